@@ -3,20 +3,32 @@ package assert
 import (
 	"github.com/dianpeng/hi-doctor/dvar"
 	"github.com/dianpeng/hi-doctor/exec"
+
+	"sync"
 )
 
 type assertFactory struct {
 }
 
+// assertion may be evaluated by batches running in parallel
 type AssertInfo struct {
+	sync.Mutex
 	YesFail int
 	NoFail  int
 }
 
 func (a *AssertInfo) IsOK() bool {
+	a.Lock()
+	defer a.Unlock()
 	return a.YesFail == 0 && a.NoFail == 0
 }
 
+func (a *AssertInfo) incYesFail() {
+	a.Lock()
+	defer a.Unlock()
+	a.YesFail++
+}
+
 func (a *assertFactory) Create(e *exec.Executor) exec.Extension {
 	b := e.Blackboard
 	info := &AssertInfo{}
@@ -27,7 +39,7 @@ func (a *assertFactory) Create(e *exec.Executor) exec.Extension {
 	lib["Yes"] = func(x interface{}) bool {
 		v := dvar.NewInterfaceVal(x)
 		if !v.Boolean() {
-			info.YesFail++
+			info.incYesFail()
 			return false
 		}
 		return true
@@ -36,7 +48,7 @@ func (a *assertFactory) Create(e *exec.Executor) exec.Extension {
 	lib["No"] = func(x interface{}) bool {
 		v := dvar.NewInterfaceVal(x)
 		if v.Boolean() {
-			info.YesFail++
+			info.incYesFail()
 			return false
 		}
 		return true
@@ -1,6 +1,7 @@
 package builtin
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/dianpeng/hi-doctor/check"
@@ -44,12 +45,15 @@ func (c *codeTaskTemplate) GenTask(env *dvar.EvalEnv) (task.TaskList, error) {
 	}, nil
 }
 
-func (c *codeTask) Prepare(env *dvar.EvalEnv) error {
+func (c *codeTask) Prepare(_ context.Context, env *dvar.EvalEnv) error {
 	return nil
 }
 
-func (c *codeTask) Run(env *dvar.EvalEnv) error {
+func (c *codeTask) Run(ctx context.Context, env *dvar.EvalEnv) error {
 	for i, code := range c.t.code {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("code task statement[%d] aborted %s", i, err)
+		}
 		if _, err := code.Value(env); err != nil {
 			return fmt.Errorf("code task statement[%d] execution error %s", i, err)
 		}
@@ -0,0 +1,487 @@
+package builtin
+
+import (
+	"github.com/dianpeng/hi-doctor/check"
+	"github.com/dianpeng/hi-doctor/dvar"
+	"github.com/dianpeng/hi-doctor/spec"
+	"github.com/dianpeng/hi-doctor/task"
+	"github.com/dianpeng/hi-doctor/util"
+
+	"github.com/mitchellh/mapstructure"
+	"golang.org/x/net/dns/dnsmessage"
+
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+)
+
+// DNS task, send a single question to the resolver, or to the target's ip
+// when no resolver is specified, and record what comes back. Each record type
+// listed in the option results in one query, ie one historical result
+
+type dnsTaskTemplate struct {
+	name      string
+	timeout   int64
+	server    dvar.DVar
+	port      uint16
+	domain    dvar.DVar
+	record    []dnsmessage.Type
+	transport string
+	recursion bool
+	check     check.Check
+}
+
+type dnsTask struct {
+	t      *dnsTaskTemplate
+	server string
+	domain string
+}
+
+type dnsTaskDefine struct {
+	Name      string   `mapstructure:"name"`
+	Timeout   int64    `mapstructure:"timeout"`
+	Server    string   `mapstructure:"server"`
+	Port      uint16   `mapstructure:"port"`
+	Domain    string   `mapstructure:"domain"`
+	Record    []string `mapstructure:"record"`
+	Transport string   `mapstructure:"transport"`
+	Recursion bool     `mapstructure:"recursion"`
+}
+
+type dnsTaskRecord struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	TTL      uint32 `json:"ttl"`
+	Value    string `json:"value"`
+	Priority uint16 `json:"priority"` // MX preference or SRV priority
+	Weight   uint16 `json:"weight"`   // SRV only
+	Port     uint16 `json:"port"`     // SRV only
+}
+
+type dnsTaskResult struct {
+	Timestamp          int64           `json:"timestamp"`
+	RT                 int64           `json:"rt"`
+	Server             string          `json:"server"`
+	Domain             string          `json:"domain"`
+	Record             string          `json:"record"`
+	Transport          string          `json:"transport"`
+	OK                 bool            `json:"ok"`
+	Error              string          `json:"error"`
+	Rcode              string          `json:"rcode"`
+	Authoritative      bool            `json:"authoritative"`
+	Truncated          bool            `json:"truncated"`
+	RecursionAvailable bool            `json:"recursion_available"`
+	Answer             []dnsTaskRecord `json:"answer"`
+	AnswerValue        []string        `json:"answer_value"`
+	AnswerCount        int             `json:"answer_count"`
+	MinTTL             uint32          `json:"min_ttl"`
+}
+
+var dnsRecordType = map[string]dnsmessage.Type{
+	"A":     dnsmessage.TypeA,
+	"AAAA":  dnsmessage.TypeAAAA,
+	"CNAME": dnsmessage.TypeCNAME,
+	"MX":    dnsmessage.TypeMX,
+	"TXT":   dnsmessage.TypeTXT,
+	"SRV":   dnsmessage.TypeSRV,
+	"NS":    dnsmessage.TypeNS,
+	"SOA":   dnsmessage.TypeSOA,
+	"PTR":   dnsmessage.TypePTR,
+}
+
+func dnsRecordTypeName(t dnsmessage.Type) string {
+	for k, v := range dnsRecordType {
+		if v == t {
+			return k
+		}
+	}
+	return fmt.Sprintf("TYPE%d", uint16(t))
+}
+
+func dnsRcodeName(r dnsmessage.RCode) string {
+	switch r {
+	case dnsmessage.RCodeSuccess:
+		return "NOERROR"
+	case dnsmessage.RCodeFormatError:
+		return "FORMERR"
+	case dnsmessage.RCodeServerFailure:
+		return "SERVFAIL"
+	case dnsmessage.RCodeNameError:
+		return "NXDOMAIN"
+	case dnsmessage.RCodeNotImplemented:
+		return "NOTIMP"
+	case dnsmessage.RCodeRefused:
+		return "REFUSED"
+	default:
+		return fmt.Sprintf("RCODE%d", uint16(r))
+	}
+}
+
+type dnsTaskFactory struct{}
+
+func (f *dnsTaskFactory) SanityCheck(spec.TaskOption) error {
+	return nil
+}
+
+func (f *dnsTaskFactory) Compile(
+	x spec.TaskOption,
+	c *spec.Check,
+) (task.TaskPlanner, error) {
+	opt := &dnsTaskDefine{
+		Timeout:   5,
+		Port:      53,
+		Transport: "udp",
+		Recursion: true,
+	}
+	err := mapstructure.Decode(x, opt)
+	if err != nil {
+		return nil, fmt.Errorf("dns_task, invalid option input: %s", err)
+	}
+
+	out := &dnsTaskTemplate{
+		name:      opt.Name,
+		timeout:   opt.Timeout,
+		port:      opt.Port,
+		recursion: opt.Recursion,
+	}
+
+	switch opt.Transport {
+	case "udp", "tcp":
+		out.transport = opt.Transport
+		break
+	default:
+		return nil, fmt.Errorf("dns_task, unknown transport %s", opt.Transport)
+	}
+
+	if dv, err := dvar.NewDVarStringContext(opt.Server); err != nil {
+		return nil, fmt.Errorf("dns_task.Server compile failed: %s", err)
+	} else {
+		out.server = dv
+	}
+
+	if dv, err := dvar.NewDVarStringContext(opt.Domain); err != nil {
+		return nil, fmt.Errorf("dns_task.Domain compile failed: %s", err)
+	} else {
+		out.domain = dv
+	}
+
+	if len(opt.Record) == 0 {
+		out.record = []dnsmessage.Type{dnsmessage.TypeA}
+	}
+	for _, r := range opt.Record {
+		if ty, ok := dnsRecordType[strings.ToUpper(r)]; !ok {
+			return nil, fmt.Errorf("dns_task, unknown record type %s", r)
+		} else {
+			out.record = append(out.record, ty)
+		}
+	}
+
+	if ck, err := check.CompileCheck(c); err != nil {
+		return nil, fmt.Errorf("dns_task, check compilation fail: %s", err)
+	} else {
+		out.check = ck
+	}
+	return out, nil
+}
+
+func (f *dnsTaskTemplate) Description() string {
+	return fmt.Sprintf("dns_task(%s)", f.name)
+}
+
+func (f *dnsTaskTemplate) GenTask(env *dvar.EvalEnv) (task.TaskList, error) {
+	return task.TaskList{
+		&dnsTask{
+			t: f,
+		},
+	}, nil
+}
+
+func (t *dnsTask) name() string {
+	return t.t.name
+}
+
+func (t *dnsTask) Prepare(_ context.Context, env *dvar.EvalEnv) error {
+	// 1) resolver specified in the task takes priority, otherwise the target's
+	//    ip is treated as the server to query against
+	if vv, err := t.t.server.Value(env); err != nil {
+		return fmt.Errorf("dns_task(%s).Server execution failed: %s", t.name(), err)
+	} else if server := vv.String(); server != "" {
+		t.server = server
+	} else if dv, ok := env.Get("target", "ip"); ok {
+		t.server = dv.String()
+	} else {
+		return fmt.Errorf(
+			"dns_task(%s) does not have server define, either server/target.ip should be defined",
+			t.name(),
+		)
+	}
+
+	// 2) the domain name to query, fallback to the target's hostname
+	if vv, err := t.t.domain.Value(env); err != nil {
+		return fmt.Errorf("dns_task(%s).Domain execution failed: %s", t.name(), err)
+	} else if domain := vv.String(); domain != "" {
+		t.domain = domain
+	} else if dv, ok := env.Get("target", "hostname"); ok {
+		t.domain = dv.String()
+	} else {
+		return fmt.Errorf(
+			"dns_task(%s) does not have domain define, either domain/target.hostname should be defined",
+			t.name(),
+		)
+	}
+
+	if !strings.HasSuffix(t.domain, ".") {
+		t.domain = t.domain + "."
+	}
+	return nil
+}
+
+func (t *dnsTask) Description() string {
+	return fmt.Sprintf("dns_task[%s]", t.name())
+}
+
+func (t *dnsTask) serverAddress() string {
+	if _, _, err := net.SplitHostPort(t.server); err == nil {
+		return t.server
+	}
+	return net.JoinHostPort(t.server, fmt.Sprintf("%d", t.t.port))
+}
+
+func (t *dnsTask) buildQuery(qtype dnsmessage.Type) (uint16, []byte, error) {
+	name, err := dnsmessage.NewName(t.domain)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	id := uint16(rand.Intn(65536))
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:               id,
+			RecursionDesired: t.t.recursion,
+		},
+		Questions: []dnsmessage.Question{
+			{
+				Name:  name,
+				Type:  qtype,
+				Class: dnsmessage.ClassINET,
+			},
+		},
+	}
+	data, err := msg.Pack()
+	return id, data, err
+}
+
+func (t *dnsTask) exchangeUdp(
+	ctx context.Context,
+	addr string,
+	id uint16,
+	query []byte,
+	deadline time.Time,
+) (*dnsmessage.Message, error) {
+	d := net.Dialer{Deadline: deadline}
+	conn, err := d.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer closeOnDone(ctx, conn)()
+	conn.SetDeadline(deadline)
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		msg := &dnsmessage.Message{}
+		if err := msg.Unpack(buf[:n]); err != nil {
+			return nil, err
+		}
+
+		// stale or spoofed response, keep waiting until the deadline
+		if msg.Header.ID != id {
+			continue
+		}
+		return msg, nil
+	}
+}
+
+func (t *dnsTask) exchangeTcp(
+	ctx context.Context,
+	addr string,
+	id uint16,
+	query []byte,
+	deadline time.Time,
+) (*dnsmessage.Message, error) {
+	d := net.Dialer{Deadline: deadline}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer closeOnDone(ctx, conn)()
+	conn.SetDeadline(deadline)
+
+	// TCP message is prefixed with 2 bytes length, RFC1035 4.2.2
+	req := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(req, uint16(len(query)))
+	copy(req[2:], query)
+	if _, err := conn.Write(req); err != nil {
+		return nil, err
+	}
+
+	var sz [2]byte
+	if _, err := io.ReadFull(conn, sz[:]); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(sz[:]))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+
+	msg := &dnsmessage.Message{}
+	if err := msg.Unpack(buf); err != nil {
+		return nil, err
+	}
+	if msg.Header.ID != id {
+		return nil, fmt.Errorf("response id mismatch")
+	}
+	return msg, nil
+}
+
+func dnsResourceToRecord(r *dnsmessage.Resource) dnsTaskRecord {
+	out := dnsTaskRecord{
+		Name: r.Header.Name.String(),
+		Type: dnsRecordTypeName(r.Header.Type),
+		TTL:  r.Header.TTL,
+	}
+
+	switch body := r.Body.(type) {
+	case *dnsmessage.AResource:
+		out.Value = net.IP(body.A[:]).String()
+	case *dnsmessage.AAAAResource:
+		out.Value = net.IP(body.AAAA[:]).String()
+	case *dnsmessage.CNAMEResource:
+		out.Value = body.CNAME.String()
+	case *dnsmessage.MXResource:
+		out.Value = body.MX.String()
+		out.Priority = body.Pref
+	case *dnsmessage.TXTResource:
+		out.Value = strings.Join(body.TXT, "")
+	case *dnsmessage.SRVResource:
+		out.Value = body.Target.String()
+		out.Priority = body.Priority
+		out.Weight = body.Weight
+		out.Port = body.Port
+	case *dnsmessage.NSResource:
+		out.Value = body.NS.String()
+	case *dnsmessage.PTRResource:
+		out.Value = body.PTR.String()
+	case *dnsmessage.SOAResource:
+		out.Value = fmt.Sprintf("%s %s %d", body.NS.String(), body.MBox.String(), body.Serial)
+	default:
+		break
+	}
+	return out
+}
+
+func (t *dnsTask) runDnsTask(
+	ctx context.Context,
+	env *dvar.EvalEnv,
+	qtype dnsmessage.Type,
+) *dnsTaskResult {
+	addr := t.serverAddress()
+	stat := &dnsTaskResult{
+		Server:      addr,
+		Domain:      t.domain,
+		Record:      dnsRecordTypeName(qtype),
+		Transport:   t.t.transport,
+		Answer:      []dnsTaskRecord{},
+		AnswerValue: []string{},
+	}
+
+	id, query, err := t.buildQuery(qtype)
+	if err != nil {
+		stat.Error = fmt.Sprintf("%s", err)
+		stat.OK = false
+		return stat
+	}
+
+	start := time.Now()
+	deadline := start.Add(time.Duration(t.t.timeout) * time.Second)
+
+	var msg *dnsmessage.Message
+	if t.t.transport == "tcp" {
+		msg, err = t.exchangeTcp(ctx, addr, id, query, deadline)
+	} else {
+		msg, err = t.exchangeUdp(ctx, addr, id, query, deadline)
+
+		// truncated answer, retry with tcp as what a stub resolver does
+		if err == nil && msg.Header.Truncated {
+			stat.Truncated = true
+			stat.Transport = "tcp"
+			msg, err = t.exchangeTcp(ctx, addr, id, query, deadline)
+		}
+	}
+	end := time.Now()
+
+	stat.Timestamp = start.UnixMilli()
+	stat.RT = end.Sub(start).Milliseconds()
+
+	if err != nil {
+		stat.Error = fmt.Sprintf("%s", err)
+		stat.OK = false
+		return stat
+	}
+
+	stat.OK = true
+	stat.Rcode = dnsRcodeName(msg.Header.RCode)
+	stat.Authoritative = msg.Header.Authoritative
+	stat.RecursionAvailable = msg.Header.RecursionAvailable
+	stat.Truncated = stat.Truncated || msg.Header.Truncated
+
+	for i := range msg.Answers {
+		r := dnsResourceToRecord(&msg.Answers[i])
+		if i == 0 || r.TTL < stat.MinTTL {
+			stat.MinTTL = r.TTL
+		}
+		stat.Answer = append(stat.Answer, r)
+		stat.AnswerValue = append(stat.AnswerValue, r.Value)
+	}
+	stat.AnswerCount = len(stat.Answer)
+	return stat
+}
+
+func (t *dnsTask) Run(ctx context.Context, env *dvar.EvalEnv) error {
+	for _, qtype := range t.t.record {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// run the dns query
+		stat := util.ToMapInterface(t.runDnsTask(ctx, env, qtype))
+
+		// record the result
+		env.RecordHistoricalResult(
+			"dns",
+			stat,
+		)
+
+		// run the check
+		if err := t.t.check.Run(env); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func init() {
+	task.RegisterTaskFactory("dns", &dnsTaskFactory{})
+}
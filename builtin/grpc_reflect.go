@@ -0,0 +1,269 @@
+package builtin
+
+import (
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// helper functions for calling an arbitrary unary method with a JSON request.
+// The method's descriptor is resolved from the server reflection service, the
+// v1 service is tried first then the v1alpha one, both share the same message.
+// Each reflection request is sent as its own stream, which is allowed since
+// the stream carries a single request and the server replies one for each.
+
+var grpcReflectionMethod = []string{
+	"/grpc.reflection.v1.ServerReflection/ServerReflectionInfo",
+	"/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo",
+}
+
+const (
+	// ServerReflectionRequest
+	grpcReflectionFileByFilename       = 3
+	grpcReflectionFileContainingSymbol = 4
+
+	// ServerReflectionResponse
+	grpcReflectionFileDescriptorResponse = 4
+	grpcReflectionErrorResponse          = 7
+
+	// max # of files fetched to resolve the dependencies
+	grpcReflectionMaxFile = 64
+)
+
+type grpcReflection struct {
+	client *grpcClient
+	method string // reflection method supported by the server, found on first use
+	files  map[string]*descriptorpb.FileDescriptorProto
+	fetch  int
+}
+
+func newGrpcReflection(c *grpcClient) *grpcReflection {
+	return &grpcReflection{
+		client: c,
+		files:  make(map[string]*descriptorpb.FileDescriptorProto),
+	}
+}
+
+// send a reflection request and add the files in the reply
+func (r *grpcReflection) request(
+	ctx context.Context,
+	field protowire.Number,
+	name string,
+) error {
+	r.fetch++
+	if r.fetch > grpcReflectionMaxFile {
+		return fmt.Errorf("reflection, too many files to resolve")
+	}
+
+	req := protowire.AppendTag(nil, field, protowire.BytesType)
+	req = protowire.AppendString(req, name)
+
+	method := grpcReflectionMethod
+	if r.method != "" {
+		method = []string{r.method}
+	}
+
+	var reply *grpcReply
+	for _, m := range method {
+		if rr, err := r.client.call(ctx, m, req); err != nil {
+			return fmt.Errorf("reflection failed: %s", err)
+		} else {
+			reply = rr
+		}
+		if reply.code != grpcCodeUnimplemented {
+			r.method = m
+			break
+		}
+	}
+	if reply.code != 0 {
+		return fmt.Errorf("reflection failed, %s: %s", grpcCodeString(reply.code), reply.message)
+	}
+	if len(reply.data) == 0 {
+		return fmt.Errorf("reflection failed, empty reply")
+	}
+
+	files := [][]byte{}
+	errCode := uint64(0)
+	errMsg := ""
+	var walkErr error
+
+	err := protoWalk(reply.data[0], func(num protowire.Number, v []byte, _ uint64) {
+		switch num {
+		case grpcReflectionFileDescriptorResponse:
+			// FileDescriptorResponse { repeated bytes file_descriptor_proto = 1; }
+			if err := protoWalk(v, func(num protowire.Number, v []byte, _ uint64) {
+				if num == 1 {
+					files = append(files, v)
+				}
+			}); err != nil {
+				walkErr = err
+			}
+		case grpcReflectionErrorResponse:
+			// ErrorResponse { int32 error_code = 1; string error_message = 2; }
+			if err := protoWalk(v, func(num protowire.Number, v []byte, n uint64) {
+				switch num {
+				case 1:
+					errCode = n
+				case 2:
+					errMsg = string(v)
+				}
+			}); err != nil {
+				walkErr = err
+			}
+			if errCode == 0 {
+				errCode = grpcCodeUnknown
+			}
+		}
+	})
+	if err == nil {
+		err = walkErr
+	}
+	if err != nil {
+		return fmt.Errorf("reflection failed, invalid reply: %s", err)
+	}
+	if errCode != 0 {
+		return fmt.Errorf("reflection of %s failed, %s: %s", name, grpcCodeString(int(errCode)), errMsg)
+	}
+
+	for _, data := range files {
+		fd := &descriptorpb.FileDescriptorProto{}
+		if err := proto.Unmarshal(data, fd); err != nil {
+			return fmt.Errorf("reflection failed, invalid file descriptor: %s", err)
+		}
+		r.files[fd.GetName()] = fd
+	}
+	return nil
+}
+
+// dependencies not fetched yet
+func (r *grpcReflection) missing() []string {
+	out := []string{}
+	for _, fd := range r.files {
+		for _, dep := range fd.GetDependency() {
+			if _, ok := r.files[dep]; !ok {
+				out = append(out, dep)
+			}
+		}
+	}
+	return out
+}
+
+// resolve the method, in form of package.Service/Method
+func (r *grpcReflection) resolve(
+	ctx context.Context,
+	method string,
+) (protoreflect.MethodDescriptor, error) {
+	method = strings.TrimPrefix(method, "/")
+	idx := strings.LastIndex(method, "/")
+	if idx <= 0 || idx == len(method)-1 {
+		return nil, fmt.Errorf("method %s is not in form of package.Service/Method", method)
+	}
+	service, name := method[:idx], method[idx+1:]
+
+	if err := r.request(ctx, grpcReflectionFileContainingSymbol, service); err != nil {
+		return nil, err
+	}
+
+	// the well known files linked in are used directly, otherwise fetched
+	for missing := r.missing(); len(missing) != 0; missing = r.missing() {
+		for _, dep := range missing {
+			if _, ok := r.files[dep]; ok {
+				continue
+			}
+			if fd, err := protoregistry.GlobalFiles.FindFileByPath(dep); err == nil {
+				r.files[dep] = protodesc.ToFileDescriptorProto(fd)
+				continue
+			}
+			if err := r.request(ctx, grpcReflectionFileByFilename, dep); err != nil {
+				return nil, err
+			}
+			if _, ok := r.files[dep]; !ok {
+				return nil, fmt.Errorf("reflection does not return file %s", dep)
+			}
+		}
+	}
+
+	set := &descriptorpb.FileDescriptorSet{}
+	for _, fd := range r.files {
+		set.File = append(set.File, fd)
+	}
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, fmt.Errorf("reflection, invalid file descriptor: %s", err)
+	}
+
+	d, err := files.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil, fmt.Errorf("service %s is not found: %s", service, err)
+	}
+	sd, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a service", service)
+	}
+	md := sd.Methods().ByName(protoreflect.Name(name))
+	if md == nil {
+		return nil, fmt.Errorf("method %s is not found in service %s", name, service)
+	}
+	if md.IsStreamingClient() || md.IsStreamingServer() {
+		return nil, fmt.Errorf("method %s is streaming, only unary method is supported", method)
+	}
+	return md, nil
+}
+
+// call the unary method with the request in JSON, the reply message is
+// returned in compact JSON, empty if the call does not succeed
+func (c *grpcClient) invoke(
+	ctx context.Context,
+	md protoreflect.MethodDescriptor,
+	request string,
+) (*grpcReply, string, error) {
+	in := dynamicpb.NewMessage(md.Input())
+	if strings.TrimSpace(request) != "" {
+		if err := protojson.Unmarshal([]byte(request), in); err != nil {
+			return nil, "", fmt.Errorf("invalid request of %s: %s", md.Input().FullName(), err)
+		}
+	}
+	data, err := proto.Marshal(in)
+	if err != nil {
+		return nil, "", err
+	}
+
+	method := fmt.Sprintf("/%s/%s", md.Parent().FullName(), md.Name())
+	reply, err := c.call(ctx, method, data)
+	if err != nil {
+		return nil, "", err
+	}
+	if reply.code != 0 || len(reply.data) == 0 {
+		return reply, "", nil
+	}
+
+	out := dynamicpb.NewMessage(md.Output())
+	if err := proto.Unmarshal(reply.data[0], out); err != nil {
+		return nil, "", fmt.Errorf("invalid reply of %s: %s", md.Output().FullName(), err)
+	}
+	js, err := protojson.MarshalOptions{
+		UseProtoNames:   true,
+		EmitUnpopulated: true,
+	}.Marshal(out)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// protojson deliberately randomizes the whitespace
+	buf := &bytes.Buffer{}
+	if err := json.Compact(buf, js); err != nil {
+		return nil, "", err
+	}
+	return reply, buf.String(), nil
+}
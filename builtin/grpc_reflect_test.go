@@ -0,0 +1,62 @@
+package builtin
+
+import (
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGrpcReflectionFallback(t *testing.T) {
+	v1, v1alpha := grpcReflectionMethod[0], grpcReflectionMethod[1]
+
+	for _, c := range []struct {
+		name string
+		v1   bool
+
+		// the method cached and the # of calls of each reflection method, the
+		// symbol and its dependency are fetched by 2 requests
+		method      string
+		v1Call      int
+		v1alphaCall int
+	}{
+		// v1 is only tried by the first request
+		{"v1alpha", false, v1alpha, 1, 2},
+		{"v1", true, v1, 2, 0},
+	} {
+		common, echo := testGrpcFiles()
+		handler := &testGrpcServer{
+			t:      t,
+			common: common,
+			echo:   echo,
+			v1:     c.v1,
+		}
+		server := httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+
+		client := newGrpcClient(server.Listener.Addr().String(), nil, 5*time.Second)
+		r := newGrpcReflection(client)
+		md, err := r.resolve(context.Background(), "test.Echo/Say")
+		client.close()
+		server.Close()
+
+		if err != nil {
+			t.Errorf("%s, resolve failed: %s", c.name, err)
+			continue
+		}
+		if got := string(md.FullName()); got != "test.Echo.Say" {
+			t.Errorf("%s, method: got %q, want %q", c.name, got, "test.Echo.Say")
+		}
+		if r.method != c.method {
+			t.Errorf("%s, cached reflection method: got %q, want %q", c.name, r.method, c.method)
+		}
+		if got := handler.count(v1); got != c.v1Call {
+			t.Errorf("%s, # of v1 calls: got %d, want %d", c.name, got, c.v1Call)
+		}
+		if got := handler.count(v1alpha); got != c.v1alphaCall {
+			t.Errorf("%s, # of v1alpha calls: got %d, want %d", c.name, got, c.v1alphaCall)
+		}
+	}
+}
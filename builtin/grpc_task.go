@@ -0,0 +1,362 @@
+package builtin
+
+import (
+	"github.com/dianpeng/hi-doctor/check"
+	"github.com/dianpeng/hi-doctor/dvar"
+	"github.com/dianpeng/hi-doctor/spec"
+	"github.com/dianpeng/hi-doctor/task"
+	"github.com/dianpeng/hi-doctor/util"
+
+	"github.com/mitchellh/mapstructure"
+
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// gRPC task, by default performs the standard grpc.health.v1.Health/Check
+// against target.ip:port. When method is specified, the unary method is called
+// instead with the JSON request, its descriptor is resolved via the server
+// reflection service.
+//
+//   - type: grpc
+//     option:
+//       service: my.Service             # health check service, empty is overall
+//       method: my.Service/Get          # optional, reflection based call
+//       request: '{"id": 1}'            # JSON request of method
+//       metadata:
+//         authorization: Bearer xxx
+//       tls:
+//         enable: true
+//         verify: true
+
+const grpcHealthMethod = "/grpc.health.v1.Health/Check"
+
+type grpcTaskDefine struct {
+	Name      string            `mapstructure:"name"`
+	Timeout   int64             `mapstructure:"timeout"` // deadline, in seconds
+	Port      uint16            `mapstructure:"port"`
+	Service   string            `mapstructure:"service"`
+	Method    string            `mapstructure:"method"`
+	Request   string            `mapstructure:"request"`
+	Authority string            `mapstructure:"authority"`
+	Metadata  map[string]string `mapstructure:"metadata"`
+	TLS       tlsClientDefine   `mapstructure:"tls"`
+}
+
+type grpcTaskTemplate struct {
+	name      string
+	timeout   time.Duration
+	port      uint16
+	service   dvar.DVar
+	method    string
+	request   dvar.DVar
+	authority dvar.DVar
+	metadata  map[string]dvar.DVar
+	tlsEnable bool
+	tlsVerify bool
+	tlsSNI    dvar.DVar
+	tlsConfig *tls.Config
+	check     check.Check
+}
+
+type grpcTask struct {
+	t         *grpcTaskTemplate
+	address   string
+	port      uint16
+	service   string
+	request   string
+	authority string
+	metadata  http.Header
+	sni       string
+}
+
+type grpcTaskResult struct {
+	Timestamp int64  `json:"timestamp"`
+	RT        int64  `json:"rt"`
+	Address   string `json:"address"`
+	Port      uint16 `json:"port"`
+	Method    string `json:"method"`
+	OK        bool   `json:"ok"`
+	Error     string `json:"error"`
+
+	// gRPC status, code is -1 when the call is not performed
+	Code     int    `json:"code"`
+	CodeName string `json:"code_name"`
+	Message  string `json:"message"`
+
+	// health check only, SERVING, NOT_SERVING, SERVICE_UNKNOWN or UNKNOWN
+	Status string `json:"status"`
+
+	// method call only, the reply in JSON and the parsed one
+	Response     string      `json:"response"`
+	ResponseData interface{} `json:"response_data"`
+
+	Header  http.Header `json:"header"`
+	Trailer http.Header `json:"trailer"`
+}
+
+type grpcTaskFactory struct{}
+
+func (f *grpcTaskFactory) SanityCheck(spec.TaskOption) error {
+	return nil
+}
+
+func (f *grpcTaskFactory) Compile(
+	x spec.TaskOption,
+	c *spec.Check,
+) (task.TaskPlanner, error) {
+	opt := &grpcTaskDefine{
+		Timeout:  30,
+		Metadata: make(map[string]string),
+	}
+	if err := mapstructure.Decode(x, opt); err != nil {
+		return nil, fmt.Errorf("grpc_task, invalid option input: %s", err)
+	}
+	if opt.Timeout <= 0 {
+		return nil, fmt.Errorf("grpc_task, timeout must be positive")
+	}
+	if opt.Method != "" && opt.Service != "" {
+		return nil, fmt.Errorf("grpc_task, service is for health check and cannot be used with method")
+	}
+
+	out := &grpcTaskTemplate{
+		name:      opt.Name,
+		timeout:   time.Duration(opt.Timeout) * time.Second,
+		port:      opt.Port,
+		method:    opt.Method,
+		metadata:  make(map[string]dvar.DVar),
+		tlsEnable: opt.TLS.Enable,
+		tlsVerify: opt.TLS.Verify,
+	}
+
+	if dv, err := dvar.NewDVarStringContext(opt.Service); err != nil {
+		return nil, fmt.Errorf("grpc_task, service compile failed: %s", err)
+	} else {
+		out.service = dv
+	}
+	if dv, err := dvar.NewDVarStringContext(opt.Request); err != nil {
+		return nil, fmt.Errorf("grpc_task, request compile failed: %s", err)
+	} else {
+		out.request = dv
+	}
+	if dv, err := dvar.NewDVarStringContext(opt.Authority); err != nil {
+		return nil, fmt.Errorf("grpc_task, authority compile failed: %s", err)
+	} else {
+		out.authority = dv
+	}
+	for k, v := range opt.Metadata {
+		if dv, err := dvar.NewDVarStringContext(v); err != nil {
+			return nil, fmt.Errorf("grpc_task, metadata[%s] compile failed: %s", k, err)
+		} else {
+			out.metadata[k] = dv
+		}
+	}
+
+	if dv, err := dvar.NewDVarStringContext(opt.TLS.Option.SNI); err != nil {
+		return nil, fmt.Errorf("grpc_task, tls.sni compile failed: %s", err)
+	} else {
+		out.tlsSNI = dv
+	}
+	if cfg, err := compileTLSConfig("grpc_task", &opt.TLS.Option); err != nil {
+		return nil, err
+	} else {
+		out.tlsConfig = cfg
+	}
+
+	if ck, err := check.CompileCheck(c); err != nil {
+		return nil, fmt.Errorf("grpc_task, check compilation fail: %s", err)
+	} else {
+		out.check = ck
+	}
+	return out, nil
+}
+
+func (f *grpcTaskTemplate) Description() string {
+	return fmt.Sprintf("grpc_task(%s)", f.name)
+}
+
+func (f *grpcTaskTemplate) GenTask(env *dvar.EvalEnv) (task.TaskList, error) {
+	return task.TaskList{
+		&grpcTask{
+			t: f,
+		},
+	}, nil
+}
+
+func (t *grpcTask) name() string {
+	return t.t.name
+}
+
+func (t *grpcTask) Prepare(_ context.Context, env *dvar.EvalEnv) error {
+	jobName := fmt.Sprintf("grpc_task(%s)", t.name())
+
+	if addr, err := targetAddress(jobName, env); err != nil {
+		return err
+	} else {
+		t.address = addr
+	}
+
+	// port, the task's port takes priority, then the target's
+	if t.t.port != 0 {
+		t.port = t.t.port
+	} else if port, err := targetPort(jobName, env, 0); err != nil {
+		return err
+	} else {
+		t.port = port
+	}
+
+	if vv, err := t.t.service.Value(env); err != nil {
+		return fmt.Errorf("%s service execution failed: %s", jobName, err)
+	} else {
+		t.service = vv.String()
+	}
+	if vv, err := t.t.request.Value(env); err != nil {
+		return fmt.Errorf("%s request execution failed: %s", jobName, err)
+	} else {
+		t.request = vv.String()
+	}
+	if vv, err := t.t.authority.Value(env); err != nil {
+		return fmt.Errorf("%s authority execution failed: %s", jobName, err)
+	} else {
+		t.authority = vv.String()
+	}
+
+	// sni, fallback to the target's hostname if any
+	if vv, err := t.t.tlsSNI.Value(env); err != nil {
+		return fmt.Errorf("%s tls.sni execution failed: %s", jobName, err)
+	} else if sni := vv.String(); sni != "" {
+		t.sni = sni
+	} else {
+		hostV := env.GetDef("target", "hostname", dvar.NewStringVal(""))
+		t.sni = hostV.String()
+	}
+
+	t.metadata = make(http.Header)
+	for k, dv := range t.t.metadata {
+		if vv, err := dv.Value(env); err != nil {
+			return fmt.Errorf("%s metadata[%s] execution failed: %s", jobName, k, err)
+		} else {
+			t.metadata.Add(strings.ToLower(k), vv.String())
+		}
+	}
+	return nil
+}
+
+func (t *grpcTask) Description() string {
+	return fmt.Sprintf("grpc_task[%s]", t.name())
+}
+
+func (t *grpcTask) runGrpcTask(ctx context.Context) *grpcTaskResult {
+	stat := &grpcTaskResult{
+		Address: t.address,
+		Port:    t.port,
+		Method:  grpcHealthMethod,
+		Code:    -1,
+	}
+	if t.t.method != "" {
+		stat.Method = "/" + strings.TrimPrefix(t.t.method, "/")
+	}
+
+	start := time.Now()
+	stat.Timestamp = start.UnixMilli()
+	defer func() {
+		stat.RT = time.Since(start).Milliseconds()
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, t.t.timeout)
+	defer cancel()
+
+	var cfg *tls.Config
+	if t.t.tlsEnable {
+		cfg = tlsClientConfig(t.t.tlsConfig, t.sni, t.t.tlsVerify)
+	}
+	client := newGrpcClient(
+		net.JoinHostPort(t.address, fmt.Sprintf("%d", t.port)),
+		cfg,
+		t.t.timeout,
+	)
+	defer client.close()
+	client.authority = t.authority
+	client.metadata = t.metadata
+
+	var reply *grpcReply
+	if t.t.method == "" {
+		if r, err := client.call(ctx, grpcHealthMethod, grpcHealthRequest(t.service)); err != nil {
+			stat.Error = fmt.Sprintf("%s", err)
+			return stat
+		} else {
+			reply = r
+		}
+		if reply.code == 0 {
+			if len(reply.data) == 0 {
+				stat.Error = "health check replies nothing"
+			} else if status, err := grpcHealthStatus(reply.data[0]); err != nil {
+				stat.Error = fmt.Sprintf("invalid health check reply: %s", err)
+			} else {
+				stat.Status = status
+			}
+		}
+	} else {
+		md, err := newGrpcReflection(client).resolve(ctx, t.t.method)
+		if err != nil {
+			stat.Error = fmt.Sprintf("%s", err)
+			return stat
+		}
+		r, response, err := client.invoke(ctx, md, t.request)
+		if err != nil {
+			stat.Error = fmt.Sprintf("%s", err)
+			return stat
+		}
+		reply = r
+		if response != "" {
+			stat.Response = response
+			json.Unmarshal([]byte(response), &stat.ResponseData)
+		}
+	}
+
+	stat.Code = reply.code
+	stat.CodeName = grpcCodeString(reply.code)
+	stat.Message = reply.message
+	stat.Header = reply.header
+	stat.Trailer = reply.trailer
+
+	switch {
+	case stat.Error != "":
+		break
+	case reply.code != 0:
+		stat.Error = fmt.Sprintf("grpc status %s: %s", stat.CodeName, stat.Message)
+	case t.t.method == "" && stat.Status != "SERVING":
+		stat.Error = fmt.Sprintf("health status is %s", stat.Status)
+	default:
+		stat.OK = true
+	}
+	return stat
+}
+
+func (t *grpcTask) Run(ctx context.Context, env *dvar.EvalEnv) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// run the grpc call
+	stat := util.ToMapInterface(t.runGrpcTask(ctx))
+
+	// record the result
+	env.RecordHistoricalResult(
+		"grpc",
+		stat,
+	)
+
+	// run the check
+	return t.t.check.Run(env)
+}
+
+func init() {
+	task.RegisterTaskFactory("grpc", &grpcTaskFactory{})
+}
@@ -0,0 +1,293 @@
+package builtin
+
+import (
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// test/common.proto and test/echo.proto, the reflection only returns the
+// latter for the symbol so the dependency has to be fetched by its name
+func testGrpcFiles() (*descriptorpb.FileDescriptorProto, *descriptorpb.FileDescriptorProto) {
+	str := descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()
+	i32 := descriptorpb.FieldDescriptorProto_TYPE_INT32.Enum()
+	msg := descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+	opt := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()
+
+	common := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("test/common.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("Name"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					{Name: proto.String("first"), JsonName: proto.String("first"), Number: proto.Int32(1), Type: str, Label: opt},
+				},
+			},
+		},
+	}
+	echo := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("test/echo.proto"),
+		Package:    proto.String("test"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"test/common.proto"},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("SayRequest"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					{Name: proto.String("name"), JsonName: proto.String("name"), Number: proto.Int32(1), Type: msg, TypeName: proto.String(".test.Name"), Label: opt},
+				},
+			},
+			{
+				Name: proto.String("SayReply"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					{Name: proto.String("message"), JsonName: proto.String("message"), Number: proto.Int32(1), Type: str, Label: opt},
+					{Name: proto.String("count"), JsonName: proto.String("count"), Number: proto.Int32(2), Type: i32, Label: opt},
+				},
+			},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{
+			{
+				Name: proto.String("Echo"),
+				Method: []*descriptorpb.MethodDescriptorProto{
+					{Name: proto.String("Say"), InputType: proto.String(".test.SayRequest"), OutputType: proto.String(".test.SayReply")},
+				},
+			},
+		},
+	}
+	return common, echo
+}
+
+type testGrpcServer struct {
+	t      *testing.T
+	common *descriptorpb.FileDescriptorProto
+	echo   *descriptorpb.FileDescriptorProto
+	v1     bool // whether the v1 reflection is served, otherwise Unimplemented
+
+	sync.Mutex
+	called map[string]int // path -> # of calls
+}
+
+func (s *testGrpcServer) count(path string) int {
+	s.Lock()
+	defer s.Unlock()
+	return s.called[path]
+}
+
+func testGrpcString(msg []byte, field protowire.Number) string {
+	for len(msg) > 0 {
+		num, typ, n := protowire.ConsumeTag(msg)
+		msg = msg[n:]
+		n = protowire.ConsumeFieldValue(num, typ, msg)
+		if num == field && typ == protowire.BytesType {
+			v, _ := protowire.ConsumeBytes(msg)
+			return string(v)
+		}
+		msg = msg[n:]
+	}
+	return ""
+}
+
+func testGrpcReply(w http.ResponseWriter, code int, message string, reply []byte) {
+	w.Header().Set("content-type", "application/grpc")
+	if reply == nil {
+		// trailers only
+		w.Header().Set("grpc-status", fmt.Sprintf("%d", code))
+		w.Header().Set("grpc-message", message)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.Header().Set("trailer", "grpc-status, grpc-message")
+	w.WriteHeader(http.StatusOK)
+	prefix := make([]byte, 5)
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(reply)))
+	w.Write(append(prefix, reply...))
+	w.Header().Set("grpc-status", fmt.Sprintf("%d", code))
+	w.Header().Set("grpc-message", message)
+}
+
+func (s *testGrpcServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if len(body) < 5 || r.Header.Get("content-type") != "application/grpc" || r.Header.Get("te") != "trailers" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	msg := body[5:]
+
+	s.Lock()
+	if s.called == nil {
+		s.called = make(map[string]int)
+	}
+	s.called[r.URL.Path]++
+	s.Unlock()
+
+	path := r.URL.Path
+	if s.v1 && path == grpcReflectionMethod[0] {
+		path = grpcReflectionMethod[1]
+	}
+
+	switch path {
+	case "/grpc.health.v1.Health/Check":
+		status := uint64(1)
+		switch service := testGrpcString(msg, 1); service {
+		case "":
+		case "down":
+			status = 2
+		case "secure":
+			if r.Header.Get("x-token") != "secret" {
+				testGrpcReply(w, 16, "token required", nil)
+				return
+			}
+		default:
+			testGrpcReply(w, 5, "unknown service "+service, nil)
+			return
+		}
+		reply := protowire.AppendTag(nil, 1, protowire.VarintType)
+		testGrpcReply(w, 0, "", protowire.AppendVarint(reply, status))
+
+	case "/grpc.reflection.v1.ServerReflection/ServerReflectionInfo":
+		testGrpcReply(w, 12, "", nil)
+
+	case "/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo":
+		var fd *descriptorpb.FileDescriptorProto
+		if testGrpcString(msg, 4) == "test.Echo" {
+			fd = s.echo
+		} else if testGrpcString(msg, 3) == "test/common.proto" {
+			fd = s.common
+		}
+		reply := []byte{}
+		if fd == nil {
+			e := protowire.AppendTag(nil, 1, protowire.VarintType)
+			e = protowire.AppendVarint(e, 5)
+			reply = protowire.AppendTag(reply, 7, protowire.BytesType)
+			reply = protowire.AppendBytes(reply, e)
+		} else {
+			data, _ := proto.Marshal(fd)
+			f := protowire.AppendTag(nil, 1, protowire.BytesType)
+			f = protowire.AppendBytes(f, data)
+			reply = protowire.AppendTag(reply, 4, protowire.BytesType)
+			reply = protowire.AppendBytes(reply, f)
+		}
+		testGrpcReply(w, 0, "", reply)
+
+	case "/test.Echo/Say":
+		// SayRequest { Name name = 1; }, Name { string first = 1; }
+		name := testGrpcString([]byte(testGrpcString(msg, 1)), 1)
+		reply := protowire.AppendTag(nil, 1, protowire.BytesType)
+		reply = protowire.AppendString(reply, "hello "+name)
+		reply = protowire.AppendTag(reply, 2, protowire.VarintType)
+		reply = protowire.AppendVarint(reply, 1)
+		testGrpcReply(w, 0, "", reply)
+
+	default:
+		testGrpcReply(w, 12, "unknown method", nil)
+	}
+}
+
+func TestGrpc(t *testing.T) {
+	common, echo := testGrpcFiles()
+	handler := &testGrpcServer{
+		t:      t,
+		common: common,
+		echo:   echo,
+	}
+
+	plain := httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+	defer plain.Close()
+
+	secure := httptest.NewUnstartedServer(handler)
+	secure.EnableHTTP2 = true
+	secure.StartTLS()
+	defer secure.Close()
+
+	for _, c := range []struct {
+		name     string
+		option   string
+		tls      bool
+		ok       bool
+		code     int
+		codeName string
+		message  string
+		status   string
+		response string
+	}{
+		{"health", ``, false, true, 0, "OK", "", "SERVING", ""},
+		{"down", `service: down`, false, false, 0, "OK", "", "NOT_SERVING", ""},
+		{"unknown", `service: nope`, false, false, 5, "NOT_FOUND", "unknown service nope", "", ""},
+		{"secure", "service: secure\nmetadata:\n  x-token: secret", false, true, 0, "OK", "", "SERVING", ""},
+		{"noauth", `service: secure`, false, false, 16, "UNAUTHENTICATED", "token required", "", ""},
+		{"tls", "tls:\n  enable: true", true, true, 0, "OK", "", "SERVING", ""},
+		{
+			"echo",
+			"method: test.Echo/Say\nrequest: '{\"name\": {\"first\": \"$<<target.name>>\"}}'",
+			false, true, 0, "OK", "", "", `{"message":"hello local","count":1}`,
+		},
+		{"nomethod", `method: test.Echo/Shout`, false, false, -1, "", "", "", ""},
+	} {
+		server := plain
+		if c.tls {
+			server = secure
+		}
+		tk, _ := testPrepareTask(t, "grpc", c.option, testServerTarget(t, server.Listener.Addr()))
+		stat := tk.(*grpcTask).runGrpcTask(context.Background())
+
+		if stat.OK != c.ok {
+			t.Errorf("%s, ok: got %t, want %t, error: %s", c.name, stat.OK, c.ok, stat.Error)
+		}
+		if stat.Code != c.code {
+			t.Errorf("%s, code: got %d, want %d", c.name, stat.Code, c.code)
+		}
+		if stat.CodeName != c.codeName {
+			t.Errorf("%s, code_name: got %q, want %q", c.name, stat.CodeName, c.codeName)
+		}
+		if stat.Message != c.message {
+			t.Errorf("%s, message: got %q, want %q", c.name, stat.Message, c.message)
+		}
+		if stat.Status != c.status {
+			t.Errorf("%s, status: got %q, want %q", c.name, stat.Status, c.status)
+		}
+		if stat.Response != c.response {
+			t.Errorf("%s, response: got %q, want %q", c.name, stat.Response, c.response)
+		}
+		if c.response != "" {
+			// the parsed reply is exposed as response_data
+			data, _ := stat.ResponseData.(map[string]interface{})
+			if got := data["message"]; got != "hello local" {
+				t.Errorf("%s, response_data.message: got %#v, want %q", c.name, got, "hello local")
+			}
+		}
+		if !c.ok && stat.Error == "" {
+			t.Errorf("%s, error: got empty, want non-empty", c.name)
+		}
+	}
+}
+
+func TestGrpcTaskPreparePort(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		option string
+		target map[string]interface{}
+		want   uint16
+	}{
+		{"port", ``, map[string]interface{}{"ip": "127.0.0.1", "port": 50051}, 50051},
+		{"port_list", ``, map[string]interface{}{"addr": "localhost", "port_list": []interface{}{50052, 50053}}, 50052},
+		{"option", `port: 50054`, map[string]interface{}{"ip": "127.0.0.1", "port": 50051}, 50054},
+	} {
+		tk, _ := testPrepareTask(t, "grpc", c.option, c.target)
+		if got := tk.(*grpcTask).port; got != c.want {
+			t.Errorf("%s, port: got %d, want %d", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package builtin
+
+import (
+	"golang.org/x/net/http2"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// helper functions for speaking gRPC over HTTP/2 without grpc-go. A unary call
+// is a POST carrying length prefixed messages, the reply carries the messages
+// in its body and the status in the grpc-status/grpc-message trailers, or in
+// the headers when the reply has no message at all, ie trailers only.
+
+const (
+	grpcCodeUnknown          = 2
+	grpcCodePermissionDenied = 7
+	grpcCodeUnimplemented    = 12
+	grpcCodeInternal         = 13
+	grpcCodeUnavailable      = 14
+	grpcCodeUnauthenticated  = 16
+
+	grpcMaxMessageSize = 4 << 20
+)
+
+var grpcCodeName = []string{
+	"OK",
+	"CANCELLED",
+	"UNKNOWN",
+	"INVALID_ARGUMENT",
+	"DEADLINE_EXCEEDED",
+	"NOT_FOUND",
+	"ALREADY_EXISTS",
+	"PERMISSION_DENIED",
+	"RESOURCE_EXHAUSTED",
+	"FAILED_PRECONDITION",
+	"ABORTED",
+	"OUT_OF_RANGE",
+	"UNIMPLEMENTED",
+	"INTERNAL",
+	"UNAVAILABLE",
+	"DATA_LOSS",
+	"UNAUTHENTICATED",
+}
+
+func grpcCodeString(code int) string {
+	if code >= 0 && code < len(grpcCodeName) {
+		return grpcCodeName[code]
+	}
+	return fmt.Sprintf("CODE(%d)", code)
+}
+
+// status of a reply without grpc-status, mapped from the http status code as
+// the gRPC over HTTP/2 spec suggests
+func grpcCodeFromHttpStatus(status int) int {
+	switch status {
+	case http.StatusBadRequest:
+		return grpcCodeInternal
+	case http.StatusUnauthorized:
+		return grpcCodeUnauthenticated
+	case http.StatusForbidden:
+		return grpcCodePermissionDenied
+	case http.StatusNotFound:
+		return grpcCodeUnimplemented
+	case http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return grpcCodeUnavailable
+	default:
+		return grpcCodeUnknown
+	}
+}
+
+type grpcClient struct {
+	transport *http2.Transport
+	scheme    string
+	address   string // host:port
+	authority string // :authority, empty means the address
+	metadata  http.Header
+	timeout   time.Duration
+}
+
+type grpcReply struct {
+	code    int
+	message string
+	header  http.Header
+	trailer http.Header
+	data    [][]byte
+}
+
+// tlsConfig being nil means plaintext, ie h2c with prior knowledge
+func newGrpcClient(
+	address string,
+	tlsConfig *tls.Config,
+	timeout time.Duration,
+) *grpcClient {
+	out := &grpcClient{
+		address:  address,
+		metadata: make(http.Header),
+		timeout:  timeout,
+	}
+	if tlsConfig == nil {
+		out.scheme = "http"
+		out.transport = &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				d := net.Dialer{Timeout: timeout}
+				return d.DialContext(ctx, network, addr)
+			},
+		}
+	} else {
+		out.scheme = "https"
+		out.transport = &http2.Transport{
+			TLSClientConfig: tlsConfig,
+		}
+	}
+	return out
+}
+
+func (c *grpcClient) close() {
+	c.transport.CloseIdleConnections()
+}
+
+// grpc-timeout allows at most 8 digits
+func grpcTimeout(x time.Duration) string {
+	if ms := x.Milliseconds(); ms < 100000000 {
+		return fmt.Sprintf("%dm", ms)
+	}
+	return fmt.Sprintf("%dS", int64(x.Seconds()))
+}
+
+func grpcFrame(msg []byte) []byte {
+	out := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(out[1:], uint32(len(msg)))
+	return append(out, msg...)
+}
+
+func grpcReadFrames(r io.Reader) ([][]byte, error) {
+	out := [][]byte{}
+	prefix := make([]byte, 5)
+	for {
+		if _, err := io.ReadFull(r, prefix); err == io.EOF {
+			return out, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if prefix[0] != 0 {
+			return nil, fmt.Errorf("compressed message is not supported")
+		}
+		size := binary.BigEndian.Uint32(prefix[1:])
+		if size > grpcMaxMessageSize {
+			return nil, fmt.Errorf("message size %d is too large", size)
+		}
+		msg := make([]byte, size)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			return nil, err
+		}
+		out = append(out, msg)
+	}
+}
+
+// perform a call with the messages, method is in form of /package.Service/Method.
+// The error is returned only when the call cannot be performed, the gRPC status
+// is reported in the reply
+func (c *grpcClient) call(
+	ctx context.Context,
+	method string,
+	msg ...[]byte,
+) (*grpcReply, error) {
+	body := []byte{}
+	for _, m := range msg {
+		body = append(body, grpcFrame(m)...)
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		"POST",
+		fmt.Sprintf("%s://%s%s", c.scheme, c.address, method),
+		bytes.NewReader(body),
+	)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range c.metadata {
+		req.Header[k] = v
+	}
+	req.Header.Set("content-type", "application/grpc")
+	req.Header.Set("te", "trailers")
+	req.Header.Set("grpc-timeout", grpcTimeout(c.timeout))
+	if c.authority != "" {
+		req.Host = c.authority
+	}
+
+	resp, err := c.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	out := &grpcReply{
+		header: resp.Header,
+	}
+	if resp.StatusCode != http.StatusOK {
+		out.code = grpcCodeFromHttpStatus(resp.StatusCode)
+		out.message = fmt.Sprintf("unexpected http status %d", resp.StatusCode)
+		return out, nil
+	}
+	if ct := resp.Header.Get("content-type"); !strings.HasPrefix(ct, "application/grpc") {
+		return nil, fmt.Errorf("unexpected content-type %s", ct)
+	}
+
+	if data, err := grpcReadFrames(resp.Body); err != nil {
+		return nil, err
+	} else {
+		out.data = data
+	}
+	out.trailer = resp.Trailer
+
+	status := resp.Trailer.Get("grpc-status")
+	message := resp.Trailer.Get("grpc-message")
+	if status == "" {
+		status = resp.Header.Get("grpc-status")
+		message = resp.Header.Get("grpc-message")
+	}
+	if status == "" {
+		return nil, fmt.Errorf("reply does not have grpc-status")
+	}
+	if code, err := strconv.Atoi(status); err != nil {
+		return nil, fmt.Errorf("invalid grpc-status %s", status)
+	} else {
+		out.code = code
+	}
+	if v, err := url.PathUnescape(message); err == nil {
+		out.message = v
+	} else {
+		out.message = message
+	}
+	return out, nil
+}
+
+// grpc.health.v1.Health/Check
+
+var grpcHealthStatusName = map[uint64]string{
+	0: "UNKNOWN",
+	1: "SERVING",
+	2: "NOT_SERVING",
+	3: "SERVICE_UNKNOWN",
+}
+
+// HealthCheckRequest { string service = 1; }
+func grpcHealthRequest(service string) []byte {
+	out := []byte{}
+	if service != "" {
+		out = protowire.AppendTag(out, 1, protowire.BytesType)
+		out = protowire.AppendString(out, service)
+	}
+	return out
+}
+
+// HealthCheckResponse { ServingStatus status = 1; }
+func grpcHealthStatus(msg []byte) (string, error) {
+	status := uint64(0)
+	err := protoWalk(msg, func(num protowire.Number, _ []byte, v uint64) {
+		if num == 1 {
+			status = v
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+	if name, ok := grpcHealthStatusName[status]; ok {
+		return name, nil
+	}
+	return fmt.Sprintf("STATUS(%d)", status), nil
+}
+
+// walk the fields of an encoded protobuf message, the callback receives the
+// payload of a length delimited field or the value of a varint field, fields of
+// other types are skipped
+func protoWalk(msg []byte, fn func(protowire.Number, []byte, uint64)) error {
+	for len(msg) > 0 {
+		num, typ, n := protowire.ConsumeTag(msg)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		msg = msg[n:]
+
+		switch typ {
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(msg)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			fn(num, v, 0)
+			msg = msg[n:]
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(msg)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			fn(num, nil, v)
+			msg = msg[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, msg)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			msg = msg[n:]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,481 @@
+package builtin
+
+import (
+	"github.com/dianpeng/hi-doctor/dvar"
+	"github.com/dianpeng/hi-doctor/util"
+
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Authentication of the http task's request. Every credential is a string
+// context, so it can be sourced from the assets or the environment variables,
+// ie $<<assets.password>> or $<<os.Env("TOKEN")>>.
+//
+//   auth:
+//     type: basic                     # basic, bearer, digest, hmac or sigv4
+//     username: admin                 # basic and digest
+//     password: $<<assets.password>>  # basic and digest
+//     token: $<<os.Env("TOKEN")>>     # bearer
+//     key_id: k1                      # hmac
+//     secret: $<<assets.secret>>      # hmac
+//     algorithm: sha256               # hmac, sha1, sha256 or sha512
+//     signed_headers: [host, date]    # hmac
+//     header: Authorization           # hmac, header carries the signature
+//     access_key: $<<assets.ak>>      # sigv4, also secret_key, session_token,
+//     region: us-east-1               # region and service
+//     service: execute-api
+//
+// The digest is challenge/response, the request is sent without credential
+// first and sent again with the answer to the digest challenge if any.
+//
+// The hmac signs the following string, each part is separated by a newline,
+// and is sent as
+// <header>: HMAC keyId="..",algorithm="hmac-sha256",headers="host date",signature="<base64>"
+//
+//   <method>
+//   <request uri>
+//   <signed header in lower case>:<value>, one line per signed header
+//   <hex sha256 of the body>
+
+const (
+	httpAuthBasic = iota
+	httpAuthBearer
+	httpAuthDigest
+	httpAuthHmac
+	httpAuthSigV4
+)
+
+var httpAuthType = map[string]int{
+	"basic":  httpAuthBasic,
+	"bearer": httpAuthBearer,
+	"digest": httpAuthDigest,
+	"hmac":   httpAuthHmac,
+	"sigv4":  httpAuthSigV4,
+}
+
+// credentials required by each type
+var httpAuthRequire = map[int][]string{
+	httpAuthBasic:  {"username"},
+	httpAuthBearer: {"token"},
+	httpAuthDigest: {"username", "password"},
+	httpAuthHmac:   {"key_id", "secret"},
+	httpAuthSigV4:  {"access_key", "secret_key", "service"},
+}
+
+var httpAuthHmacAlgorithm = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+type httpAuthDefine struct {
+	Type          string   `mapstructure:"type"`
+	Username      string   `mapstructure:"username"`
+	Password      string   `mapstructure:"password"`
+	Token         string   `mapstructure:"token"`
+	KeyID         string   `mapstructure:"key_id"`
+	Secret        string   `mapstructure:"secret"`
+	Algorithm     string   `mapstructure:"algorithm"`
+	SignedHeaders []string `mapstructure:"signed_headers"`
+	Header        string   `mapstructure:"header"`
+	AccessKey     string   `mapstructure:"access_key"`
+	SecretKey     string   `mapstructure:"secret_key"`
+	SessionToken  string   `mapstructure:"session_token"`
+	Region        string   `mapstructure:"region"`
+	Service       string   `mapstructure:"service"`
+}
+
+type httpAuthTemplate struct {
+	kind          int
+	credential    map[string]dvar.DVar
+	algorithm     string
+	signedHeaders []string
+	header        string
+}
+
+// materialized authentication of a single request
+type httpAuth struct {
+	t          *httpAuthTemplate
+	credential map[string]string
+}
+
+func compileHttpAuth(d *httpAuthDefine) (*httpAuthTemplate, error) {
+	if d == nil {
+		return nil, nil
+	}
+
+	kind, ok := httpAuthType[d.Type]
+	if !ok {
+		return nil, fmt.Errorf("http_task.Auth.Type %s is unknown", d.Type)
+	}
+
+	out := &httpAuthTemplate{
+		kind:       kind,
+		credential: make(map[string]dvar.DVar),
+		algorithm:  d.Algorithm,
+		header:     d.Header,
+	}
+
+	for name, v := range map[string]string{
+		"username":      d.Username,
+		"password":      d.Password,
+		"token":         d.Token,
+		"key_id":        d.KeyID,
+		"secret":        d.Secret,
+		"access_key":    d.AccessKey,
+		"secret_key":    d.SecretKey,
+		"session_token": d.SessionToken,
+		"region":        d.Region,
+		"service":       d.Service,
+	} {
+		if v == "" {
+			continue
+		}
+		if dv, err := dvar.NewDVarStringContext(v); err != nil {
+			return nil, fmt.Errorf("http_task.Auth.%s compile failed: %s", name, err)
+		} else {
+			out.credential[name] = dv
+		}
+	}
+	for _, name := range httpAuthRequire[kind] {
+		if _, ok := out.credential[name]; !ok {
+			return nil, fmt.Errorf("http_task.Auth.%s is required by %s", name, d.Type)
+		}
+	}
+
+	if kind == httpAuthHmac {
+		if out.algorithm == "" {
+			out.algorithm = "sha256"
+		}
+		if _, ok := httpAuthHmacAlgorithm[out.algorithm]; !ok {
+			return nil, fmt.Errorf("http_task.Auth.Algorithm %s is unknown", out.algorithm)
+		}
+		if out.header == "" {
+			out.header = "Authorization"
+		}
+		out.signedHeaders = []string{"host", "date"}
+		if d.SignedHeaders != nil {
+			out.signedHeaders = nil
+			for _, h := range d.SignedHeaders {
+				out.signedHeaders = append(out.signedHeaders, strings.ToLower(h))
+			}
+		}
+	}
+	return out, nil
+}
+
+// evaluate the credentials, nil template results in nil authentication
+func (t *httpAuthTemplate) Value(env *dvar.EvalEnv) (*httpAuth, error) {
+	if t == nil {
+		return nil, nil
+	}
+	out := &httpAuth{
+		t:          t,
+		credential: make(map[string]string),
+	}
+	for name, dv := range t.credential {
+		if v, err := dv.Value(env); err != nil {
+			return nil, fmt.Errorf("http_task.Auth.%s execution failed: %s", name, err)
+		} else {
+			out.credential[name] = v.String()
+		}
+	}
+	return out, nil
+}
+
+// authenticate the request right before it is sent, body is the request's
+// body which is hashed when needed. The digest is not applied here, see
+// challenge
+func (a *httpAuth) apply(req *http.Request, body *httpBody, now time.Time) error {
+	c := a.credential
+
+	switch a.t.kind {
+	case httpAuthBasic:
+		req.SetBasicAuth(c["username"], c["password"])
+	case httpAuthBearer:
+		req.Header.Set("Authorization", "Bearer "+c["token"])
+	case httpAuthHmac:
+		return a.signHmac(req, body, now)
+	case httpAuthSigV4:
+		region := c["region"]
+		if region == "" {
+			region = "us-east-1"
+		}
+		signer := &util.SigV4{
+			AccessKey:    c["access_key"],
+			SecretKey:    c["secret_key"],
+			SessionToken: c["session_token"],
+			Region:       region,
+			Service:      c["service"],
+		}
+		payload, err := body.hash(sha256.New)
+		if err != nil {
+			return err
+		}
+		signer.Sign(req, payload, now)
+	}
+	return nil
+}
+
+func (a *httpAuth) signHmac(req *http.Request, body *httpBody, now time.Time) error {
+	lines := []string{
+		req.Method,
+		req.URL.RequestURI(),
+	}
+	for _, name := range a.t.signedHeaders {
+		var value string
+		switch name {
+		case "host":
+			value = req.Host
+			if value == "" {
+				value = req.URL.Host
+			}
+		case "date":
+			if req.Header.Get("Date") == "" {
+				req.Header.Set("Date", now.UTC().Format(http.TimeFormat))
+			}
+			value = req.Header.Get("Date")
+		default:
+			value = strings.Join(req.Header.Values(name), ",")
+		}
+		lines = append(lines, name+":"+value)
+	}
+	if payload, err := body.hash(sha256.New); err != nil {
+		return err
+	} else {
+		lines = append(lines, payload)
+	}
+
+	mac := hmac.New(httpAuthHmacAlgorithm[a.t.algorithm], []byte(a.credential["secret"]))
+	mac.Write([]byte(strings.Join(lines, "\n")))
+
+	req.Header.Set(
+		a.t.header,
+		fmt.Sprintf(
+			"HMAC keyId=\"%s\",algorithm=\"hmac-%s\",headers=\"%s\",signature=\"%s\"",
+			a.credential["key_id"],
+			a.t.algorithm,
+			strings.Join(a.t.signedHeaders, " "),
+			base64.StdEncoding.EncodeToString(mac.Sum(nil)),
+		),
+	)
+	return nil
+}
+
+// split the value of WWW-Authenticate into challenges, each is its scheme and
+// its parameters, ie
+//
+//	Basic realm="x", Digest realm="y", nonce="z"
+//
+// is [Basic, realm="x"] and [Digest, realm="y", nonce="z"]. The value is split
+// by the commas outside of the quoted-string, an item starts a new challenge
+// if it begins with a token that is not followed by '='
+func splitHttpAuthChallenge(x string) [][2]string {
+	item := []string{}
+	start, quoted := 0, false
+	for i := 0; i < len(x); i++ {
+		switch {
+		case quoted && x[i] == '\\':
+			i++
+		case x[i] == '"':
+			quoted = !quoted
+		case !quoted && x[i] == ',':
+			item = append(item, x[start:i])
+			start = i + 1
+		}
+	}
+	item = append(item, x[start:])
+
+	out := [][2]string{}
+	for _, v := range item {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		sp := strings.IndexAny(v, " \t")
+		eq := strings.IndexByte(v, '=')
+		switch {
+		case eq < 0 && sp < 0:
+			// scheme only
+			out = append(out, [2]string{v, ""})
+		case sp >= 0 && (eq < 0 || sp < eq) &&
+			!strings.HasPrefix(strings.TrimLeft(v[sp:], " \t"), "="):
+			out = append(out, [2]string{v[:sp], strings.TrimSpace(v[sp:])})
+		case len(out) > 0:
+			// parameter of the current challenge
+			out[len(out)-1][1] += ", " + v
+		}
+	}
+	return out
+}
+
+// parse the parameters of a challenge, ie realm="x", nonce="y", qop="auth"
+func parseHttpAuthParam(x string) map[string]string {
+	out := make(map[string]string)
+	for {
+		x = strings.TrimLeft(x, " \t,")
+		eq := strings.IndexByte(x, '=')
+		if eq <= 0 {
+			return out
+		}
+		key := strings.ToLower(strings.TrimSpace(x[:eq]))
+		x = strings.TrimLeft(x[eq+1:], " \t")
+
+		var value string
+		if strings.HasPrefix(x, "\"") {
+			buf := strings.Builder{}
+			i := 1
+			for ; i < len(x) && x[i] != '"'; i++ {
+				if x[i] == '\\' && i+1 < len(x) {
+					i++
+				}
+				buf.WriteByte(x[i])
+			}
+			value = buf.String()
+			if i < len(x) {
+				i++
+			}
+			x = x[i:]
+		} else if end := strings.IndexByte(x, ','); end >= 0 {
+			value = strings.TrimSpace(x[:end])
+			x = x[end:]
+		} else {
+			value = strings.TrimSpace(x)
+			x = ""
+		}
+		out[key] = value
+	}
+}
+
+// quoted-string of the auth parameter
+func httpQuote(x string) string {
+	x = strings.ReplaceAll(x, `\`, `\\`)
+	return `"` + strings.ReplaceAll(x, `"`, `\"`) + `"`
+}
+
+var httpDigestAlgorithm = map[string]func() hash.Hash{
+	"MD5":     md5.New,
+	"SHA-256": sha256.New,
+}
+
+// answer the digest challenge of the response, returns the Authorization of
+// the next request, or false if the response is not a digest challenge that
+// can be answered
+func (a *httpAuth) challenge(
+	resp *http.Response,
+	method string,
+	uri string,
+	body *httpBody,
+) (string, bool, error) {
+	if a.t.kind != httpAuthDigest || resp.StatusCode != http.StatusUnauthorized {
+		return "", false, nil
+	}
+
+	// prefer SHA-256 when multiple challenges are offered
+	var param map[string]string
+	algorithm, base := "", ""
+	for _, v := range resp.Header.Values("WWW-Authenticate") {
+		for _, c := range splitHttpAuthChallenge(v) {
+			if !strings.EqualFold(c[0], "digest") {
+				continue
+			}
+			p := parseHttpAuthParam(c[1])
+			alg := strings.ToUpper(p["algorithm"])
+			if alg == "" {
+				alg = "MD5"
+			}
+			b := strings.TrimSuffix(alg, "-SESS")
+			if _, ok := httpDigestAlgorithm[b]; !ok {
+				continue
+			}
+			if param == nil || b == "SHA-256" {
+				param, algorithm, base = p, alg, b
+			}
+		}
+	}
+	if param == nil {
+		return "", false, nil
+	}
+
+	h := func(x string) string {
+		hh := httpDigestAlgorithm[base]()
+		hh.Write([]byte(x))
+		return hex.EncodeToString(hh.Sum(nil))
+	}
+
+	qop := ""
+	for _, q := range strings.Split(param["qop"], ",") {
+		switch q = strings.TrimSpace(q); {
+		case q == "auth":
+			qop = q
+		case q == "auth-int" && qop == "":
+			qop = q
+		}
+	}
+
+	cnonceData := make([]byte, 16)
+	if _, err := rand.Read(cnonceData); err != nil {
+		return "", false, fmt.Errorf("cnonce generation failed: %s", err)
+	}
+	cnonce := hex.EncodeToString(cnonceData)
+	nc := "00000001"
+	nonce := param["nonce"]
+
+	ha1 := h(fmt.Sprintf("%s:%s:%s", a.credential["username"], param["realm"], a.credential["password"]))
+	if strings.HasSuffix(algorithm, "-SESS") {
+		ha1 = h(fmt.Sprintf("%s:%s:%s", ha1, nonce, cnonce))
+	}
+	ha2 := h(fmt.Sprintf("%s:%s", method, uri))
+	if qop == "auth-int" {
+		payload, err := body.hash(httpDigestAlgorithm[base])
+		if err != nil {
+			return "", false, fmt.Errorf("auth-int body hash failed: %s", err)
+		}
+		ha2 = h(fmt.Sprintf("%s:%s:%s", method, uri, payload))
+	}
+
+	field := map[string]string{
+		"username":  httpQuote(a.credential["username"]),
+		"realm":     httpQuote(param["realm"]),
+		"nonce":     httpQuote(nonce),
+		"uri":       httpQuote(uri),
+		"algorithm": algorithm,
+	}
+	if qop == "" {
+		field["response"] = httpQuote(h(fmt.Sprintf("%s:%s:%s", ha1, nonce, ha2)))
+	} else {
+		field["response"] = httpQuote(
+			h(fmt.Sprintf("%s:%s:%s:%s:%s:%s", ha1, nonce, nc, cnonce, qop, ha2)),
+		)
+		field["qop"] = qop
+		field["nc"] = nc
+		field["cnonce"] = httpQuote(cnonce)
+	}
+	if opaque, ok := param["opaque"]; ok {
+		field["opaque"] = httpQuote(opaque)
+	}
+
+	names := make([]string, 0, len(field))
+	for k := range field {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, k := range names {
+		parts = append(parts, k+"="+field[k])
+	}
+	return "Digest " + strings.Join(parts, ", "), true, nil
+}
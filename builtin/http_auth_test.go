@@ -0,0 +1,248 @@
+package builtin
+
+import (
+	"github.com/dianpeng/hi-doctor/util"
+
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testAuthPassword = "s3cret"
+	testAuthToken    = "tok"
+)
+
+func testDigestParam(x string) map[string]string {
+	out := make(map[string]string)
+	for _, kv := range strings.Split(x, ", ") {
+		if idx := strings.IndexByte(kv, '='); idx > 0 {
+			out[kv[:idx]] = strings.Trim(kv[idx+1:], "\"")
+		}
+	}
+	return out
+}
+
+func testMD5(x string) string {
+	sum := md5.Sum([]byte(x))
+	return hex.EncodeToString(sum[:])
+}
+
+func testCheckDigest(r *http.Request) bool {
+	authz := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authz, "Digest ") {
+		return false
+	}
+	p := testDigestParam(authz[7:])
+	if p["nonce"] != "abc" || p["opaque"] != "xyz" || p["qop"] != "auth" || p["uri"] != r.URL.RequestURI() {
+		return false
+	}
+	ha1 := testMD5(fmt.Sprintf("%s:test:%s", p["username"], testAuthPassword))
+	ha2 := testMD5(fmt.Sprintf("%s:%s", r.Method, p["uri"]))
+	return p["response"] == testMD5(strings.Join([]string{ha1, p["nonce"], p["nc"], p["cnonce"], p["qop"], ha2}, ":"))
+}
+
+func testCheckHmac(r *http.Request, body []byte) bool {
+	sum := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(testAuthPassword))
+	mac.Write([]byte(strings.Join([]string{
+		r.Method,
+		r.URL.RequestURI(),
+		"host:" + r.Host,
+		"date:" + r.Header.Get("Date"),
+		"x-nonce:" + r.Header.Get("X-Nonce"),
+		hex.EncodeToString(sum[:]),
+	}, "\n")))
+	return r.Header.Get("Authorization") == fmt.Sprintf(
+		"HMAC keyId=\"k1\",algorithm=\"hmac-sha256\",headers=\"host date x-nonce\",signature=\"%s\"",
+		base64.StdEncoding.EncodeToString(mac.Sum(nil)),
+	)
+}
+
+// sign the received request again, the signature must be the same
+func testCheckSigV4(r *http.Request, body []byte) bool {
+	now, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return false
+	}
+	req, _ := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), nil)
+	req.Host = r.Host
+	for k, v := range r.Header {
+		if k != "Authorization" {
+			req.Header[k] = v
+		}
+	}
+	signer := &util.SigV4{
+		AccessKey: "AK",
+		SecretKey: testAuthPassword,
+		Region:    "us-east-1",
+		Service:   "execute-api",
+	}
+	signer.Sign(req, util.SigV4PayloadHash(body), now)
+	return req.Header.Get("Authorization") == r.Header.Get("Authorization")
+}
+
+func TestHttpAuth(t *testing.T) {
+	t.Setenv("HD_TEST_PASSWORD", testAuthPassword)
+	t.Setenv("HD_TEST_TOKEN", testAuthToken)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		pass := false
+
+		switch r.URL.Path {
+		case "/basic":
+			user, password, ok := r.BasicAuth()
+			pass = ok && user == "admin" && password == testAuthPassword
+		case "/bearer":
+			pass = r.Header.Get("Authorization") == "Bearer "+testAuthToken
+		case "/digest":
+			if pass = testCheckDigest(r); !pass {
+				w.Header().Add("WWW-Authenticate", `Basic realm="test"`)
+				w.Header().Add("WWW-Authenticate", `Digest realm="test", nonce="abc", qop="auth,auth-int", algorithm=MD5, opaque="xyz"`)
+			}
+		case "/digest/combined":
+			// both challenges in one header value
+			if pass = testCheckDigest(r); !pass {
+				w.Header().Set("WWW-Authenticate", `Basic realm="test", Digest realm="test", nonce="abc", qop="auth,auth-int", algorithm=MD5, opaque="xyz"`)
+			}
+		case "/hmac":
+			pass = testCheckHmac(r, body)
+		case "/sigv4/a b":
+			pass = testCheckSigV4(r, body)
+		}
+
+		if !pass {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	target := testServerTarget(t, server.Listener.Addr())
+
+	for _, x := range []struct {
+		name   string
+		option string
+		status int
+		body   string
+	}{
+		{"basic", `
+method: GET
+path: /basic
+auth:
+  type: basic
+  username: admin
+  password: ${os.Env("HD_TEST_PASSWORD")}
+`, 200, ""},
+		{"bearer", `
+method: GET
+path: /bearer
+auth:
+  type: bearer
+  token: ${os.Env("HD_TEST_TOKEN")}
+`, 200, ""},
+		{"digest", `
+method: POST
+path: /digest?x=1
+body: hello
+auth:
+  type: digest
+  username: admin
+  password: ${os.Env("HD_TEST_PASSWORD")}
+`, 200, "hello"},
+		{"digest combined", `
+method: POST
+path: /digest/combined
+body: hello
+auth:
+  type: digest
+  username: admin
+  password: ${os.Env("HD_TEST_PASSWORD")}
+`, 200, "hello"},
+		{"hmac", `
+method: PUT
+path: /hmac
+host: api.example.com
+body: hello
+header:
+  x-nonce: "1"
+auth:
+  type: hmac
+  key_id: k1
+  secret: ${os.Env("HD_TEST_PASSWORD")}
+  signed_headers: [host, date, x-nonce]
+`, 200, "hello"},
+		{"sigv4", `
+method: POST
+path: /sigv4/a b
+host: api.example.com
+body: hello
+auth:
+  type: sigv4
+  access_key: AK
+  secret_key: ${os.Env("HD_TEST_PASSWORD")}
+  service: execute-api
+`, 200, "hello"},
+		{"wrong", `
+method: GET
+path: /basic
+auth:
+  type: basic
+  username: admin
+  password: wrong
+`, 401, ""},
+	} {
+		stat := testRunHttpTask(t, x.option, target)
+		if stat.RespStatus != x.status {
+			t.Errorf("%s, resp_status: got %d, want %d, resp_error: %s", x.name, stat.RespStatus, x.status, stat.RespError)
+		}
+		if stat.RespBody != x.body {
+			t.Errorf("%s, resp_body: got %q, want %q", x.name, stat.RespBody, x.body)
+		}
+
+		// the credential is never exposed
+		if v := stat.ReqHeader.Get("Authorization"); v != "" {
+			t.Errorf("%s, req_header.Authorization: got %q, want empty", x.name, v)
+		}
+	}
+}
+
+func TestSplitHttpAuthChallenge(t *testing.T) {
+	for _, x := range []struct {
+		value string
+		want  [][2]string
+	}{
+		{`Digest realm="y", nonce="z"`, [][2]string{{"Digest", `realm="y", nonce="z"`}}},
+		{
+			`Basic realm="x", Digest realm="y", nonce="z"`,
+			[][2]string{{"Basic", `realm="x"`}, {"Digest", `realm="y", nonce="z"`}},
+		},
+		{
+			`Digest realm="a, Basic b", qop="auth,auth-int", Negotiate, Bearer realm = "c"`,
+			[][2]string{
+				{"Digest", `realm="a, Basic b", qop="auth,auth-int"`},
+				{"Negotiate", ""},
+				{"Bearer", `realm = "c"`},
+			},
+		},
+		{`Negotiate YII=, Basic realm="\"x\", y"`, [][2]string{{"Negotiate", "YII="}, {"Basic", `realm="\"x\", y"`}}},
+		{"", [][2]string{}},
+	} {
+		got := splitHttpAuthChallenge(x.value)
+		if !reflect.DeepEqual(got, x.want) {
+			t.Errorf("challenges of %s: got %q, want %q", x.value, got, x.want)
+		}
+	}
+}
@@ -0,0 +1,436 @@
+package builtin
+
+import (
+	"github.com/dianpeng/hi-doctor/dvar"
+
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"math/rand"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Body of the http task, at most one of the following sources can be used.
+// Large payloads are never held in memory, files and generated content are
+// streamed for each request, the Content-Type is set unless the header has it
+// and the Content-Length is always known.
+//
+//   body: 'plain text, interpolated'
+//   body_file: /path/to/upload.bin    # streamed from disk
+//   body_base64: 'AAECAw=='           # binary payload
+//   body_size: 1048576                # random content of the size
+//   form:                             # application/x-www-form-urlencoded
+//     user: foo
+//   multipart:                        # multipart/form-data
+//     - name: user
+//       value: foo
+//     - name: upload
+//       file: /path/to/upload.bin
+//       filename: upload.bin          # default to the file's base name
+//       content_type: image/png       # default to the one of the file's extension
+
+const httpBodyDefContentType = "application/octet-stream"
+
+const (
+	httpBodyText = iota
+	httpBodyFile
+	httpBodyBase64
+	httpBodyRandom
+	httpBodyForm
+	httpBodyMultipart
+)
+
+type httpMultipartDefine struct {
+	Name        string `mapstructure:"name"`
+	Value       string `mapstructure:"value"`
+	File        string `mapstructure:"file"`
+	Filename    string `mapstructure:"filename"`
+	ContentType string `mapstructure:"content_type"`
+}
+
+type httpMultipartTemplate struct {
+	name        string
+	value       dvar.DVar
+	file        dvar.DVar
+	isFile      bool
+	filename    dvar.DVar
+	contentType dvar.DVar
+}
+
+type httpBodyTemplate struct {
+	kind      int
+	text      dvar.DVar
+	file      dvar.DVar
+	base64    dvar.DVar
+	size      int64
+	formKey   []string
+	form      map[string]dvar.DVar
+	multipart []httpMultipartTemplate
+}
+
+// a piece of the body, either the data itself, a file or random content
+type httpBodySegment struct {
+	data []byte
+	file string
+	size int64 // size of the file or # of random bytes
+	seed int64 // seed of the random content
+}
+
+type httpBody struct {
+	contentType string
+	length      int64
+	text        string // reported as req_body, only for the textual body
+	segment     []httpBodySegment
+}
+
+func compileHttpBody(m *httpTaskDefine) (*httpBodyTemplate, error) {
+	kind, source := httpBodyText, 0
+	for k, set := range []bool{
+		m.Body != "",
+		m.BodyFile != "",
+		m.BodyBase64 != "",
+		m.BodySize != 0,
+		len(m.Form) != 0,
+		len(m.Multipart) != 0,
+	} {
+		if set {
+			kind = k
+			source++
+		}
+	}
+	if source > 1 {
+		return nil, fmt.Errorf("http_task, only one of body, body_file, body_base64, body_size, form and multipart can be used")
+	}
+	if m.BodySize < 0 {
+		return nil, fmt.Errorf("http_task.BodySize must not be negative")
+	}
+
+	o := &httpBodyTemplate{
+		kind: kind,
+		size: m.BodySize,
+		form: make(map[string]dvar.DVar),
+	}
+
+	if dv, err := dvar.NewDVarStringContext(m.Body); err != nil {
+		return nil, fmt.Errorf("http_task.Body compile failed: %s", err)
+	} else {
+		o.text = dv
+	}
+	if dv, err := dvar.NewDVarStringContext(m.BodyFile); err != nil {
+		return nil, fmt.Errorf("http_task.BodyFile compile failed: %s", err)
+	} else {
+		o.file = dv
+	}
+	if dv, err := dvar.NewDVarStringContext(m.BodyBase64); err != nil {
+		return nil, fmt.Errorf("http_task.BodyBase64 compile failed: %s", err)
+	} else {
+		o.base64 = dv
+	}
+
+	for k, v := range m.Form {
+		if dv, err := dvar.NewDVarStringContext(v); err != nil {
+			return nil, fmt.Errorf("http_task.Form[%s] compile failed: %s", k, err)
+		} else {
+			o.form[k] = dv
+			o.formKey = append(o.formKey, k)
+		}
+	}
+	sort.Strings(o.formKey)
+
+	for i, p := range m.Multipart {
+		if p.Name == "" {
+			return nil, fmt.Errorf("http_task.Multipart[%d] must have name", i)
+		}
+		if p.Value != "" && p.File != "" {
+			return nil, fmt.Errorf("http_task.Multipart[%d] cannot have both value and file", i)
+		}
+		part := httpMultipartTemplate{
+			name:   p.Name,
+			isFile: p.File != "",
+		}
+		for _, x := range []struct {
+			field  string
+			source string
+			out    *dvar.DVar
+		}{
+			{"value", p.Value, &part.value},
+			{"file", p.File, &part.file},
+			{"filename", p.Filename, &part.filename},
+			{"content_type", p.ContentType, &part.contentType},
+		} {
+			if dv, err := dvar.NewDVarStringContext(x.source); err != nil {
+				return nil, fmt.Errorf("http_task.Multipart[%d].%s compile failed: %s", i, x.field, err)
+			} else {
+				*x.out = dv
+			}
+		}
+		o.multipart = append(o.multipart, part)
+	}
+
+	return o, nil
+}
+
+func httpFileContentType(path string) string {
+	if ct := mime.TypeByExtension(filepath.Ext(path)); ct != "" {
+		return ct
+	}
+	return httpBodyDefContentType
+}
+
+// size of the file to be streamed, only regular file is allowed
+func httpFileSize(path string) (int64, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	if !st.Mode().IsRegular() {
+		return 0, fmt.Errorf("%s is not a regular file", path)
+	}
+	return st.Size(), nil
+}
+
+func (t *httpBodyTemplate) Value(env *dvar.EvalEnv) (*httpBody, error) {
+	out := &httpBody{}
+
+	switch t.kind {
+	case httpBodyFile:
+		vv, err := t.file.Value(env)
+		if err != nil {
+			return nil, fmt.Errorf("http_task.BodyFile execution failed: %s", err)
+		}
+		path := vv.String()
+		size, err := httpFileSize(path)
+		if err != nil {
+			return nil, fmt.Errorf("http_task.BodyFile invalid: %s", err)
+		}
+		out.contentType = httpFileContentType(path)
+		out.add(httpBodySegment{file: path, size: size})
+
+	case httpBodyBase64:
+		vv, err := t.base64.Value(env)
+		if err != nil {
+			return nil, fmt.Errorf("http_task.BodyBase64 execution failed: %s", err)
+		}
+		data, err := base64.StdEncoding.DecodeString(vv.String())
+		if err != nil {
+			return nil, fmt.Errorf("http_task.BodyBase64 invalid: %s", err)
+		}
+		out.contentType = httpBodyDefContentType
+		out.add(httpBodySegment{data: data})
+
+	case httpBodyRandom:
+		// the seed is kept so the same content can be generated again when the
+		// request is resent or the body is signed
+		out.contentType = httpBodyDefContentType
+		out.add(httpBodySegment{size: t.size, seed: rand.Int63()})
+
+	case httpBodyForm:
+		form := url.Values{}
+		for _, k := range t.formKey {
+			dv := t.form[k]
+			if vv, err := dv.Value(env); err != nil {
+				return nil, fmt.Errorf("http_task.Form[%s] execution failed: %s", k, err)
+			} else {
+				form.Set(k, vv.String())
+			}
+		}
+		out.contentType = "application/x-www-form-urlencoded"
+		out.text = form.Encode()
+		out.add(httpBodySegment{data: []byte(out.text)})
+
+	case httpBodyMultipart:
+		if err := out.addMultipart(t.multipart, env); err != nil {
+			return nil, err
+		}
+
+	default:
+		vv, err := t.text.Value(env)
+		if err != nil {
+			return nil, fmt.Errorf("http_task.Body execution failed: %s", err)
+		}
+		out.text = vv.String()
+		out.add(httpBodySegment{data: []byte(out.text)})
+	}
+
+	return out, nil
+}
+
+func (b *httpBody) add(seg httpBodySegment) {
+	if seg.data != nil {
+		seg.size = int64(len(seg.data))
+	}
+	if seg.size != 0 {
+		b.segment = append(b.segment, seg)
+		b.length += seg.size
+	}
+}
+
+// the part headers and the fields are generated upfront, the files are left as
+// segments in between and streamed later
+func (b *httpBody) addMultipart(parts []httpMultipartTemplate, env *dvar.EvalEnv) error {
+	buf := &bytes.Buffer{}
+	w := multipart.NewWriter(buf)
+
+	eval := func(i int, field string, dv *dvar.DVar) (string, error) {
+		if vv, err := dv.Value(env); err != nil {
+			return "", fmt.Errorf("http_task.Multipart[%d].%s execution failed: %s", i, field, err)
+		} else {
+			return vv.String(), nil
+		}
+	}
+
+	for i, p := range parts {
+		contentType, err := eval(i, "content_type", &p.contentType)
+		if err != nil {
+			return err
+		}
+
+		if !p.isFile {
+			value, err := eval(i, "value", &p.value)
+			if err != nil {
+				return err
+			}
+			h := make(textproto.MIMEHeader)
+			h.Set("Content-Disposition", fmt.Sprintf("form-data; name=%s", httpQuote(p.name)))
+			if contentType != "" {
+				h.Set("Content-Type", contentType)
+			}
+			if pw, err := w.CreatePart(h); err != nil {
+				return fmt.Errorf("http_task.Multipart[%d] failed: %s", i, err)
+			} else {
+				pw.Write([]byte(value))
+			}
+			continue
+		}
+
+		path, err := eval(i, "file", &p.file)
+		if err != nil {
+			return err
+		}
+		size, err := httpFileSize(path)
+		if err != nil {
+			return fmt.Errorf("http_task.Multipart[%d].file invalid: %s", i, err)
+		}
+		filename, err := eval(i, "filename", &p.filename)
+		if err != nil {
+			return err
+		}
+		if filename == "" {
+			filename = filepath.Base(path)
+		}
+		if contentType == "" {
+			contentType = httpFileContentType(path)
+		}
+
+		h := make(textproto.MIMEHeader)
+		h.Set(
+			"Content-Disposition",
+			fmt.Sprintf("form-data; name=%s; filename=%s", httpQuote(p.name), httpQuote(filename)),
+		)
+		h.Set("Content-Type", contentType)
+		if _, err := w.CreatePart(h); err != nil {
+			return fmt.Errorf("http_task.Multipart[%d] failed: %s", i, err)
+		}
+
+		b.add(httpBodySegment{data: append([]byte{}, buf.Bytes()...)})
+		b.add(httpBodySegment{file: path, size: size})
+		buf.Reset()
+	}
+
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("http_task.Multipart failed: %s", err)
+	}
+	b.add(httpBodySegment{data: buf.Bytes()})
+	b.contentType = w.FormDataContentType()
+	return nil
+}
+
+// a new reader of the body, it can be called as many times as needed
+func (b *httpBody) reader() io.ReadCloser {
+	return &httpBodyReader{
+		segment: b.segment,
+	}
+}
+
+// hash of the whole body in hex, the body is streamed through the hash
+func (b *httpBody) hash(fn func() hash.Hash) (string, error) {
+	h := fn()
+	r := b.reader()
+	defer r.Close()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// reads the segments one by one, the file is opened only when it is reached
+type httpBodyReader struct {
+	segment []httpBodySegment
+	current io.Reader
+	file    *os.File
+}
+
+func (r *httpBodyReader) next() error {
+	r.closeFile()
+	seg := r.segment[0]
+	r.segment = r.segment[1:]
+
+	switch {
+	case seg.data != nil:
+		r.current = bytes.NewReader(seg.data)
+	case seg.file != "":
+		f, err := os.Open(seg.file)
+		if err != nil {
+			return err
+		}
+		r.file = f
+		r.current = io.LimitReader(f, seg.size)
+	default:
+		r.current = io.LimitReader(rand.New(rand.NewSource(seg.seed)), seg.size)
+	}
+	return nil
+}
+
+func (r *httpBodyReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.segment) == 0 {
+				return 0, io.EOF
+			}
+			if err := r.next(); err != nil {
+				return 0, err
+			}
+		}
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			r.current = nil
+			err = nil
+		}
+		if n != 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+func (r *httpBodyReader) closeFile() {
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+}
+
+func (r *httpBodyReader) Close() error {
+	r.closeFile()
+	r.current = nil
+	r.segment = nil
+	return nil
+}
@@ -0,0 +1,196 @@
+package builtin
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testBodyFile = "hello world"
+
+// what the server received of a request
+type testBodyRequest struct {
+	body          []byte
+	contentType   string
+	contentLength int64
+}
+
+func TestHttpBody(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "upload.txt")
+	if err := os.WriteFile(path, []byte(testBodyFile), 0644); err != nil {
+		t.Fatalf("cannot write file: %s", err)
+	}
+	t.Setenv("HD_TEST_FILE", path)
+
+	var lock sync.Mutex
+	received := make(map[string]testBodyRequest)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		lock.Lock()
+		received[r.URL.Path] = testBodyRequest{
+			body:          body,
+			contentType:   r.Header.Get("Content-Type"),
+			contentLength: r.ContentLength,
+		}
+		lock.Unlock()
+
+		// the body must be the same when resent after the redirect
+		if r.URL.Path == "/size" {
+			http.Redirect(w, r, "/size2", http.StatusTemporaryRedirect)
+		}
+	}))
+	defer server.Close()
+
+	target := testServerTarget(t, server.Listener.Addr())
+
+	for _, x := range []struct {
+		name        string
+		option      string
+		body        []byte // nil is not compared
+		contentType string
+		size        int64
+		text        string // req_body, only for the textual body
+	}{
+		{"file", `
+method: PUT
+path: /file
+body_file: ${os.Env("HD_TEST_FILE")}
+`, []byte(testBodyFile), "text/plain; charset=utf-8", 11, ""},
+		{"binary", `
+method: POST
+path: /binary
+body_base64: AAECAw==
+`, []byte{0, 1, 2, 3}, "application/octet-stream", 4, ""},
+		{"size", `
+method: POST
+path: /size
+body_size: 100000
+`, nil, "application/octet-stream", 100000, ""},
+		{"form", `
+method: POST
+path: /form
+form:
+  a: "1"
+  b: x y
+`, []byte("a=1&b=x+y"), "application/x-www-form-urlencoded", 9, "a=1&b=x+y"},
+	} {
+		stat := testRunHttpTask(t, x.option, target)
+		if stat.RespStatus != 200 {
+			t.Errorf("%s, resp_status: got %d, want 200, resp_error: %s", x.name, stat.RespStatus, stat.RespError)
+		}
+		if stat.ReqBodySize != x.size {
+			t.Errorf("%s, req_body_size: got %d, want %d", x.name, stat.ReqBodySize, x.size)
+		}
+		if stat.ReqBody != x.text {
+			t.Errorf("%s, req_body: got %q, want %q", x.name, stat.ReqBody, x.text)
+		}
+
+		lock.Lock()
+		got := received["/"+x.name]
+		lock.Unlock()
+		if x.body != nil && !bytes.Equal(got.body, x.body) {
+			t.Errorf("%s, body received: got %q, want %q", x.name, got.body, x.body)
+		}
+		if int64(len(got.body)) != x.size {
+			t.Errorf("%s, size of body received: got %d, want %d", x.name, len(got.body), x.size)
+		}
+		if got.contentLength != x.size {
+			t.Errorf("%s, Content-Length received: got %d, want %d", x.name, got.contentLength, x.size)
+		}
+		if got.contentType != x.contentType {
+			t.Errorf("%s, Content-Type received: got %q, want %q", x.name, got.contentType, x.contentType)
+		}
+	}
+
+	// the random body is generated again for the redirect
+	lock.Lock()
+	defer lock.Unlock()
+	if first, second := received["/size"].body, received["/size2"].body; !bytes.Equal(first, second) {
+		t.Errorf("size, body resent after redirect: got different body of %d bytes, want the same", len(second))
+	}
+}
+
+func TestHttpBodyMultipart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "upload.txt")
+	if err := os.WriteFile(path, []byte(testBodyFile), 0644); err != nil {
+		t.Fatalf("cannot write file: %s", err)
+	}
+	t.Setenv("HD_TEST_FILE", path)
+
+	type part struct {
+		filename    string
+		contentType string
+		data        string
+	}
+	var (
+		lock          sync.Mutex
+		user          string
+		upload        part
+		contentLength int64
+		parseErr      error
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		contentLength = r.ContentLength
+		if parseErr = r.ParseMultipartForm(1 << 20); parseErr != nil {
+			return
+		}
+		user = r.FormValue("user")
+		file, header, err := r.FormFile("upload")
+		if err != nil {
+			parseErr = err
+			return
+		}
+		data, _ := io.ReadAll(file)
+		upload = part{
+			filename:    header.Filename,
+			contentType: header.Header.Get("Content-Type"),
+			data:        string(data),
+		}
+	}))
+	defer server.Close()
+
+	stat := testRunHttpTask(t, `
+method: POST
+path: /multipart
+multipart:
+  - name: user
+    value: foo
+  - name: upload
+    file: ${os.Env("HD_TEST_FILE")}
+    content_type: text/plain
+`, testServerTarget(t, server.Listener.Addr()))
+
+	if stat.RespStatus != 200 {
+		t.Fatalf("resp_status: got %d, want 200, resp_error: %s", stat.RespStatus, stat.RespError)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if parseErr != nil {
+		t.Fatalf("multipart received: got error %s, want none", parseErr)
+	}
+	if user != "foo" {
+		t.Errorf("field user: got %q, want %q", user, "foo")
+	}
+	want := part{"upload.txt", "text/plain", testBodyFile}
+	if upload != want {
+		t.Errorf("file upload: got %+v, want %+v", upload, want)
+	}
+	if contentLength != stat.ReqBodySize || contentLength <= 0 {
+		t.Errorf("Content-Length received: got %d, want req_body_size %d", contentLength, stat.ReqBodySize)
+	}
+	if ct := stat.ReqHeader.Get("Content-Type"); !strings.HasPrefix(ct, "multipart/form-data; boundary=") {
+		t.Errorf("req_header.Content-Type: got %q, want multipart/form-data with boundary", ct)
+	}
+}
@@ -0,0 +1,176 @@
+package builtin
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Proxy of the http task, the task's proxy option takes priority, then the
+// target.proxy and lastly the job's proxy, direct means no proxy at all.
+//
+//   - http target via http(s) proxy, the request is forwarded with the
+//     absolute URL, the proxy's reply is the response itself
+//   - https target via http(s) proxy, a CONNECT tunnel is established first,
+//     its status and timing are recorded separately
+//   - socks5(h) proxy, the connection is made via the SOCKS5 handshake
+
+// result of the CONNECT tunnel of a request, the tunnel is dialed by the
+// transport so it is passed along via the request's context
+type httpProxyTrace struct {
+	sync.Mutex
+	status  int
+	connect time.Duration
+}
+
+type httpProxyTraceKey struct{}
+
+func withHttpProxyTrace(ctx context.Context, t *httpProxyTrace) context.Context {
+	return context.WithValue(ctx, httpProxyTraceKey{}, t)
+}
+
+func (t *httpProxyTrace) record(status int, connect time.Duration) {
+	if t == nil {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+	t.status = status
+	t.connect = connect
+}
+
+func (t *httpProxyTrace) result() (int, time.Duration) {
+	t.Lock()
+	defer t.Unlock()
+	return t.status, t.connect
+}
+
+// whether the request to the target goes through a CONNECT tunnel
+func httpProxyTunnel(proxy *url.URL, isHttps bool) bool {
+	return proxy != nil && isHttps && (proxy.Scheme == "http" || proxy.Scheme == "https")
+}
+
+// setup the transport to reach the target via the proxy
+func setupHttpProxy(tr *http.Transport, proxy *url.URL, isHttps bool) {
+	if proxy == nil {
+		return
+	}
+	if httpProxyTunnel(proxy, isHttps) {
+		tr.DialContext = httpProxyDialer(proxy)
+		return
+	}
+
+	tr.Proxy = http.ProxyURL(proxy)
+
+	// the transport's TLS config is the target's, the https proxy itself is
+	// verified against its own name
+	if proxy.Scheme == "https" {
+		tr.DialTLSContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return dialHttpProxy(ctx, network, proxy)
+		}
+	}
+}
+
+func httpProxyAddress(proxy *url.URL) string {
+	if port := proxy.Port(); port != "" {
+		return net.JoinHostPort(proxy.Hostname(), port)
+	}
+	if proxy.Scheme == "https" {
+		return net.JoinHostPort(proxy.Hostname(), "443")
+	}
+	return net.JoinHostPort(proxy.Hostname(), "80")
+}
+
+// connection to the http(s) proxy itself
+func dialHttpProxy(ctx context.Context, network string, proxy *url.URL) (net.Conn, error) {
+	d := net.Dialer{}
+	conn, err := d.DialContext(ctx, network, httpProxyAddress(proxy))
+	if err != nil {
+		return nil, err
+	}
+	if proxy.Scheme != "https" {
+		return conn, nil
+	}
+
+	tlsConn := tls.Client(conn, &tls.Config{
+		ServerName: proxy.Hostname(),
+	})
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy tls handshake failed: %s", err)
+	}
+	return tlsConn, nil
+}
+
+// dial the target via the CONNECT tunnel, the TLS handshake with the target is
+// done by the transport on top of the returned connection
+func httpProxyDialer(proxy *url.URL) func(context.Context, string, string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		trace, _ := ctx.Value(httpProxyTraceKey{}).(*httpProxyTrace)
+
+		conn, err := dialHttpProxy(ctx, network, proxy)
+		if err != nil {
+			return nil, err
+		}
+
+		start := time.Now()
+		status, err := httpProxyConnect(ctx, conn, proxy, addr)
+		trace.record(status, time.Since(start))
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+}
+
+// send the CONNECT request and wait for its reply, the status is returned as
+// long as the reply is received
+func httpProxyConnect(
+	ctx context.Context,
+	conn net.Conn,
+	proxy *url.URL,
+	addr string,
+) (int, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if u := proxy.User; u != nil {
+		password, _ := u.Password()
+		req.Header.Set(
+			"Proxy-Authorization",
+			"Basic "+base64.StdEncoding.EncodeToString([]byte(u.Username()+":"+password)),
+		)
+	}
+	if err := req.Write(conn); err != nil {
+		return 0, fmt.Errorf("proxy CONNECT %s failed: %s", addr, err)
+	}
+
+	// nothing is sent by the target before the client speaks, so the reader
+	// never buffers bytes belonging to the tunnel
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return 0, fmt.Errorf("proxy CONNECT %s failed: %s", addr, err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, fmt.Errorf("proxy CONNECT %s failed: %s", addr, resp.Status)
+	}
+	return resp.StatusCode, nil
+}
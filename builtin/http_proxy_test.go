@@ -0,0 +1,141 @@
+package builtin
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const testProxyAuth = "user:s3cret"
+
+// a forward proxy which also supports CONNECT, the CONNECT requires the
+// credential while forwarding does not
+func testProxyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		if r.Header.Get("Proxy-Authorization") != "Basic "+base64.StdEncoding.EncodeToString([]byte(testProxyAuth)) {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			upstream.Close()
+			return
+		}
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		go func() {
+			io.Copy(upstream, conn)
+			upstream.Close()
+		}()
+		io.Copy(conn, upstream)
+		conn.Close()
+		return
+	}
+
+	req, _ := http.NewRequest(r.Method, r.URL.String(), r.Body)
+	req.Header = r.Header.Clone()
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("X-Via-Proxy", "1")
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+func TestHttpProxy(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(testProxyHandler))
+	defer proxy.Close()
+	proxyAddr := proxy.Listener.Addr().String()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer origin.Close()
+
+	tlsOrigin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello tls"))
+	}))
+	defer tlsOrigin.Close()
+
+	plain := testServerTarget(t, origin.Listener.Addr())
+	plain["proxy"] = "http://" + proxyAddr
+
+	tls := testServerTarget(t, tlsOrigin.Listener.Addr())
+	tls["proxy"] = fmt.Sprintf("http://%s@%s", testProxyAuth, proxyAddr)
+
+	for _, x := range []struct {
+		name        string
+		option      string
+		target      map[string]interface{}
+		ok          bool
+		body        string
+		via         string // X-Via-Proxy of the response
+		proxy       string // req_proxy
+		proxyStatus int
+	}{
+		{
+			name:   "forward",
+			option: "{method: GET, path: /hello}",
+			target: plain,
+			ok:     true,
+			body:   "hello",
+			via:    "1",
+			proxy:  "http://" + proxyAddr,
+		},
+		{
+			name:   "direct",
+			option: "{method: GET, path: /hello, proxy: direct}",
+			target: plain,
+			ok:     true,
+			body:   "hello",
+		},
+		{
+			name:        "tunnel",
+			option:      "{method: GET, scheme: https, path: /hello}",
+			target:      tls,
+			ok:          true,
+			body:        "hello tls",
+			proxy:       "http://user:xxxxx@" + proxyAddr,
+			proxyStatus: 200,
+		},
+		{
+			name:        "denied",
+			option:      fmt.Sprintf("{method: GET, scheme: https, path: /hello, proxy: 'http://%s'}", proxyAddr),
+			target:      tls,
+			ok:          false,
+			proxy:       "http://" + proxyAddr,
+			proxyStatus: 407,
+		},
+	} {
+		stat := testRunHttpTask(t, x.option, x.target)
+		if stat.RespOK != x.ok {
+			t.Errorf("%s, resp_ok: got %t, want %t, resp_error: %s", x.name, stat.RespOK, x.ok, stat.RespError)
+		}
+		if stat.RespBody != x.body {
+			t.Errorf("%s, resp_body: got %q, want %q", x.name, stat.RespBody, x.body)
+		}
+		if via := stat.RespHeader.Get("X-Via-Proxy"); via != x.via {
+			t.Errorf("%s, resp_header.X-Via-Proxy: got %q, want %q", x.name, via, x.via)
+		}
+		if stat.ReqProxy != x.proxy {
+			t.Errorf("%s, req_proxy: got %q, want %q", x.name, stat.ReqProxy, x.proxy)
+		}
+		if stat.RespProxyStatus != x.proxyStatus {
+			t.Errorf("%s, resp_proxy_status: got %d, want %d", x.name, stat.RespProxyStatus, x.proxyStatus)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package builtin
+
+import (
+	"github.com/mitchellh/mapstructure"
+
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"time"
+)
+
+// Redirect policy of the http task. Every redirect followed is recorded as a
+// hop, when a redirect is not followed due to the policy, the redirect reply
+// itself becomes the response and the reason is recorded.
+//
+//   redirect: follow                  # default, at most 10 redirects
+//   redirect: none                    # never follow
+//   redirect:
+//     max: 3                          # at most 3 redirects
+//     cross_host: false               # follow redirect to another host or not
+//     allow_host: ["*.example.com"]   # hosts allowed to redirect to, when it is
+//                                     # set, cross_host is ignored
+
+const httpDefMaxRedirect = 10
+
+type httpRedirectDefine struct {
+	Max       int      `mapstructure:"max"`
+	CrossHost bool     `mapstructure:"cross_host"`
+	AllowHost []string `mapstructure:"allow_host"`
+}
+
+type httpRedirectPolicy struct {
+	max       int
+	crossHost bool
+	allowHost []string
+}
+
+type httpTaskResultRedirect struct {
+	Url      string `json:"url"`
+	Status   int    `json:"status"`
+	Location string `json:"location"`
+	RT       int64  `json:"rt"` // from the hop's request till its response
+}
+
+func compileHttpRedirect(x interface{}) (*httpRedirectPolicy, error) {
+	def := &httpRedirectDefine{
+		Max:       httpDefMaxRedirect,
+		CrossHost: true,
+	}
+
+	switch v := x.(type) {
+	case nil:
+		break
+	case string:
+		switch v {
+		case "", "follow":
+			break
+		case "none":
+			def.Max = 0
+		default:
+			return nil, fmt.Errorf("http_task.Redirect %s is unknown, must be follow or none", v)
+		}
+	default:
+		if err := mapstructure.Decode(v, def); err != nil {
+			return nil, fmt.Errorf("http_task.Redirect invalid option: %s", err)
+		}
+		if def.Max < 0 {
+			return nil, fmt.Errorf("http_task.Redirect.Max must not be negative")
+		}
+		for _, p := range def.AllowHost {
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("http_task.Redirect.AllowHost %s is invalid: %s", p, err)
+			}
+		}
+	}
+
+	return &httpRedirectPolicy{
+		max:       def.Max,
+		crossHost: def.CrossHost,
+		allowHost: def.AllowHost,
+	}, nil
+}
+
+func (p *httpRedirectPolicy) allow(host string) bool {
+	if len(p.allowHost) == 0 {
+		return p.crossHost
+	}
+	for _, pattern := range p.allowHost {
+		if ok, _ := path.Match(pattern, host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// host name of the request, the host header takes priority
+func httpRequestHost(r *http.Request) string {
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		return name
+	}
+	return host
+}
+
+// follows the redirects of a single request, used as CheckRedirect of the
+// http.Client
+type httpRedirectTracker struct {
+	policy   *httpRedirectPolicy
+	hopStart time.Time
+	hops     []httpTaskResultRedirect
+	stop     string
+}
+
+func newHttpRedirectTracker(p *httpRedirectPolicy) *httpRedirectTracker {
+	return &httpRedirectTracker{
+		policy:   p,
+		hopStart: time.Now(),
+		hops:     []httpTaskResultRedirect{},
+	}
+}
+
+func (t *httpRedirectTracker) CheckRedirect(req *http.Request, via []*http.Request) error {
+	prev := via[len(via)-1]
+	hop := httpTaskResultRedirect{
+		Url:      prev.URL.String(),
+		Status:   req.Response.StatusCode,
+		Location: req.Response.Header.Get("Location"),
+		RT:       time.Since(t.hopStart).Milliseconds(),
+	}
+
+	if len(via) > t.policy.max {
+		t.stop = fmt.Sprintf("stopped after %d redirects", t.policy.max)
+		return http.ErrUseLastResponse
+	}
+	if from, to := httpRequestHost(prev), httpRequestHost(req); from != to && !t.policy.allow(to) {
+		t.stop = fmt.Sprintf("cross host redirect from %s to %s is not allowed", from, to)
+		return http.ErrUseLastResponse
+	}
+
+	t.hops = append(t.hops, hop)
+	t.hopStart = time.Now()
+	return nil
+}
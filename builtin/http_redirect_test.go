@@ -0,0 +1,121 @@
+package builtin
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// /a redirects to /b on the same host, /b redirects to another host
+func TestHttpRedirect(t *testing.T) {
+	var port int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a":
+			http.Redirect(w, r, "/b", http.StatusFound)
+		case "/b":
+			// relative redirect keeps the host
+			if r.Host != "www.example.com" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			http.Redirect(w, r, fmt.Sprintf("http://localhost:%d/c", port), http.StatusMovedPermanently)
+		default:
+			w.Write([]byte("done"))
+		}
+	}))
+	defer server.Close()
+
+	target := testServerTarget(t, server.Listener.Addr())
+	port = target["port"].(int)
+
+	for _, x := range []struct {
+		name      string
+		redirect  string
+		status    int
+		url       string
+		redirects []httpTaskResultRedirect // rt is not compared
+		stop      string
+	}{
+		{
+			name:   "follow",
+			status: 200,
+			url:    fmt.Sprintf("http://localhost:%d/c", port),
+			redirects: []httpTaskResultRedirect{
+				{Url: fmt.Sprintf("http://127.0.0.1:%d/a", port), Status: 302, Location: "/b"},
+				{Url: fmt.Sprintf("http://127.0.0.1:%d/b", port), Status: 301, Location: fmt.Sprintf("http://localhost:%d/c", port)},
+			},
+		},
+		{
+			name:      "none",
+			redirect:  "redirect: none",
+			status:    302,
+			url:       fmt.Sprintf("http://127.0.0.1:%d/a", port),
+			redirects: []httpTaskResultRedirect{},
+			stop:      "stopped after 0 redirects",
+		},
+		{
+			name:     "max",
+			redirect: "redirect: {max: 1}",
+			status:   301,
+			url:      fmt.Sprintf("http://127.0.0.1:%d/b", port),
+			redirects: []httpTaskResultRedirect{
+				{Url: fmt.Sprintf("http://127.0.0.1:%d/a", port), Status: 302, Location: "/b"},
+			},
+			stop: "stopped after 1 redirects",
+		},
+		{
+			name:     "same_host",
+			redirect: "redirect: {cross_host: false}",
+			status:   301,
+			url:      fmt.Sprintf("http://127.0.0.1:%d/b", port),
+			redirects: []httpTaskResultRedirect{
+				{Url: fmt.Sprintf("http://127.0.0.1:%d/a", port), Status: 302, Location: "/b"},
+			},
+			stop: "cross host redirect from www.example.com to localhost is not allowed",
+		},
+		{
+			name:     "allow_host",
+			redirect: "redirect: {cross_host: false, allow_host: ['local*']}",
+			status:   200,
+			url:      fmt.Sprintf("http://localhost:%d/c", port),
+			redirects: []httpTaskResultRedirect{
+				{Url: fmt.Sprintf("http://127.0.0.1:%d/a", port), Status: 302, Location: "/b"},
+				{Url: fmt.Sprintf("http://127.0.0.1:%d/b", port), Status: 301, Location: fmt.Sprintf("http://localhost:%d/c", port)},
+			},
+		},
+	} {
+		stat := testRunHttpTask(t, fmt.Sprintf(`
+method: GET
+path: /a
+host: www.example.com
+%s
+`, x.redirect), target)
+
+		if stat.RespStatus != x.status {
+			t.Errorf("%s, resp_status: got %d, want %d", x.name, stat.RespStatus, x.status)
+		}
+		if stat.RespUrl != x.url {
+			t.Errorf("%s, resp_url: got %q, want %q", x.name, stat.RespUrl, x.url)
+		}
+		if stat.RespRedirectStop != x.stop {
+			t.Errorf("%s, resp_redirect_stop: got %q, want %q", x.name, stat.RespRedirectStop, x.stop)
+		}
+		if x.status == 200 && stat.RespBody != "done" {
+			t.Errorf("%s, resp_body: got %q, want %q", x.name, stat.RespBody, "done")
+		}
+
+		if len(stat.RespRedirects) != len(x.redirects) {
+			t.Errorf("%s, # of resp_redirects: got %d, want %d", x.name, len(stat.RespRedirects), len(x.redirects))
+			continue
+		}
+		for i, want := range x.redirects {
+			got := stat.RespRedirects[i]
+			got.RT = 0
+			if got != want {
+				t.Errorf("%s, resp_redirects[%d]: got %+v, want %+v", x.name, i, got, want)
+			}
+		}
+	}
+}
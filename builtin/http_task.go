@@ -9,11 +9,15 @@ import (
 
 	"github.com/mitchellh/mapstructure"
 
+	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
-	"strings"
+	"net/http/httptrace"
+	"net/url"
 	"time"
 )
 
@@ -24,15 +28,27 @@ type httpTaskTemplate struct {
 	method dvar.DVar
 	path   dvar.DVar
 	header map[string]dvar.DVar
-	body   dvar.DVar
+	body   *httpBodyTemplate
 	host   dvar.DVar
 	close  bool
 
 	timeout int64
 
-	// --------------------------------------------------------------------------
-	// TODO(dpeng): TLS related stuff, if needed
-	// --------------------------------------------------------------------------
+	// integrity of the response body
+	integrity      *integrityTemplate
+	maxBodyCapture int64
+
+	redirect *httpRedirectPolicy
+	auth     *httpAuthTemplate
+	proxy    dvar.DVar
+
+	// tls related stuff, only used when the request is https
+	tlsConfig *tls.Config
+	tlsVerify bool
+	tlsSNI    dvar.DVar
+	tlsKey    string // identity of the tls option, see transportKey
+
+	pool httpPoolOption
 
 	check check.Check
 }
@@ -57,6 +73,37 @@ type httpTaskDefine struct {
 	Body    string            `mapstructure:"body"`
 	Host    string            `mapstructure:"host"`
 	Close   bool              `mapstructure:"close"`
+	TLS     httpTaskTLSDefine `mapstructure:"tls"`
+
+	Integrity      *integrityOptionDefine `mapstructure:"integrity"`
+	MaxBodyCapture int64                  `mapstructure:"max_body_capture"`
+
+	// either follow, none or the policy
+	Redirect interface{} `mapstructure:"redirect"`
+
+	Auth *httpAuthDefine `mapstructure:"auth"`
+
+	// other sources of the body, see http_body.go
+	BodyFile   string                `mapstructure:"body_file"`
+	BodyBase64 string                `mapstructure:"body_base64"`
+	BodySize   int64                 `mapstructure:"body_size"`
+	Form       map[string]string     `mapstructure:"form"`
+	Multipart  []httpMultipartDefine `mapstructure:"multipart"`
+
+	// overrides target.proxy and the job's proxy, see http_proxy.go
+	Proxy string `mapstructure:"proxy"`
+
+	// connection pool, see http_transport.go
+	Keepalive           bool  `mapstructure:"keepalive"`
+	MaxIdleConns        int   `mapstructure:"max_idle_conns"`
+	MaxIdleConnsPerHost int   `mapstructure:"max_idle_conns_per_host"`
+	MaxConnsPerHost     int   `mapstructure:"max_conns_per_host"`
+	IdleConnTimeout     int64 `mapstructure:"idle_conn_timeout"` // in seconds
+}
+
+type httpTaskTLSDefine struct {
+	Verify bool            `mapstructure:"verify"`
+	Option tlsOptionDefine `mapstructure:",squash"`
 }
 
 type httpTaskResultTLS struct {
@@ -66,14 +113,16 @@ type httpTaskResultTLS struct {
 }
 
 type httpTaskResult struct {
-	ReqMethod string      `json:"req_method"`
-	ReqUrl    string      `json:"req_url"`
-	ReqBody   string      `json:"req_body"`
-	ReqScheme string      `json:"req_scheme"`
-	ReqIp     string      `json:"req_ip"`
-	ReqPort   uint16      `json:"req_port"`
-	ReqPath   string      `json:"req_path"`
-	ReqHeader http.Header `json:"req_header"`
+	ReqMethod   string      `json:"req_method"`
+	ReqUrl      string      `json:"req_url"`
+	ReqBody     string      `json:"req_body"`
+	ReqBodySize int64       `json:"req_body_size"`
+	ReqScheme   string      `json:"req_scheme"`
+	ReqIp       string      `json:"req_ip"`
+	ReqPort     uint16      `json:"req_port"`
+	ReqPath     string      `json:"req_path"`
+	ReqHeader   http.Header `json:"req_header"`
+	ReqProxy    string      `json:"req_proxy"` // password is redacted
 
 	RespOK     bool        `json:"resp_ok"`
 	RespError  string      `json:"resp_error"`
@@ -81,21 +130,46 @@ type httpTaskResult struct {
 	RespHeader http.Header `json:"resp_header"`
 	RespBody   string      `json:"resp_body"`
 	RespProto  string      `json:"resp_proto"`
+	RespUrl    string      `json:"resp_url"` // url of the final response
+
+	// redirects followed, and why the redirect is not followed if so
+	RespRedirects    []httpTaskResultRedirect `json:"resp_redirects"`
+	RespRedirectStop string                   `json:"resp_redirect_stop"`
+
+	// status of the proxy's reply to CONNECT, 0 if no tunnel is established
+	RespProxyStatus int `json:"resp_proxy_status"`
+
+	// whether the request is sent over a pooled connection
+	RespConnReused bool `json:"resp_conn_reused"`
+
+	// body is streamed, resp_body keeps at most max_body_capture bytes
+	RespBodySize     int64           `json:"resp_body_size"`
+	RespBodyTruncate bool            `json:"resp_body_truncate"`
+	RespIntegrity    integrityResult `json:"resp_integrity"`
 
 	// TLS related information
-	RespIsTLS bool              `json:"resp_is_tls"`
-	RespTLS   httpTaskResultTLS `json:"resp_tls"`
+	RespIsTLS          bool              `json:"resp_is_tls"`
+	RespTLS            httpTaskResultTLS `json:"resp_tls"`
+	RespTLSVerifyError string            `json:"resp_tls_verify_error"`
 
 	// time statistics, need more ??
 	Timestamp int64 `json:"timestamp"`
 	RespTTFB  int64 `json:"resp_ttfb"`
 	RespRT    int64 `json:"resp_rt"`
+
+	// fine grained timing of each phase
+	RespTiming httpTaskResultTiming `json:"resp_timing"`
 }
 
 func populateHttpTaskDefine(opt spec.TaskOption,
 ) (*httpTaskDefine, error) {
 	o := httpTaskDefine{
-		Header: make(map[string]string),
+		Header:              make(map[string]string),
+		MaxBodyCapture:      -1,
+		Keepalive:           true,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 2,
+		IdleConnTimeout:     90,
 	}
 
 	err := mapstructure.Decode(opt, &o)
@@ -155,10 +229,60 @@ func compileHttpTaskTemplate(m *httpTaskDefine,
 	}
 
 	// http.Body
-	if dv, err := dvar.NewDVarStringContext(m.Body); err != nil {
-		return nil, fmt.Errorf("http_task.Body compile failed: %s", err)
+	if bt, err := compileHttpBody(m); err != nil {
+		return nil, err
+	} else {
+		o.body = bt
+	}
+
+	// http.TLS
+	if dv, err := dvar.NewDVarStringContext(m.TLS.Option.SNI); err != nil {
+		return nil, fmt.Errorf("http_task.TLS.SNI compile failed: %s", err)
+	} else {
+		o.tlsSNI = dv
+	}
+	if cfg, err := compileTLSConfig("http_task", &m.TLS.Option); err != nil {
+		return nil, err
+	} else {
+		o.tlsConfig = cfg
+		o.tlsVerify = m.TLS.Verify
+		o.tlsKey = fmt.Sprintf("%+v", m.TLS.Option)
+	}
+
+	// http.Pool
+	if pool, err := compileHttpPool(m); err != nil {
+		return nil, err
 	} else {
-		o.body = dv
+		o.pool = pool
+	}
+
+	// http.Integrity
+	if it, err := compileIntegrity("http_task", m.Integrity); err != nil {
+		return nil, err
+	} else {
+		o.integrity = it
+		o.maxBodyCapture = m.MaxBodyCapture
+	}
+
+	// http.Redirect
+	if rp, err := compileHttpRedirect(m.Redirect); err != nil {
+		return nil, err
+	} else {
+		o.redirect = rp
+	}
+
+	// http.Auth
+	if at, err := compileHttpAuth(m.Auth); err != nil {
+		return nil, err
+	} else {
+		o.auth = at
+	}
+
+	// http.Proxy
+	if dv, err := dvar.NewDVarStringContext(m.Proxy); err != nil {
+		return nil, fmt.Errorf("http_task.Proxy compile failed: %s", err)
+	} else {
+		o.proxy = dv
 	}
 
 	if ck, err := check.CompileCheck(checkModel); err != nil {
@@ -197,9 +321,14 @@ type httpTask struct {
 	method  string
 	path    string
 	header  http.Header
-	body    string
+	body    *httpBody
 	host    string
+	sni     string
 	isHttps bool
+
+	integrity *integrityExpect
+	auth      *httpAuth
+	proxy     *url.URL
 }
 
 func newHttpTask(t *httpTaskTemplate) *httpTask {
@@ -234,7 +363,7 @@ func checkHttpPath(path string) bool {
 }
 
 // implementation of exec interface
-func (h *httpTask) Prepare(env *dvar.EvalEnv) error {
+func (h *httpTask) Prepare(_ context.Context, env *dvar.EvalEnv) error {
 	{
 		var ip string
 		if ipVal, hasIpVal := env.Get("target", "ip"); !hasIpVal {
@@ -267,10 +396,10 @@ func (h *httpTask) Prepare(env *dvar.EvalEnv) error {
 		}
 	}
 
-	if vv, err := h.t.body.Value(env); err != nil {
-		return fmt.Errorf("http_task.Body execution failed: %s", err)
+	if body, err := h.t.body.Value(env); err != nil {
+		return err
 	} else {
-		h.body = vv.String()
+		h.body = body
 	}
 
 	if vv, err := h.t.scheme.Value(env); err != nil {
@@ -322,6 +451,10 @@ func (h *httpTask) Prepare(env *dvar.EvalEnv) error {
 		}
 	}
 
+	if ct := h.body.contentType; ct != "" && h.header.Get("content-type") == "" {
+		h.header.Set("content-type", ct)
+	}
+
 	// set host if needed
 
 	// 1) If the task has host setup, ie does host overwrite, then it takes effect
@@ -353,6 +486,45 @@ func (h *httpTask) Prepare(env *dvar.EvalEnv) error {
 		}
 	}
 
+	// tls server name, if not specified then derived from the host, notes if
+	// the host is an ip address, no SNI will be sent
+	if vv, err := h.t.tlsSNI.Value(env); err != nil {
+		return fmt.Errorf("http_task.TLS.SNI execution failed: %s", err)
+	} else if sni := vv.String(); sni != "" {
+		h.sni = sni
+	} else if hostname, _, err := net.SplitHostPort(h.host); err == nil {
+		h.sni = hostname
+	} else {
+		h.sni = h.host
+	}
+
+	if it, err := h.t.integrity.Value("http_task", env); err != nil {
+		return err
+	} else {
+		h.integrity = it
+	}
+
+	if auth, err := h.t.auth.Value(env); err != nil {
+		return err
+	} else {
+		h.auth = auth
+	}
+
+	// proxy, the task's one takes priority, then target.proxy and the job's
+	if vv, err := h.t.proxy.Value(env); err != nil {
+		return fmt.Errorf("http_task.Proxy execution failed: %s", err)
+	} else {
+		proxy := vv.String()
+		if proxy == "" {
+			proxy = env.Proxy()
+		}
+		if u, err := util.ParseProxy(proxy); err != nil {
+			return fmt.Errorf("http_task.Proxy invalid: %s", err)
+		} else {
+			h.proxy = u
+		}
+	}
+
 	return nil
 }
 
@@ -360,24 +532,100 @@ func (h *httpTask) taskName() string {
 	return h.t.name
 }
 
-func (h *httpTask) bodyReader() io.Reader {
-	return strings.NewReader(h.body)
+// create the request to be sent, the header is copied so the credential added
+// by the authentication is not reported in req_header
+func (h *httpTask) newRequest(ctx context.Context, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, h.method, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("http_task, cannot create request(%s): %s", url, err)
+	}
+
+	// the body is streamed, GetBody allows it to be resent on redirect
+	if h.body.length != 0 {
+		req.Body = h.body.reader()
+		req.GetBody = func() (io.ReadCloser, error) {
+			return h.body.reader(), nil
+		}
+		req.ContentLength = h.body.length
+	} else {
+		req.Body = http.NoBody
+	}
+
+	req.Close = h.t.close
+
+	req.Header = h.header.Clone()
+	req.Host = h.host
+
+	if h.auth != nil {
+		if err := h.auth.apply(req, h.body, time.Now()); err != nil {
+			req.Body.Close()
+			return nil, fmt.Errorf("http_task, cannot authenticate request(%s): %s", url, err)
+		}
+	}
+	return req, nil
+}
+
+// failure of the peer chain verification, reported separately from the
+// request error
+type httpTLSVerifyError struct {
+	err error
+}
+
+func (e *httpTLSVerifyError) Error() string {
+	return e.err.Error()
+}
+
+// tls config used by the transport. If verification is enabled, the peer chain
+// is verified against the configured CA bundle, the failure is returned as
+// httpTLSVerifyError by the handshake
+func (h *httpTask) tlsConfig() *tls.Config {
+	cfg := h.t.tlsConfig.Clone()
+	cfg.ServerName = h.sni
+
+	if h.t.tlsVerify {
+		roots := cfg.RootCAs
+		sni := h.sni
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			if err := verifyTLSChain(cs.PeerCertificates, roots, sni); err != nil {
+				return &httpTLSVerifyError{err: err}
+			}
+			return nil
+		}
+	}
+	return cfg
+}
+
+func (h *httpTask) forceHttp2() bool {
+	for _, proto := range h.t.tlsConfig.NextProtos {
+		if proto == "h2" {
+			return true
+		}
+	}
+	return false
 }
 
 // run the task
-func (h *httpTask) doRunHttp(env *dvar.EvalEnv) (*httpTaskResult, error) {
+func (h *httpTask) doRunHttp(
+	ctx context.Context,
+	env *dvar.EvalEnv,
+) (*httpTaskResult, error) {
 	out := &httpTaskResult{}
 
+	redirect := newHttpRedirectTracker(h.t.redirect)
+
+	transport, release := h.transport(ctx)
+	defer release()
+
 	// perform the http task requests and return everything into the global table
 	client := &http.Client{
-		Timeout: time.Duration(h.t.timeout) * time.Second,
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true,
-			},
-		},
+		Timeout:       time.Duration(h.t.timeout) * time.Second,
+		Transport:     transport,
+		CheckRedirect: redirect.CheckRedirect,
 	}
 
+	proxyTrace := &httpProxyTrace{}
+	ctx = withHttpProxyTrace(ctx, proxyTrace)
+
 	var scheme string
 	if h.isHttps {
 		scheme = "https"
@@ -392,22 +640,22 @@ func (h *httpTask) doRunHttp(env *dvar.EvalEnv) (*httpTaskResult, error) {
 		h.path, // path
 	)
 
-	req, err := http.NewRequest(h.method, url, h.bodyReader())
+	tracer := newHttpTracer()
+	req, err := h.newRequest(httptrace.WithClientTrace(ctx, tracer.ClientTrace()), url)
 	if err != nil {
-		return nil, fmt.Errorf("http_task, cannot create request: %s", url, err)
+		return nil, err
 	}
 
-	req.Close = h.t.close
-
-	req.Header = h.header
-	req.Host = h.host
-
 	respStatusCode := 0
 	respHeader := make(http.Header)
 	respBody := ""
+	respBodySize := int64(0)
+	respBodyTruncate := false
+	respIntegrity := integrityResult{}
 	respError := ""
 	respHasError := false
 	respProto := ""
+	respUrl := url
 	respIsTls := false
 
 	respTlsVer := ""
@@ -416,22 +664,59 @@ func (h *httpTask) doRunHttp(env *dvar.EvalEnv) (*httpTaskResult, error) {
 
 	httpReqTs := time.Now().UnixMilli()
 	resp, err := client.Do(req)
+
+	// answer the digest challenge, the request is sent again and only the
+	// second one is measured
+	if err == nil && h.auth != nil && len(redirect.hops) == 0 {
+		authz, ok, cerr := h.auth.challenge(resp, req.Method, req.URL.RequestURI(), h.body)
+		if cerr != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			err = fmt.Errorf("digest challenge failed: %s", cerr)
+		} else if ok {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+
+			tracer = newHttpTracer()
+			req, err = h.newRequest(httptrace.WithClientTrace(ctx, tracer.ClientTrace()), url)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Authorization", authz)
+
+			httpReqTs = time.Now().UnixMilli()
+			resp, err = client.Do(req)
+		}
+	}
 	httpRespTs := time.Now().UnixMilli()
 
 	var httpBodyTs int64
+	var httpDone time.Time
 
 	if err != nil {
+		httpDone = time.Now()
 		respStatusCode = 0
 		respError = fmt.Sprintf("%s", err)
 		respHasError = true
 	} else {
 		defer resp.Body.Close()
-		data, err := io.ReadAll(resp.Body)
+		data, err := readBodyWithIntegrity(
+			resp.Body,
+			resp.Header,
+			h.integrity,
+			h.t.maxBodyCapture,
+		)
 		if err != nil {
 			return nil, fmt.Errorf("http_task, client response body failed to read: %s", err)
 		}
-		httpBodyTs = time.Now().UnixMilli()
-		respBody = string(data)
+		httpDone = time.Now()
+		httpBodyTs = httpDone.UnixMilli()
+		respBody = data.data
+		respBodySize = data.size
+		respBodyTruncate = data.truncate
+		respIntegrity = data.result
+		respProto = resp.Proto
+		respUrl = resp.Request.URL.String()
 		respHeader = resp.Header
 		respStatusCode = resp.StatusCode
 
@@ -445,12 +730,16 @@ func (h *httpTask) doRunHttp(env *dvar.EvalEnv) (*httpTaskResult, error) {
 
 	out.ReqMethod = h.method
 	out.ReqUrl = url
-	out.ReqBody = h.body
+	out.ReqBody = h.body.text
+	out.ReqBodySize = h.body.length
 	out.ReqScheme = scheme
 	out.ReqIp = h.ip
 	out.ReqPort = h.port
 	out.ReqPath = h.path
 	out.ReqHeader = h.header
+	if h.proxy != nil {
+		out.ReqProxy = h.proxy.Redacted()
+	}
 
 	out.RespOK = !respHasError
 	out.RespError = respError
@@ -458,16 +747,32 @@ func (h *httpTask) doRunHttp(env *dvar.EvalEnv) (*httpTaskResult, error) {
 	out.RespHeader = respHeader
 	out.RespBody = respBody
 	out.RespProto = respProto
+	out.RespUrl = respUrl
+	out.RespRedirects = redirect.hops
+	out.RespRedirectStop = redirect.stop
+	out.RespBodySize = respBodySize
+	out.RespBodyTruncate = respBodyTruncate
+	out.RespIntegrity = respIntegrity
 
 	out.RespTTFB = (httpRespTs - httpReqTs)
 	out.RespRT = (httpBodyTs - httpReqTs)
 	out.Timestamp = httpReqTs
+	out.RespTiming = tracer.Timing(httpDone)
+	out.RespConnReused = out.RespTiming.ConnReused
+
+	proxyStatus, proxyConnect := proxyTrace.result()
+	out.RespProxyStatus = proxyStatus
+	out.RespTiming.ProxyConnect = proxyConnect.Milliseconds()
 
 	// TLS related stuff
 	out.RespIsTLS = respIsTls
 	out.RespTLS.Version = respTlsVer
 	out.RespTLS.CipherSuite = respTlsCipher
 	out.RespTLS.NegotiatedProtocol = respTlsNProto
+	var tlsVerifyErr *httpTLSVerifyError
+	if errors.As(tracer.TLSError(), &tlsVerifyErr) {
+		out.RespTLSVerifyError = fmt.Sprintf("%s", tlsVerifyErr)
+	}
 
 	return out, nil
 }
@@ -482,8 +787,8 @@ func (h *httpTask) populateResult(env *dvar.EvalEnv, r *httpTaskResult) error {
 	return nil
 }
 
-func (h *httpTask) runHttp(env *dvar.EvalEnv) error {
-	stat, err := h.doRunHttp(env)
+func (h *httpTask) runHttp(ctx context.Context, env *dvar.EvalEnv) error {
+	stat, err := h.doRunHttp(ctx, env)
 	if err != nil {
 		return err
 	}
@@ -494,8 +799,8 @@ func (h *httpTask) runCheck(env *dvar.EvalEnv) error {
 	return h.t.check.Run(env)
 }
 
-func (h *httpTask) Run(env *dvar.EvalEnv) error {
-	if err := h.runHttp(env); err != nil {
+func (h *httpTask) Run(ctx context.Context, env *dvar.EvalEnv) error {
+	if err := h.runHttp(ctx, env); err != nil {
 		return err
 	}
 	if err := h.runCheck(env); err != nil {
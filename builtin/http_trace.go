@@ -0,0 +1,141 @@
+package builtin
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Breakdown of http request's timing, collected via net/http/httptrace. All
+// the duration is in milliseconds, and a phase that does not happen, ie DNS
+// when requesting an ip address or connect when connection is reused, is 0
+
+type httpTaskResultTiming struct {
+	DNS          int64 `json:"dns"`
+	Connect      int64 `json:"connect"`
+	TLSHandshake int64 `json:"tls_handshake"`
+	Wait         int64 `json:"wait"` // request written till first response byte
+	Transfer     int64 `json:"transfer"`
+	Total        int64 `json:"total"`
+
+	// CONNECT of the proxy tunnel, connect is the one to the proxy then
+	ProxyConnect int64 `json:"proxy_connect"`
+
+	// connection reuse information
+	ConnReused   bool  `json:"conn_reused"`
+	ConnWasIdle  bool  `json:"conn_was_idle"`
+	ConnIdleTime int64 `json:"conn_idle_time"`
+}
+
+type httpTracer struct {
+	sync.Mutex
+
+	start        time.Time
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	gotConn      time.Time
+	wroteRequest time.Time
+	firstByte    time.Time
+
+	connInfo httptrace.GotConnInfo
+	tlsErr   error
+}
+
+func newHttpTracer() *httpTracer {
+	return &httpTracer{
+		start: time.Now(),
+	}
+}
+
+// callbacks may be invoked from different goroutines, ie dialing multiple
+// addresses in parallel, so every callback is guarded by the lock
+func (t *httpTracer) mark(f func()) {
+	t.Lock()
+	defer t.Unlock()
+	f()
+}
+
+func (t *httpTracer) ClientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mark(func() { t.dnsStart = time.Now() })
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mark(func() { t.dnsDone = time.Now() })
+		},
+		ConnectStart: func(string, string) {
+			t.mark(func() {
+				if t.connectStart.IsZero() {
+					t.connectStart = time.Now()
+				}
+			})
+		},
+		ConnectDone: func(string, string, error) {
+			t.mark(func() { t.connectDone = time.Now() })
+		},
+		TLSHandshakeStart: func() {
+			t.mark(func() { t.tlsStart = time.Now() })
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			t.mark(func() {
+				t.tlsDone = time.Now()
+				t.tlsErr = err
+			})
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mark(func() {
+				t.gotConn = time.Now()
+				t.connInfo = info
+			})
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			t.mark(func() { t.wroteRequest = time.Now() })
+		},
+		GotFirstResponseByte: func() {
+			t.mark(func() { t.firstByte = time.Now() })
+		},
+	}
+}
+
+// error of the TLS handshake with the target if any
+func (t *httpTracer) TLSError() error {
+	t.Lock()
+	defer t.Unlock()
+	return t.tlsErr
+}
+
+func httpTraceSpan(from, to time.Time) int64 {
+	if from.IsZero() || to.IsZero() || to.Before(from) {
+		return 0
+	}
+	return to.Sub(from).Milliseconds()
+}
+
+// generate the timing breakdown, done is the time when the body is fully read
+// or when the request failed
+func (t *httpTracer) Timing(done time.Time) httpTaskResultTiming {
+	t.Lock()
+	defer t.Unlock()
+
+	waitFrom := t.wroteRequest
+	if waitFrom.IsZero() {
+		waitFrom = t.gotConn
+	}
+
+	return httpTaskResultTiming{
+		DNS:          httpTraceSpan(t.dnsStart, t.dnsDone),
+		Connect:      httpTraceSpan(t.connectStart, t.connectDone),
+		TLSHandshake: httpTraceSpan(t.tlsStart, t.tlsDone),
+		Wait:         httpTraceSpan(waitFrom, t.firstByte),
+		Transfer:     httpTraceSpan(t.firstByte, done),
+		Total:        httpTraceSpan(t.start, done),
+		ConnReused:   t.connInfo.Reused,
+		ConnWasIdle:  t.connInfo.WasIdle,
+		ConnIdleTime: t.connInfo.IdleTime.Milliseconds(),
+	}
+}
@@ -0,0 +1,99 @@
+package builtin
+
+import (
+	"github.com/dianpeng/hi-doctor/task"
+
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Transport of the http task. The transports are shared by all the http tasks
+// of the job, keyed by the TLS, proxy and pool settings, so the connection is
+// reused across the tasks, the targets and the runs. They are released when the
+// job is stopped.
+//
+//   keepalive: true                   # default, false closes the connection
+//                                     # after each request
+//   max_idle_conns: 100               # idle connections of the transport
+//   max_idle_conns_per_host: 2        # idle connections of each host
+//   max_conns_per_host: 0             # connections of each host, 0 is unlimited
+//   idle_conn_timeout: 90             # in seconds
+
+type httpPoolOption struct {
+	keepalive           bool
+	maxIdleConns        int
+	maxIdleConnsPerHost int
+	maxConnsPerHost     int
+	idleConnTimeout     time.Duration
+}
+
+func compileHttpPool(m *httpTaskDefine) (httpPoolOption, error) {
+	if m.MaxIdleConns < 0 || m.MaxIdleConnsPerHost < 0 || m.MaxConnsPerHost < 0 {
+		return httpPoolOption{}, fmt.Errorf("http_task, connection pool limit must not be negative")
+	}
+	if m.IdleConnTimeout < 0 {
+		return httpPoolOption{}, fmt.Errorf("http_task.IdleConnTimeout must not be negative")
+	}
+	return httpPoolOption{
+		keepalive:           m.Keepalive,
+		maxIdleConns:        m.MaxIdleConns,
+		maxIdleConnsPerHost: m.MaxIdleConnsPerHost,
+		maxConnsPerHost:     m.MaxConnsPerHost,
+		idleConnTimeout:     time.Duration(m.IdleConnTimeout) * time.Second,
+	}, nil
+}
+
+// every setting of the transport must be part of the key
+func (h *httpTask) transportKey() string {
+	proxy := ""
+	if h.proxy != nil {
+		proxy = h.proxy.String()
+	}
+	return fmt.Sprintf(
+		"http{tls: %s, verify: %t, sni: %s, https: %t, proxy: %s, pool: %+v}",
+		h.t.tlsKey,
+		h.t.tlsVerify,
+		h.sni,
+		h.isHttps,
+		proxy,
+		h.t.pool,
+	)
+}
+
+func (h *httpTask) newTransport() *http.Transport {
+	pool := h.t.pool
+	tr := &http.Transport{
+		TLSClientConfig:     h.tlsConfig(),
+		ForceAttemptHTTP2:   h.forceHttp2(),
+		DisableKeepAlives:   !pool.keepalive,
+		MaxIdleConns:        pool.maxIdleConns,
+		MaxIdleConnsPerHost: pool.maxIdleConnsPerHost,
+		MaxConnsPerHost:     pool.maxConnsPerHost,
+		IdleConnTimeout:     pool.idleConnTimeout,
+	}
+	setupHttpProxy(tr, h.proxy, h.isHttps)
+	return tr
+}
+
+// transport of the request, the returned function must be called once the
+// request is done, it closes the transport unless it is shared
+func (h *httpTask) transport(ctx context.Context) (*http.Transport, func()) {
+	create := func() (interface{}, func()) {
+		tr := h.newTransport()
+		return tr, tr.CloseIdleConnections
+	}
+
+	if shared := task.GetShared(ctx); shared != nil {
+		v, ok := shared.Get(h.transportKey(), create)
+		tr := v.(*http.Transport)
+		if ok {
+			return tr, func() {}
+		}
+		return tr, tr.CloseIdleConnections
+	}
+
+	tr := h.newTransport()
+	return tr, tr.CloseIdleConnections
+}
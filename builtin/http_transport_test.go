@@ -0,0 +1,63 @@
+package builtin
+
+import (
+	"github.com/dianpeng/hi-doctor/task"
+
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHttpTransport(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	})
+
+	plain := httptest.NewServer(handler)
+	defer plain.Close()
+	secure := httptest.NewTLSServer(handler)
+	defer secure.Close()
+
+	// the resources shared by the tasks of a job
+	shared := task.NewShared()
+	defer shared.Release()
+	ctx := task.WithShared(context.Background(), shared)
+
+	for _, x := range []struct {
+		name   string
+		option string
+		target *httptest.Server
+		ok     bool
+		reused bool
+	}{
+		{"cold", "{method: GET, path: /}", plain, true, false},
+		{"warm", "{method: GET, path: /}", plain, true, true},
+		{"close1", "{method: GET, path: /, keepalive: false}", plain, true, false},
+		{"close2", "{method: GET, path: /, keepalive: false}", plain, true, false},
+		{"tls1", "{method: GET, scheme: https, path: /}", secure, true, false},
+		{"tls2", "{method: GET, scheme: https, path: /}", secure, true, true},
+
+		// different TLS setting, so not the same transport
+		{"verify", "{method: GET, scheme: https, path: /, tls: {verify: true}}", secure, false, false},
+	} {
+		tk, env := testPrepareTask(t, "http", x.option, testServerTarget(t, x.target.Listener.Addr()))
+		stat, err := tk.(*httpTask).doRunHttp(ctx, env)
+		if err != nil {
+			t.Fatalf("%s, run failed: %s", x.name, err)
+		}
+
+		if stat.RespOK != x.ok {
+			t.Errorf("%s, resp_ok: got %t, want %t, resp_error: %s", x.name, stat.RespOK, x.ok, stat.RespError)
+		}
+		if stat.RespConnReused != x.reused {
+			t.Errorf("%s, resp_conn_reused: got %t, want %t", x.name, stat.RespConnReused, x.reused)
+		}
+		if x.reused && stat.RespTiming.Connect != 0 {
+			t.Errorf("%s, resp_timing.connect: got %d, want 0", x.name, stat.RespTiming.Connect)
+		}
+		if x.name == "verify" && stat.RespTLSVerifyError == "" {
+			t.Errorf("%s, resp_tls_verify_error: got empty, want non-empty", x.name)
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package builtin
+
+import (
+	"github.com/dianpeng/hi-doctor/dvar"
+	"github.com/dianpeng/hi-doctor/util"
+
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// helper functions for checking the integrity of a body while it is streamed,
+// shared by the tasks that download content, ie http and oss_get. The digests
+// are computed on the fly and only max_body_capture bytes of the body are kept
+// in memory, so a large object can be verified without holding it.
+//
+//   integrity:
+//     size: 1024                 # expected size of the body
+//     md5: <hex or base64>       # expected digest, also sha1/sha256/crc32/crc32c
+//     header: true               # compare with Content-MD5, ETag and
+//                                # x-amz-checksum-* of the response
+//     digest: [sha256]           # digest always computed and reported
+//   max_body_capture: 4096       # bytes of body kept, negative means all
+
+var integrityAlgorithm = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"crc32": func() hash.Hash {
+		return crc32.NewIEEE()
+	},
+	"crc32c": func() hash.Hash {
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	},
+}
+
+type integrityOptionDefine struct {
+	Size   interface{} `mapstructure:"size"`
+	MD5    string      `mapstructure:"md5"`
+	SHA1   string      `mapstructure:"sha1"`
+	SHA256 string      `mapstructure:"sha256"`
+	CRC32  string      `mapstructure:"crc32"`
+	CRC32C string      `mapstructure:"crc32c"`
+	Header bool        `mapstructure:"header"`
+	Digest []string    `mapstructure:"digest"`
+}
+
+type integrityTemplate struct {
+	size   dvar.DVar
+	expect map[string]dvar.DVar // algorithm -> expected digest
+	header bool
+	digest []string
+}
+
+// expectation of a single run, materialized from the template
+type integrityExpect struct {
+	size   int64 // negative means not checked
+	expect map[string][]byte
+	header bool
+	digest []string
+}
+
+type integrityResult struct {
+	OK      bool              `json:"ok"`
+	Error   string            `json:"error"`
+	Checked []string          `json:"checked"`
+	Digest  map[string]string `json:"digest"`
+}
+
+func compileIntegrity(jobName string, d *integrityOptionDefine) (*integrityTemplate, error) {
+	if d == nil {
+		return nil, nil
+	}
+
+	out := &integrityTemplate{
+		expect: make(map[string]dvar.DVar),
+		header: d.Header,
+	}
+
+	switch v := d.Size.(type) {
+	case nil:
+		out.size = dvar.NewDVarLit("")
+	case string:
+		if dv, err := dvar.NewDVarStringContext(v); err != nil {
+			return nil, fmt.Errorf("%s integrity.size compile failed: %s", jobName, err)
+		} else {
+			out.size = dv
+		}
+	default:
+		if n, ok := util.ToInt(v, false); !ok {
+			return nil, fmt.Errorf("%s integrity.size must be integer", jobName)
+		} else {
+			out.size = dvar.NewDVarLit(fmt.Sprintf("%d", n))
+		}
+	}
+
+	for name, v := range map[string]string{
+		"md5":    d.MD5,
+		"sha1":   d.SHA1,
+		"sha256": d.SHA256,
+		"crc32":  d.CRC32,
+		"crc32c": d.CRC32C,
+	} {
+		if v == "" {
+			continue
+		}
+		if dv, err := dvar.NewDVarStringContext(v); err != nil {
+			return nil, fmt.Errorf("%s integrity.%s compile failed: %s", jobName, name, err)
+		} else {
+			out.expect[name] = dv
+		}
+	}
+
+	for _, name := range d.Digest {
+		if _, ok := integrityAlgorithm[name]; !ok {
+			return nil, fmt.Errorf("%s integrity.digest %s is unknown", jobName, name)
+		}
+		out.digest = append(out.digest, name)
+	}
+	return out, nil
+}
+
+// evaluate the template, nil template results in nil expectation, ie nothing
+// is checked
+func (t *integrityTemplate) Value(jobName string, env *dvar.EvalEnv) (*integrityExpect, error) {
+	if t == nil {
+		return nil, nil
+	}
+
+	out := &integrityExpect{
+		size:   -1,
+		expect: make(map[string][]byte),
+		header: t.header,
+		digest: t.digest,
+	}
+
+	if v, err := t.size.Value(env); err != nil {
+		return nil, fmt.Errorf("%s integrity.size execution failed: %s", jobName, err)
+	} else if str := v.String(); str != "" {
+		if n, err := strconv.ParseInt(str, 10, 64); err != nil || n < 0 {
+			return nil, fmt.Errorf("%s integrity.size %s is invalid", jobName, str)
+		} else {
+			out.size = n
+		}
+	}
+
+	for name, dv := range t.expect {
+		v, err := dv.Value(env)
+		if err != nil {
+			return nil, fmt.Errorf("%s integrity.%s execution failed: %s", jobName, name, err)
+		}
+		if sum, ok := decodeDigest(name, v.String()); !ok {
+			return nil, fmt.Errorf("%s integrity.%s %s is not a valid hex or base64 digest", jobName, name, v.String())
+		} else {
+			out.expect[name] = sum
+		}
+	}
+	return out, nil
+}
+
+// digest in hex or base64 encoding
+func decodeDigest(name string, x string) ([]byte, bool) {
+	size := integrityAlgorithm[name]().Size()
+	x = strings.TrimSpace(x)
+
+	if len(x) == size*2 {
+		if v, err := hex.DecodeString(x); err == nil {
+			return v, true
+		}
+	}
+	if v, err := base64.StdEncoding.DecodeString(x); err == nil && len(v) == size {
+		return v, true
+	}
+	return nil, false
+}
+
+// digest supplied by the response header, the md5 based ETag of multipart
+// upload, ie with a dash, is not a digest of the content and is ignored
+func headerDigest(header http.Header) map[string][]byte {
+	out := make(map[string][]byte)
+
+	if v := header.Get("Content-MD5"); v != "" {
+		if sum, ok := decodeDigest("md5", v); ok {
+			out["content-md5"] = sum
+		}
+	}
+	if v := header.Get("ETag"); v != "" {
+		v = strings.Trim(strings.TrimPrefix(v, "W/"), "\"")
+		if len(v) == md5.Size*2 {
+			if sum, err := hex.DecodeString(v); err == nil {
+				out["etag"] = sum
+			}
+		}
+	}
+	for name := range integrityAlgorithm {
+		if v := header.Get("X-Amz-Checksum-" + name); v != "" {
+			if sum, ok := decodeDigest(name, v); ok {
+				out["x-amz-checksum-"+name] = sum
+			}
+		}
+	}
+	return out
+}
+
+func headerDigestAlgorithm(name string) string {
+	switch name {
+	case "content-md5", "etag":
+		return "md5"
+	default:
+		return strings.TrimPrefix(name, "x-amz-checksum-")
+	}
+}
+
+// keeps up to max bytes written into it, negative max means no limit
+type captureWriter struct {
+	buf      bytes.Buffer
+	max      int64
+	truncate bool
+}
+
+func (w *captureWriter) Write(p []byte) (int, error) {
+	if w.max >= 0 {
+		if left := w.max - int64(w.buf.Len()); int64(len(p)) > left {
+			w.truncate = true
+			w.buf.Write(p[:left])
+			return len(p), nil
+		}
+	}
+	return w.buf.Write(p)
+}
+
+// result of streaming a body
+type integrityBody struct {
+	data     string
+	size     int64
+	truncate bool
+	result   integrityResult
+}
+
+// stream the body, computes the digests needed and keeps at most maxCapture
+// bytes of it. The integrity failure is reported in the result, the error is
+// only returned when the body cannot be read
+func readBodyWithIntegrity(
+	body io.Reader,
+	header http.Header,
+	expect *integrityExpect,
+	maxCapture int64,
+) (*integrityBody, error) {
+	capture := &captureWriter{
+		max: maxCapture,
+	}
+	writer := []io.Writer{capture}
+	hasher := make(map[string]hash.Hash)
+
+	var fromHeader map[string][]byte
+	if expect != nil {
+		need := append([]string{}, expect.digest...)
+		for name := range expect.expect {
+			need = append(need, name)
+		}
+		if expect.header {
+			fromHeader = headerDigest(header)
+			for name := range fromHeader {
+				need = append(need, headerDigestAlgorithm(name))
+			}
+		}
+		for _, name := range need {
+			if _, ok := hasher[name]; !ok {
+				hasher[name] = integrityAlgorithm[name]()
+				writer = append(writer, hasher[name])
+			}
+		}
+	}
+
+	n, err := io.Copy(io.MultiWriter(writer...), body)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &integrityBody{
+		data:     capture.buf.String(),
+		size:     n,
+		truncate: capture.truncate,
+		result: integrityResult{
+			OK:      true,
+			Checked: []string{},
+			Digest:  make(map[string]string),
+		},
+	}
+	if expect == nil {
+		return out, nil
+	}
+
+	sum := make(map[string][]byte)
+	for name, h := range hasher {
+		sum[name] = h.Sum(nil)
+		out.result.Digest[name] = hex.EncodeToString(sum[name])
+	}
+
+	fail := []string{}
+	if expect.size >= 0 {
+		out.result.Checked = append(out.result.Checked, "size")
+		if expect.size != n {
+			fail = append(fail, fmt.Sprintf("size mismatch, expect %d, got %d", expect.size, n))
+		}
+	}
+
+	compare := func(what string, name string, want []byte) {
+		out.result.Checked = append(out.result.Checked, what)
+		if !bytes.Equal(want, sum[name]) {
+			fail = append(fail, fmt.Sprintf(
+				"%s mismatch, expect %s, got %s",
+				what,
+				hex.EncodeToString(want),
+				hex.EncodeToString(sum[name]),
+			))
+		}
+	}
+
+	for _, name := range sortedKeys(expect.expect) {
+		compare(name, name, expect.expect[name])
+	}
+	for _, name := range sortedKeys(fromHeader) {
+		compare("header:"+name, headerDigestAlgorithm(name), fromHeader[name])
+	}
+
+	if len(fail) != 0 {
+		out.result.OK = false
+		out.result.Error = strings.Join(fail, "; ")
+	}
+	return out, nil
+}
+
+func sortedKeys(x map[string][]byte) []string {
+	out := make([]string, 0, len(x))
+	for k := range x {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,117 @@
+package builtin
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadBodyWithIntegrity(t *testing.T) {
+	body := "hello world"
+	md5Sum := md5.Sum([]byte(body))
+	shaSum := sha256.Sum256([]byte(body))
+	crc := crc32.Checksum([]byte(body), crc32.MakeTable(crc32.Castagnoli))
+	crcSum := []byte{byte(crc >> 24), byte(crc >> 16), byte(crc >> 8), byte(crc)}
+
+	header := http.Header{}
+	header.Set("ETag", "\""+hex.EncodeToString(md5Sum[:])+"\"")
+	header.Set("Content-MD5", base64.StdEncoding.EncodeToString(md5Sum[:]))
+	header.Set("X-Amz-Checksum-Crc32c", base64.StdEncoding.EncodeToString(crcSum))
+
+	expect := &integrityExpect{
+		size: 11,
+		expect: map[string][]byte{
+			"sha256": shaSum[:],
+		},
+		header: true,
+	}
+
+	r, err := readBodyWithIntegrity(strings.NewReader(body), header, expect, 5)
+	if err != nil {
+		t.Fatalf("read failed: %s", err)
+	}
+	if r.data != "hello" || r.size != 11 || !r.truncate {
+		t.Fatalf("unexpected capture: %+v", r)
+	}
+	if !r.result.OK || len(r.result.Checked) != 5 || r.result.Digest["crc32c"] != hex.EncodeToString(crcSum) {
+		t.Fatalf("unexpected result: %+v", r.result)
+	}
+
+	// multipart etag is ignored, the sha256 and size mismatch
+	header.Set("ETag", "\"d41d8cd98f00b204e9800998ecf8427e-2\"")
+	r, _ = readBodyWithIntegrity(strings.NewReader(body+"!"), header, expect, -1)
+	if r.result.OK || r.data != body+"!" || r.truncate ||
+		!strings.Contains(r.result.Error, "size mismatch") ||
+		!strings.Contains(r.result.Error, "sha256 mismatch") ||
+		!strings.Contains(r.result.Error, "header:content-md5 mismatch") ||
+		strings.Contains(r.result.Error, "etag") {
+		t.Fatalf("unexpected result: %+v", r.result)
+	}
+
+	if _, ok := decodeDigest("md5", "xyz"); ok {
+		t.Fatalf("invalid digest should fail")
+	}
+}
+
+// a large body is verified against the Content-MD5 header and the expected
+// size, while only the head of it is captured
+func TestHttpIntegrity(t *testing.T) {
+	body := strings.Repeat("a", 1<<20)
+	sum := md5.Sum([]byte(body))
+	digest := sha256.Sum256([]byte(body[1:]))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+		if r.URL.Path == "/bad" {
+			w.Write([]byte(body[1:]))
+		} else {
+			w.Write([]byte(body))
+		}
+	}))
+	defer server.Close()
+	target := testServerTarget(t, server.Listener.Addr())
+
+	stat := testRunHttpTask(t, `
+method: GET
+path: /good
+max_body_capture: 4
+integrity:
+  size: 1048576
+  header: true
+`, target)
+	if !stat.RespIntegrity.OK {
+		t.Errorf("good, resp_integrity.ok: got false, want true, error: %s", stat.RespIntegrity.Error)
+	}
+	if stat.RespBody != "aaaa" {
+		t.Errorf("good, resp_body: got %q, want %q", stat.RespBody, "aaaa")
+	}
+	if !stat.RespBodyTruncate {
+		t.Errorf("good, resp_body_truncate: got false, want true")
+	}
+	if stat.RespBodySize != 1<<20 {
+		t.Errorf("good, resp_body_size: got %d, want %d", stat.RespBodySize, 1<<20)
+	}
+
+	stat = testRunHttpTask(t, `
+method: GET
+path: /bad
+integrity:
+  header: true
+  digest: [sha256]
+`, target)
+	if stat.RespIntegrity.OK {
+		t.Errorf("bad, resp_integrity.ok: got true, want false")
+	}
+	if stat.RespIntegrity.Error == "" {
+		t.Errorf("bad, resp_integrity.error: got empty, want non-empty")
+	}
+	if got, want := stat.RespIntegrity.Digest["sha256"], hex.EncodeToString(digest[:]); got != want {
+		t.Errorf("bad, resp_integrity.digest.sha256: got %q, want %q", got, want)
+	}
+}
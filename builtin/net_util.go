@@ -0,0 +1,81 @@
+package builtin
+
+import (
+	"github.com/dianpeng/hi-doctor/dvar"
+
+	"context"
+	"fmt"
+	"net"
+)
+
+// helper functions for working with raw connections
+
+// address of the target, target.addr takes priority over target.ip
+func targetAddress(jobName string, env *dvar.EvalEnv) (string, error) {
+	if dv, ok := env.Get("target", "addr"); ok {
+		return dv.String(), nil
+	} else if dv, ok := env.Get("target", "ip"); ok {
+		return dv.String(), nil
+	}
+	return "", fmt.Errorf(
+		"%s does not have proper address define, either address/ip should be defined",
+		jobName,
+	)
+}
+
+// ports of the target, either target.port or target.port_list
+func targetPortList(jobName string, env *dvar.EvalEnv) ([]uint16, error) {
+	if dv, ok := env.Get("target", "port"); ok {
+		if port, ok := dv.Port(); !ok {
+			return nil, fmt.Errorf("%s, invalid port number", jobName)
+		} else {
+			return []uint16{port}, nil
+		}
+	} else if dv, ok := env.Get("target", "port_list"); ok {
+		if prList, ok := dv.PortList(); !ok {
+			return nil, fmt.Errorf("%s, invalid port list", jobName)
+		} else {
+			return prList, nil
+		}
+	}
+	return nil, fmt.Errorf("%s does not have port/port_list definition", jobName)
+}
+
+// port of the tasks connecting a single port, the first one of the target's
+// ports. The def is used when the target has neither port nor port_list, 0
+// means the port is required
+func targetPort(jobName string, env *dvar.EvalEnv, def uint16) (uint16, error) {
+	_, hasPort := env.Get("target", "port")
+	_, hasPortList := env.Get("target", "port_list")
+	if !hasPort && !hasPortList && def != 0 {
+		return def, nil
+	}
+
+	pr, err := targetPortList(jobName, env)
+	if err != nil {
+		return 0, err
+	}
+	if len(pr) == 0 {
+		return 0, fmt.Errorf("%s, empty port list", jobName)
+	}
+	return pr[0], nil
+}
+
+// Close the connection once the context is done, so any blocking io on it is
+// aborted. The returned function stops watching and closes the connection, it
+// is expected to be deferred right after the connection is established
+func closeOnDone(ctx context.Context, conn net.Conn) func() {
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+			break
+		}
+	}()
+	return func() {
+		close(stop)
+		conn.Close()
+	}
+}
@@ -0,0 +1,296 @@
+package builtin
+
+import (
+	"github.com/dianpeng/hi-doctor/check"
+	"github.com/dianpeng/hi-doctor/dvar"
+	"github.com/dianpeng/hi-doctor/spec"
+	"github.com/dianpeng/hi-doctor/task"
+	"github.com/dianpeng/hi-doctor/util"
+
+	"github.com/mitchellh/mapstructure"
+
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// NTP task, sends a SNTP(RFC 4330) client request to the target and records
+// the clock offset of the local host against the server along with the round
+// trip delay and the server status. The port is the option port, then
+// target.port or the first one of target.port_list, otherwise 123.
+//
+//   offset = ((T2 - T1) + (T3 - T4)) / 2
+//   delay  = (T4 - T1) - (T3 - T2)
+//
+// T1 is the time request sent, T2 is the time server receives it, T3 is the
+// time server sends the reply and T4 is the time reply is received. A positive
+// offset means the server clock is ahead of the local one.
+
+const (
+	ntpPacketSize = 48
+	ntpDefPort    = 123
+
+	// seconds between 1900-01-01 and 1970-01-01
+	ntpEpochOffset = 2208988800
+
+	ntpModeClient = 3
+	ntpModeServer = 4
+	ntpVersion    = 4
+)
+
+type ntpTaskTemplate struct {
+	name    string
+	timeout time.Duration
+	port    uint16
+	check   check.Check
+}
+
+type ntpTask struct {
+	t       *ntpTaskTemplate
+	address string
+	port    uint16
+}
+
+type ntpTaskDefine struct {
+	Name    string `mapstructure:"name"`
+	Timeout int64  `mapstructure:"timeout"` // in seconds
+	Port    uint16 `mapstructure:"port"`
+}
+
+type ntpTaskResult struct {
+	Timestamp    int64  `json:"timestamp"`
+	RT           int64  `json:"rt"`
+	Port         uint16 `json:"port"`
+	Address      string `json:"address"`
+	LocalAddress string `json:"local_address"`
+	OK           bool   `json:"ok"`
+	Error        string `json:"error"`
+
+	OffsetMs         float64 `json:"offset_ms"`
+	DelayMs          float64 `json:"delay_ms"`
+	Stratum          int     `json:"stratum"`
+	ReferenceID      string  `json:"reference_id"`
+	Leap             int     `json:"leap"`
+	Version          int     `json:"version"`
+	Precision        int     `json:"precision"`
+	RootDelayMs      float64 `json:"root_delay_ms"`
+	RootDispersionMs float64 `json:"root_dispersion_ms"`
+	ReferenceTime    int64   `json:"reference_time"` // unix ms
+	Kiss             string  `json:"kiss"`           // kiss code, stratum 0
+}
+
+type ntpTaskFactory struct{}
+
+func (f *ntpTaskFactory) SanityCheck(spec.TaskOption) error {
+	return nil
+}
+
+func (f *ntpTaskFactory) Compile(
+	x spec.TaskOption,
+	c *spec.Check,
+) (task.TaskPlanner, error) {
+	opt := &ntpTaskDefine{
+		Timeout: 5,
+	}
+	if err := mapstructure.Decode(x, opt); err != nil {
+		return nil, fmt.Errorf("ntp_task, invalid option input: %s", err)
+	}
+	if opt.Timeout <= 0 {
+		return nil, fmt.Errorf("ntp_task, timeout must be positive")
+	}
+
+	out := &ntpTaskTemplate{
+		name:    opt.Name,
+		timeout: time.Duration(opt.Timeout) * time.Second,
+		port:    opt.Port,
+	}
+
+	if ck, err := check.CompileCheck(c); err != nil {
+		return nil, fmt.Errorf("ntp_task, check compilation fail: %s", err)
+	} else {
+		out.check = ck
+	}
+	return out, nil
+}
+
+func (f *ntpTaskTemplate) Description() string {
+	return fmt.Sprintf("ntp_task(%s)", f.name)
+}
+
+func (f *ntpTaskTemplate) GenTask(env *dvar.EvalEnv) (task.TaskList, error) {
+	return task.TaskList{
+		&ntpTask{
+			t: f,
+		},
+	}, nil
+}
+
+func (t *ntpTask) name() string {
+	return t.t.name
+}
+
+func (t *ntpTask) Prepare(_ context.Context, env *dvar.EvalEnv) error {
+	jobName := fmt.Sprintf("ntp_task(%s)", t.name())
+
+	if addr, err := targetAddress(jobName, env); err != nil {
+		return err
+	} else {
+		t.address = addr
+	}
+
+	if t.t.port != 0 {
+		t.port = t.t.port
+	} else if port, err := targetPort(jobName, env, ntpDefPort); err != nil {
+		return err
+	} else {
+		t.port = port
+	}
+	return nil
+}
+
+func (t *ntpTask) Description() string {
+	return fmt.Sprintf("ntp_task[%s]", t.name())
+}
+
+// 64 bits NTP timestamp, 32 bits seconds and 32 bits fraction
+func ntpTime(x time.Time) uint64 {
+	sec := uint64(x.Unix() + ntpEpochOffset)
+	frac := (uint64(x.Nanosecond()) << 32) / uint64(time.Second)
+	return sec<<32 | frac
+}
+
+func ntpToTime(x uint64) time.Time {
+	sec := int64(x >> 32)
+	// era 1 starts at 2036-02-07, seconds of a timestamp in era 1 wrap around
+	if sec < 0x80000000 {
+		sec += 1 << 32
+	}
+	nsec := int64(((x & 0xffffffff) * uint64(time.Second)) >> 32)
+	return time.Unix(sec-ntpEpochOffset, nsec)
+}
+
+// 32 bits NTP short format, 16 bits seconds and 16 bits fraction
+func ntpShortMs(x uint32) float64 {
+	return float64(x) / 65536 * 1000
+}
+
+// reference id is 4 ascii characters for stratum 0(kiss code) and 1(clock
+// source), otherwise the IPv4 address of the upstream server
+func ntpReferenceID(stratum int, x []byte) string {
+	if stratum <= 1 {
+		return strings.TrimRight(string(x), "\x00")
+	}
+	return net.IP(x).String()
+}
+
+func durationMs(x time.Duration) float64 {
+	return float64(x) / float64(time.Millisecond)
+}
+
+func (t *ntpTask) runNtpTask(ctx context.Context) *ntpTaskResult {
+	addrAndPort := net.JoinHostPort(t.address, fmt.Sprintf("%d", t.port))
+	stat := &ntpTaskResult{
+		Port:    t.port,
+		Address: t.address,
+		RT:      -1,
+	}
+	start := time.Now()
+	stat.Timestamp = start.UnixMilli()
+
+	d := net.Dialer{Timeout: t.t.timeout}
+	conn, err := d.DialContext(ctx, "udp", addrAndPort)
+	if err != nil {
+		stat.Error = fmt.Sprintf("%s", err)
+		return stat
+	}
+	defer closeOnDone(ctx, conn)()
+	stat.LocalAddress = conn.LocalAddr().String()
+	conn.SetDeadline(start.Add(t.t.timeout))
+
+	req := make([]byte, ntpPacketSize)
+	req[0] = ntpVersion<<3 | ntpModeClient
+
+	t1 := time.Now()
+	origin := ntpTime(t1)
+	binary.BigEndian.PutUint64(req[40:], origin)
+
+	if _, err := conn.Write(req); err != nil {
+		stat.Error = fmt.Sprintf("%s", err)
+		return stat
+	}
+
+	resp := make([]byte, 1024)
+	var n int
+	for {
+		n, err = conn.Read(resp)
+		if err != nil {
+			stat.Error = fmt.Sprintf("%s", err)
+			return stat
+		}
+		// ignore the stale reply of other request
+		if n >= ntpPacketSize && binary.BigEndian.Uint64(resp[24:]) == origin {
+			break
+		}
+	}
+	t4 := time.Now()
+	stat.RT = t4.Sub(start).Milliseconds()
+
+	stat.Leap = int(resp[0] >> 6)
+	stat.Version = int(resp[0]>>3) & 0x7
+	stat.Stratum = int(resp[1])
+	stat.Precision = int(int8(resp[3]))
+	stat.RootDelayMs = ntpShortMs(binary.BigEndian.Uint32(resp[4:]))
+	stat.RootDispersionMs = ntpShortMs(binary.BigEndian.Uint32(resp[8:]))
+	stat.ReferenceID = ntpReferenceID(stat.Stratum, resp[12:16])
+	if ref := binary.BigEndian.Uint64(resp[16:]); ref != 0 {
+		stat.ReferenceTime = ntpToTime(ref).UnixMilli()
+	}
+
+	if mode := resp[0] & 0x7; mode != ntpModeServer {
+		stat.Error = fmt.Sprintf("unexpected mode %d in reply", mode)
+		return stat
+	}
+	if stat.Stratum == 0 {
+		stat.Kiss = stat.ReferenceID
+		stat.Error = fmt.Sprintf("kiss of death received: %s", stat.Kiss)
+		return stat
+	}
+	transmit := binary.BigEndian.Uint64(resp[40:])
+	if transmit == 0 {
+		stat.Error = "invalid reply, transmit timestamp is zero"
+		return stat
+	}
+
+	t2 := ntpToTime(binary.BigEndian.Uint64(resp[32:]))
+	t3 := ntpToTime(transmit)
+
+	stat.OffsetMs = durationMs((t2.Sub(t1) + t3.Sub(t4)) / 2)
+	stat.DelayMs = durationMs(t4.Sub(t1) - t3.Sub(t2))
+	stat.OK = true
+	return stat
+}
+
+func (t *ntpTask) Run(ctx context.Context, env *dvar.EvalEnv) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// run the ntp query
+	stat := util.ToMapInterface(t.runNtpTask(ctx))
+
+	// record the result
+	env.RecordHistoricalResult(
+		"ntp",
+		stat,
+	)
+
+	// run the check
+	return t.t.check.Run(env)
+}
+
+func init() {
+	task.RegisterTaskFactory("ntp", &ntpTaskFactory{})
+}
@@ -0,0 +1,116 @@
+package builtin
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func testNtpTime(x time.Time) uint64 {
+	sec := uint64(x.Unix() + 2208988800)
+	frac := (uint64(x.Nanosecond()) << 32) / uint64(time.Second)
+	return sec<<32 | frac
+}
+
+// the stand-in replies with a clock 1 second ahead, then a kiss of death
+func serveTestNtp(conn net.PacketConn) {
+	buf := make([]byte, 1024)
+	for cnt := 0; ; cnt++ {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if n < 48 {
+			continue
+		}
+		recv := time.Now().Add(time.Second)
+
+		resp := make([]byte, 48)
+		resp[0] = 4<<3 | 4
+		copy(resp[24:32], buf[40:48])
+
+		if cnt == 0 {
+			resp[1] = 2
+			resp[3] = 0xec
+			binary.BigEndian.PutUint32(resp[4:], 0x8000)
+			copy(resp[12:16], []byte{10, 0, 0, 1})
+			binary.BigEndian.PutUint64(resp[16:], testNtpTime(recv.Add(-time.Minute)))
+			binary.BigEndian.PutUint64(resp[32:], testNtpTime(recv))
+			binary.BigEndian.PutUint64(resp[40:], testNtpTime(time.Now().Add(time.Second)))
+		} else {
+			copy(resp[12:16], []byte("RATE"))
+		}
+		conn.WriteTo(resp, addr)
+	}
+}
+
+func TestNtpTask(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %s", err)
+	}
+	defer conn.Close()
+	go serveTestNtp(conn)
+
+	tk, _ := testPrepareTask(t, "ntp", "timeout: 2", map[string]interface{}{
+		"name": "local",
+		"ip":   "127.0.0.1",
+		"port": conn.LocalAddr().(*net.UDPAddr).Port,
+	})
+	ntp := tk.(*ntpTask)
+
+	stat := ntp.runNtpTask(context.Background())
+	if !stat.OK {
+		t.Errorf("good, ok: got false, want true, error: %s", stat.Error)
+	}
+	if stat.OffsetMs <= 900 || stat.OffsetMs >= 1100 {
+		t.Errorf("good, offset_ms: got %v, want about 1000", stat.OffsetMs)
+	}
+	if stat.DelayMs >= 100 {
+		t.Errorf("good, delay_ms: got %v, want less than 100", stat.DelayMs)
+	}
+	if stat.Stratum != 2 {
+		t.Errorf("good, stratum: got %d, want 2", stat.Stratum)
+	}
+	if stat.ReferenceID != "10.0.0.1" {
+		t.Errorf("good, reference_id: got %q, want %q", stat.ReferenceID, "10.0.0.1")
+	}
+	if stat.Leap != 0 {
+		t.Errorf("good, leap: got %d, want 0", stat.Leap)
+	}
+	if stat.RootDelayMs != 500 {
+		t.Errorf("good, root_delay_ms: got %v, want 500", stat.RootDelayMs)
+	}
+
+	stat = ntp.runNtpTask(context.Background())
+	if stat.OK {
+		t.Errorf("kiss, ok: got true, want false")
+	}
+	if stat.Stratum != 0 {
+		t.Errorf("kiss, stratum: got %d, want 0", stat.Stratum)
+	}
+	if stat.Kiss != "RATE" {
+		t.Errorf("kiss, kiss: got %q, want %q", stat.Kiss, "RATE")
+	}
+}
+
+func TestNtpTaskPreparePort(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		option string
+		target map[string]interface{}
+		want   uint16
+	}{
+		{"default", ``, map[string]interface{}{"ip": "127.0.0.1"}, 123},
+		{"port", ``, map[string]interface{}{"ip": "127.0.0.1", "port": 1123}, 1123},
+		{"port_list", ``, map[string]interface{}{"addr": "localhost", "port_list": []interface{}{2123, 3123}}, 2123},
+		{"option", `port: 4123`, map[string]interface{}{"ip": "127.0.0.1", "port": 1123}, 4123},
+	} {
+		tk, _ := testPrepareTask(t, "ntp", c.option, c.target)
+		if got := tk.(*ntpTask).port; got != c.want {
+			t.Errorf("%s, port: got %d, want %d", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package builtin
+
+import (
+	"github.com/dianpeng/hi-doctor/check"
+	"github.com/dianpeng/hi-doctor/dvar"
+	"github.com/dianpeng/hi-doctor/oss"
+	"github.com/dianpeng/hi-doctor/spec"
+	"github.com/dianpeng/hi-doctor/task"
+	"github.com/dianpeng/hi-doctor/util"
+	"github.com/mitchellh/mapstructure"
+
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// OSS read-after-write consistency probe. A random object is put, then it is
+// read and listed repeatedly until it is visible with the same content or the
+// deadline passes. The latency between the put is done and the object becomes
+// visible is recorded, for read and list separately. Reads returning content
+// other than what is written are counted as mismatch.
+
+type ossConsistencyOptionDefine struct {
+	Timeout  int64 `mapstructure:"timeout"`  // deadline of the probe, in seconds
+	Interval int64 `mapstructure:"interval"` // interval between polling, in ms
+	Size     int64 `mapstructure:"size"`     // size of the random object
+	Cleanup  bool  `mapstructure:"cleanup"`  // delete the object at last
+}
+
+type ossConsistencyTemplate struct {
+	client   oss.Oss   // oss client
+	prefix   dvar.DVar // prefix of the random object key
+	timeout  time.Duration
+	interval time.Duration
+	size     int64
+	cleanup  bool
+	check    check.Check
+}
+
+type ossConsistencyDefine struct {
+	RespErr            string `json:"resp_err"`
+	RespOK             bool   `json:"resp_ok"`
+	Path               string `json:"path"`
+	ObjSize            int64  `json:"obj_size"`
+	PutRT              int64  `json:"put_rt"`
+	Visible            bool   `json:"visible"`
+	VisibleLatency     int64  `json:"visible_latency"`
+	ListVisible        bool   `json:"list_visible"`
+	ListVisibleLatency int64  `json:"list_visible_latency"`
+	Mismatch           int64  `json:"mismatch"`
+	ReadAttempt        int64  `json:"read_attempt"`
+	ListAttempt        int64  `json:"list_attempt"`
+	Timestamp          int64  `json:"timestamp"`
+	RespRT             int64  `json:"resp_rt"`
+}
+
+type ossConsistencyTask struct {
+	t      *ossConsistencyTemplate
+	prefix string
+}
+
+type ossConsistencyTaskFactory struct{}
+
+func (f *ossConsistencyTaskFactory) Compile(
+	x spec.TaskOption,
+	c *spec.Check,
+) (task.TaskPlanner, error) {
+	opt := oss.Option(x)
+	def := &ossConsistencyOptionDefine{
+		Timeout:  10,
+		Interval: 100,
+		Size:     1024,
+		Cleanup:  true,
+	}
+	if err := mapstructure.Decode(map[string]interface{}(opt), def); err != nil {
+		return nil, fmt.Errorf("oss_consistency, invalid option input: %s", err)
+	}
+	if def.Timeout <= 0 || def.Interval <= 0 || def.Size < 0 {
+		return nil, fmt.Errorf("oss_consistency, timeout and interval must be positive, size must not be negative")
+	}
+
+	tmpl := &ossConsistencyTemplate{
+		timeout:  time.Duration(def.Timeout) * time.Second,
+		interval: time.Duration(def.Interval) * time.Millisecond,
+		size:     def.Size,
+		cleanup:  def.Cleanup,
+	}
+
+	if cli, err := ossNewClient("oss_consistency", opt); err != nil {
+		return nil, err
+	} else {
+		tmpl.client = cli
+	}
+	if _, ok := opt["prefix"]; !ok {
+		tmpl.prefix = dvar.NewDVarLit("hi-doctor/consistency/")
+	} else if dv, err := ossCompileString("oss_consistency", opt, "prefix"); err != nil {
+		return nil, err
+	} else {
+		tmpl.prefix = dv
+	}
+	if ck, err := check.CompileCheck(c); err != nil {
+		return nil, fmt.Errorf("oss_consistency check compile fail: %s", err)
+	} else {
+		tmpl.check = ck
+	}
+	return tmpl, nil
+}
+
+func (f *ossConsistencyTaskFactory) SanityCheck(x spec.TaskOption) error {
+	return ossSanityCheck("oss_consistency", oss.Option(x))
+}
+
+func (p *ossConsistencyTemplate) Description() string {
+	return "oss_consistency"
+}
+
+func (p *ossConsistencyTemplate) GenTask(env *dvar.EvalEnv) (task.TaskList, error) {
+	return task.TaskList{
+		&ossConsistencyTask{
+			t: p,
+		},
+	}, nil
+}
+
+func (t *ossConsistencyTask) Prepare(_ context.Context, env *dvar.EvalEnv) error {
+	if v, err := t.t.prefix.Value(env); err != nil {
+		return fmt.Errorf("oss_consistency prefix execution failed: %s", err)
+	} else {
+		t.prefix = v.String()
+	}
+	return nil
+}
+
+// read the object, returns whether the object is found and whether the
+// content matches
+func (t *ossConsistencyTask) read(ctx context.Context, path string, digest [32]byte) (bool, bool) {
+	obj, err := t.t.client.Get(ctx, path)
+	if err != nil {
+		return false, false
+	}
+	body := obj.Reader()
+	defer body.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, body); err != nil {
+		return false, false
+	}
+	sum := [32]byte{}
+	copy(sum[:], h.Sum(nil))
+	return true, sum == digest
+}
+
+func (t *ossConsistencyTask) listed(ctx context.Context, path string) bool {
+	keys, err := t.t.client.List(ctx, path, 1)
+	if err != nil {
+		return false
+	}
+	key := strings.TrimLeft(path, "/")
+	for _, k := range keys {
+		if strings.TrimLeft(k, "/") == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *ossConsistencyTask) doRunProbe(ctx context.Context) *ossConsistencyDefine {
+	path := t.prefix + util.GetUUID()
+	data := util.RndStr(int(t.t.size))
+	digest := sha256.Sum256([]byte(data))
+
+	stat := &ossConsistencyDefine{
+		Path:               path,
+		ObjSize:            t.t.size,
+		VisibleLatency:     -1,
+		ListVisibleLatency: -1,
+	}
+
+	start := time.Now()
+	stat.Timestamp = start.UnixMilli()
+	defer func() {
+		stat.RespRT = time.Since(start).Milliseconds()
+	}()
+
+	if err := t.t.client.Put(ctx, path, strings.NewReader(data), t.t.size); err != nil {
+		stat.RespErr = fmt.Sprintf("%s", err)
+		stat.PutRT = -1
+		return stat
+	}
+	putDone := time.Now()
+	stat.PutRT = putDone.Sub(start).Milliseconds()
+
+	// the object is removed even if the task is aborted
+	if t.t.cleanup {
+		defer t.t.client.Del(context.Background(), path)
+	}
+
+	deadline := putDone.Add(t.t.timeout)
+	for {
+		if !stat.Visible {
+			stat.ReadAttempt++
+			found, match := t.read(ctx, path, digest)
+			if found && !match {
+				stat.Mismatch++
+			}
+			if match {
+				stat.Visible = true
+				stat.VisibleLatency = time.Since(putDone).Milliseconds()
+			}
+		}
+		if !stat.ListVisible {
+			stat.ListAttempt++
+			if t.listed(ctx, path) {
+				stat.ListVisible = true
+				stat.ListVisibleLatency = time.Since(putDone).Milliseconds()
+			}
+		}
+		if stat.Visible && stat.ListVisible {
+			stat.RespOK = true
+			return stat
+		}
+
+		if time.Now().Add(t.t.interval).After(deadline) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			stat.RespErr = fmt.Sprintf("%s", ctx.Err())
+			return stat
+		case <-time.After(t.t.interval):
+		}
+	}
+
+	stat.RespErr = fmt.Sprintf(
+		"object is not consistent within %s, visible: %t, list visible: %t, mismatch: %d",
+		t.t.timeout,
+		stat.Visible,
+		stat.ListVisible,
+		stat.Mismatch,
+	)
+	return stat
+}
+
+func (t *ossConsistencyTask) Run(ctx context.Context, env *dvar.EvalEnv) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	def := t.doRunProbe(ctx)
+	env.RecordHistoricalResult("oss_consistency", util.ToMapInterface(def))
+	return t.t.check.Run(env)
+}
+
+func (t *ossConsistencyTask) Description() string {
+	return "oss_consistency"
+}
+
+func init() {
+	task.RegisterTaskFactory("oss_consistency", &ossConsistencyTaskFactory{})
+}
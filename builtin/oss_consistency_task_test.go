@@ -0,0 +1,121 @@
+package builtin
+
+import (
+	"github.com/dianpeng/hi-doctor/oss"
+
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// eventually consistent oss, the first reads return stale content and the
+// first lists miss the object
+type testEventualOss struct {
+	sync.Mutex
+	object    map[string]string
+	staleRead int
+	missList  int
+}
+
+type testEventualObject struct {
+	data string
+}
+
+func (o *testEventualObject) Reader() io.ReadCloser {
+	return io.NopCloser(strings.NewReader(o.data))
+}
+func (o *testEventualObject) Size() int64           { return int64(len(o.data)) }
+func (o *testEventualObject) ModifyTime() time.Time { return time.Time{} }
+func (o *testEventualObject) Headers() http.Header  { return http.Header{} }
+func (s *testEventualOss) Head(ctx context.Context, p string) (oss.Object, error) {
+	return s.Get(ctx, p)
+}
+func (s *testEventualOss) Copy(context.Context, string, string) error { return nil }
+func (s *testEventualOss) Provider() string                           { return "test" }
+
+func (s *testEventualOss) Get(_ context.Context, p string) (oss.Object, error) {
+	s.Lock()
+	defer s.Unlock()
+	v, ok := s.object[p]
+	if !ok {
+		return nil, fmt.Errorf("not found")
+	}
+	if s.staleRead > 0 {
+		s.staleRead--
+		return &testEventualObject{data: "stale"}, nil
+	}
+	return &testEventualObject{data: v}, nil
+}
+
+func (s *testEventualOss) Put(_ context.Context, p string, r io.Reader, _ int64) error {
+	s.Lock()
+	defer s.Unlock()
+	data, _ := io.ReadAll(r)
+	s.object[p] = string(data)
+	return nil
+}
+
+func (s *testEventualOss) Del(_ context.Context, p string) error {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.object, p)
+	return nil
+}
+
+func (s *testEventualOss) List(_ context.Context, prefix string, _ int) ([]string, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.missList > 0 {
+		s.missList--
+		return nil, nil
+	}
+	out := []string{}
+	for k := range s.object {
+		if strings.HasPrefix(k, prefix) {
+			out = append(out, k)
+		}
+	}
+	return out, nil
+}
+
+func TestOssConsistency(t *testing.T) {
+	cli := &testEventualOss{
+		object:    map[string]string{},
+		staleRead: 2,
+		missList:  4,
+	}
+	tsk := &ossConsistencyTask{
+		t: &ossConsistencyTemplate{
+			client:   cli,
+			timeout:  time.Second,
+			interval: 10 * time.Millisecond,
+			size:     16,
+			cleanup:  true,
+		},
+		prefix: "probe/",
+	}
+
+	s := tsk.doRunProbe(context.Background())
+	if !s.RespOK || s.Mismatch != 2 || s.ReadAttempt != 3 || s.ListAttempt != 5 {
+		t.Fatalf("unexpected result: %+v", s)
+	}
+	if s.VisibleLatency < 0 || s.ListVisibleLatency < s.VisibleLatency {
+		t.Fatalf("unexpected latency: %+v", s)
+	}
+	if len(cli.object) != 0 {
+		t.Fatalf("object should be cleaned up")
+	}
+
+	// never visible in list
+	cli.missList = 1 << 30
+	tsk.t.timeout = 100 * time.Millisecond
+	s = tsk.doRunProbe(context.Background())
+	if s.RespOK || !s.Visible || s.ListVisible || s.ListVisibleLatency != -1 || s.RespErr == "" {
+		t.Fatalf("unexpected result: %+v", s)
+	}
+}
@@ -0,0 +1,146 @@
+package builtin
+
+import (
+	"github.com/dianpeng/hi-doctor/check"
+	"github.com/dianpeng/hi-doctor/dvar"
+	"github.com/dianpeng/hi-doctor/oss"
+	"github.com/dianpeng/hi-doctor/spec"
+	"github.com/dianpeng/hi-doctor/task"
+	"github.com/dianpeng/hi-doctor/util"
+
+	"context"
+	"fmt"
+	"time"
+)
+
+// OSS copy task, copies the object at path to dest within the bucket
+
+type ossCopyTemplate struct {
+	client oss.Oss   // oss client
+	path   dvar.DVar // path of the source object
+	dest   dvar.DVar // path of the destination object
+	check  check.Check
+}
+
+type ossCopyDefine struct {
+	RespErr   string `json:"resp_err"`
+	RespOK    bool   `json:"resp_ok"`
+	Dest      string `json:"dest"`
+	Timestamp int64  `json:"timestamp"`
+	RespRT    int64  `json:"resp_rt"`
+}
+
+type ossCopyTask struct {
+	t    *ossCopyTemplate
+	path string
+	dest string
+}
+
+type ossCopyTaskFactory struct{}
+
+func (f *ossCopyTaskFactory) Compile(
+	x spec.TaskOption,
+	c *spec.Check,
+) (task.TaskPlanner, error) {
+	opt := oss.Option(x)
+	tmpl := &ossCopyTemplate{}
+
+	if cli, err := ossNewClient("oss_copy", opt); err != nil {
+		return nil, err
+	} else {
+		tmpl.client = cli
+	}
+	if dv, err := ossCompileString("oss_copy", opt, "path"); err != nil {
+		return nil, err
+	} else {
+		tmpl.path = dv
+	}
+	if dv, err := ossCompileString("oss_copy", opt, "dest"); err != nil {
+		return nil, err
+	} else {
+		tmpl.dest = dv
+	}
+	if ck, err := check.CompileCheck(c); err != nil {
+		return nil, fmt.Errorf("oss_copy check compile fail: %s", err)
+	} else {
+		tmpl.check = ck
+	}
+	return tmpl, nil
+}
+
+func (f *ossCopyTaskFactory) SanityCheck(x spec.TaskOption) error {
+	opt := oss.Option(x)
+	if err := ossSanityCheck("oss_copy", opt); err != nil {
+		return err
+	}
+	if _, ok := opt["dest"]; !ok {
+		return fmt.Errorf("oss_copy option does not have dest field")
+	}
+	return nil
+}
+
+func (p *ossCopyTemplate) Description() string {
+	return "oss_copy"
+}
+
+func (p *ossCopyTemplate) GenTask(env *dvar.EvalEnv) (task.TaskList, error) {
+	return task.TaskList{
+		&ossCopyTask{
+			t: p,
+		},
+	}, nil
+}
+
+func (t *ossCopyTask) Prepare(_ context.Context, env *dvar.EvalEnv) error {
+	if v, err := ossGetPath("oss_copy", &t.t.path, env); err != nil {
+		return err
+	} else {
+		t.path = v
+	}
+
+	if v, err := t.t.dest.Value(env); err != nil {
+		return fmt.Errorf("oss_copy dest execution failed: %s", err)
+	} else if v.String() == "" {
+		return fmt.Errorf("oss_copy dest is empty")
+	} else {
+		t.dest = v.String()
+	}
+	return nil
+}
+
+func (t *ossCopyTask) doRunCopy(ctx context.Context) *ossCopyDefine {
+	stat := &ossCopyDefine{
+		Dest: t.dest,
+	}
+
+	startTs := time.Now().UnixMilli()
+	err := t.t.client.Copy(ctx, t.path, t.dest)
+	endTs := time.Now().UnixMilli()
+
+	stat.Timestamp = startTs
+	if err != nil {
+		stat.RespErr = fmt.Sprintf("%s", err)
+		stat.RespOK = false
+		stat.RespRT = -1
+	} else {
+		stat.RespOK = true
+		stat.RespRT = endTs - startTs
+	}
+	return stat
+}
+
+func (t *ossCopyTask) Run(ctx context.Context, env *dvar.EvalEnv) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	env.RecordHistoricalResult("oss_copy", util.ToMapInterface(t.doRunCopy(ctx)))
+	return t.t.check.Run(env)
+}
+
+func (t *ossCopyTask) Description() string {
+	return "oss_copy"
+}
+
+func init() {
+	task.RegisterTaskFactory("oss_copy", &ossCopyTaskFactory{})
+}
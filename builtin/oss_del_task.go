@@ -0,0 +1,121 @@
+package builtin
+
+import (
+	"github.com/dianpeng/hi-doctor/check"
+	"github.com/dianpeng/hi-doctor/dvar"
+	"github.com/dianpeng/hi-doctor/oss"
+	"github.com/dianpeng/hi-doctor/spec"
+	"github.com/dianpeng/hi-doctor/task"
+	"github.com/dianpeng/hi-doctor/util"
+
+	"context"
+	"fmt"
+	"time"
+)
+
+// OSS delete task, deleting a non-existed object is treated as success
+
+type ossDelTemplate struct {
+	client oss.Oss   // oss client
+	path   dvar.DVar // path of the object
+	check  check.Check
+}
+
+type ossDelDefine struct {
+	RespErr   string `json:"resp_err"`
+	RespOK    bool   `json:"resp_ok"`
+	Timestamp int64  `json:"timestamp"`
+	RespRT    int64  `json:"resp_rt"`
+}
+
+type ossDelTask struct {
+	t    *ossDelTemplate
+	path string
+}
+
+type ossDelTaskFactory struct{}
+
+func (f *ossDelTaskFactory) Compile(
+	x spec.TaskOption,
+	c *spec.Check,
+) (task.TaskPlanner, error) {
+	opt := oss.Option(x)
+	tmpl := &ossDelTemplate{}
+
+	if cli, err := ossNewClient("oss_del", opt); err != nil {
+		return nil, err
+	} else {
+		tmpl.client = cli
+	}
+	if dv, err := ossCompileString("oss_del", opt, "path"); err != nil {
+		return nil, err
+	} else {
+		tmpl.path = dv
+	}
+	if ck, err := check.CompileCheck(c); err != nil {
+		return nil, fmt.Errorf("oss_del check compile fail: %s", err)
+	} else {
+		tmpl.check = ck
+	}
+	return tmpl, nil
+}
+
+func (f *ossDelTaskFactory) SanityCheck(x spec.TaskOption) error {
+	return ossSanityCheck("oss_del", oss.Option(x))
+}
+
+func (p *ossDelTemplate) Description() string {
+	return "oss_del"
+}
+
+func (p *ossDelTemplate) GenTask(env *dvar.EvalEnv) (task.TaskList, error) {
+	return task.TaskList{
+		&ossDelTask{
+			t: p,
+		},
+	}, nil
+}
+
+func (t *ossDelTask) Prepare(_ context.Context, env *dvar.EvalEnv) error {
+	if v, err := ossGetPath("oss_del", &t.t.path, env); err != nil {
+		return err
+	} else {
+		t.path = v
+	}
+	return nil
+}
+
+func (t *ossDelTask) doRunDel(ctx context.Context) *ossDelDefine {
+	stat := &ossDelDefine{}
+
+	startTs := time.Now().UnixMilli()
+	err := t.t.client.Del(ctx, t.path)
+	endTs := time.Now().UnixMilli()
+
+	stat.Timestamp = startTs
+	if err != nil {
+		stat.RespErr = fmt.Sprintf("%s", err)
+		stat.RespOK = false
+		stat.RespRT = -1
+	} else {
+		stat.RespOK = true
+		stat.RespRT = endTs - startTs
+	}
+	return stat
+}
+
+func (t *ossDelTask) Run(ctx context.Context, env *dvar.EvalEnv) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	env.RecordHistoricalResult("oss_del", util.ToMapInterface(t.doRunDel(ctx)))
+	return t.t.check.Run(env)
+}
+
+func (t *ossDelTask) Description() string {
+	return "oss_del"
+}
+
+func init() {
+	task.RegisterTaskFactory("oss_del", &ossDelTaskFactory{})
+}
@@ -7,9 +7,10 @@ import (
 	"github.com/dianpeng/hi-doctor/spec"
 	"github.com/dianpeng/hi-doctor/task"
 	"github.com/dianpeng/hi-doctor/util"
+	"github.com/mitchellh/mapstructure"
 
+	"context"
 	"fmt"
-	"io"
 	"time"
 )
 
@@ -20,6 +21,15 @@ type ossGetTemplate struct {
 	client oss.Oss    // oss client
 	path   dvar.DVar  // path of the object
 	check  check.Check
+
+	// integrity of the object
+	integrity      *integrityTemplate
+	maxBodyCapture int64
+}
+
+type ossGetOptionDefine struct {
+	Integrity      *integrityOptionDefine `mapstructure:"integrity"`
+	MaxBodyCapture int64                  `mapstructure:"max_body_capture"`
 }
 
 type ossGetDefine struct {
@@ -31,11 +41,14 @@ type ossGetDefine struct {
 	Timestamp    int64  `json:"timestamp"`
 	RespTTFB     int64  `json:"resp_ttfb"`
 	RespRT       int64  `json:"resp_rt"`
+
+	RespIntegrity integrityResult `json:"resp_integrity"`
 }
 
 type ossGetTask struct {
-	t    *ossGetTemplate
-	path string
+	t         *ossGetTemplate
+	path      string
+	integrity *integrityExpect
 }
 
 type ossGetTaskFactory struct{}
@@ -84,6 +97,19 @@ func newOssGetTemplate(
 			tmpl.path = dvar.NewDVarLit("")
 		}
 
+		def := &ossGetOptionDefine{
+			MaxBodyCapture: -1,
+		}
+		if err := mapstructure.Decode(map[string]interface{}(x), def); err != nil {
+			return nil, fmt.Errorf("oss_get invalid option input: %s", err)
+		}
+		if it, err := compileIntegrity("oss_get", def.Integrity); err != nil {
+			return nil, err
+		} else {
+			tmpl.integrity = it
+			tmpl.maxBodyCapture = def.MaxBodyCapture
+		}
+
 		if ck, err := check.CompileCheck(c); err != nil {
 			return nil, fmt.Errorf("oss_get check compile fail: %s", err)
 		} else {
@@ -122,20 +148,26 @@ func (p *ossGetTemplate) GenTask(env *dvar.EvalEnv) (task.TaskList, error) {
 }
 
 // OSSGetTask
-func (t *ossGetTask) Prepare(env *dvar.EvalEnv) error {
+func (t *ossGetTask) Prepare(_ context.Context, env *dvar.EvalEnv) error {
 	if v, err := ossGetPath("oss_get", &t.t.path, env); err != nil {
 		return err
 	} else {
 		t.path = v
 	}
+
+	if it, err := t.t.integrity.Value("oss_get", env); err != nil {
+		return err
+	} else {
+		t.integrity = it
+	}
 	return nil
 }
 
-func (t *ossGetTask) doRunGet(env *dvar.EvalEnv) *ossGetDefine {
+func (t *ossGetTask) doRunGet(ctx context.Context, env *dvar.EvalEnv) *ossGetDefine {
 	stat := &ossGetDefine{}
 
 	startTs := time.Now().UnixMilli()
-	obj, err := t.t.client.Get(t.path)
+	obj, err := t.t.client.Get(ctx, t.path)
 	endTs := time.Now().UnixMilli()
 
 	if err != nil {
@@ -155,7 +187,12 @@ func (t *ossGetTask) doRunGet(env *dvar.EvalEnv) *ossGetDefine {
 		body := obj.Reader()
 		defer body.Close()
 
-		if data, err := io.ReadAll(body); err != nil {
+		if data, err := readBodyWithIntegrity(
+			body,
+			obj.Headers(),
+			t.integrity,
+			t.t.maxBodyCapture,
+		); err != nil {
 			stat.RespErr = fmt.Sprintf("%s", err)
 			stat.RespOK = false
 			stat.RespObj = ""
@@ -169,9 +206,10 @@ func (t *ossGetTask) doRunGet(env *dvar.EvalEnv) *ossGetDefine {
 
 			stat.RespErr = ""
 			stat.RespOK = true
-			stat.RespObj = string(data)
-			stat.RespObjSize = int64(len(stat.RespObj))
-			stat.RespTruncate = false
+			stat.RespObj = data.data
+			stat.RespObjSize = data.size
+			stat.RespTruncate = data.truncate
+			stat.RespIntegrity = data.result
 			stat.RespTTFB = endTs - startTs
 			stat.RespRT = endBodyTs - startTs
 			stat.Timestamp = startTs
@@ -181,15 +219,18 @@ func (t *ossGetTask) doRunGet(env *dvar.EvalEnv) *ossGetDefine {
 	return stat
 }
 
-func (t *ossGetTask) runGet(env *dvar.EvalEnv) error {
-	def := t.doRunGet(env)
+func (t *ossGetTask) runGet(ctx context.Context, env *dvar.EvalEnv) error {
+	def := t.doRunGet(ctx, env)
 	stat := util.ToMapInterface(def)
 	env.RecordHistoricalResult("oss_get", stat)
 	return nil
 }
 
-func (t *ossGetTask) Run(env *dvar.EvalEnv) error {
-	if err := t.runGet(env); err != nil {
+func (t *ossGetTask) Run(ctx context.Context, env *dvar.EvalEnv) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := t.runGet(ctx, env); err != nil {
 		return err
 	}
 	if err := t.t.check.Run(env); err != nil {
@@ -0,0 +1,136 @@
+package builtin
+
+import (
+	"github.com/dianpeng/hi-doctor/check"
+	"github.com/dianpeng/hi-doctor/dvar"
+	"github.com/dianpeng/hi-doctor/oss"
+	"github.com/dianpeng/hi-doctor/spec"
+	"github.com/dianpeng/hi-doctor/task"
+	"github.com/dianpeng/hi-doctor/util"
+
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// OSS head task, only the metadata of the object is fetched
+
+type ossHeadTemplate struct {
+	client oss.Oss   // oss client
+	path   dvar.DVar // path of the object
+	check  check.Check
+}
+
+type ossHeadDefine struct {
+	RespErr         string      `json:"resp_err"`
+	RespOK          bool        `json:"resp_ok"`
+	RespObjSize     int64       `json:"resp_obj_size"`
+	RespETag        string      `json:"resp_etag"`
+	RespContentType string      `json:"resp_content_type"`
+	RespMTime       int64       `json:"resp_mtime"`
+	RespHeader      http.Header `json:"resp_header"`
+	Timestamp       int64       `json:"timestamp"`
+	RespRT          int64       `json:"resp_rt"`
+}
+
+type ossHeadTask struct {
+	t    *ossHeadTemplate
+	path string
+}
+
+type ossHeadTaskFactory struct{}
+
+func (f *ossHeadTaskFactory) Compile(
+	x spec.TaskOption,
+	c *spec.Check,
+) (task.TaskPlanner, error) {
+	opt := oss.Option(x)
+	tmpl := &ossHeadTemplate{}
+
+	if cli, err := ossNewClient("oss_head", opt); err != nil {
+		return nil, err
+	} else {
+		tmpl.client = cli
+	}
+	if dv, err := ossCompileString("oss_head", opt, "path"); err != nil {
+		return nil, err
+	} else {
+		tmpl.path = dv
+	}
+	if ck, err := check.CompileCheck(c); err != nil {
+		return nil, fmt.Errorf("oss_head check compile fail: %s", err)
+	} else {
+		tmpl.check = ck
+	}
+	return tmpl, nil
+}
+
+func (f *ossHeadTaskFactory) SanityCheck(x spec.TaskOption) error {
+	return ossSanityCheck("oss_head", oss.Option(x))
+}
+
+func (p *ossHeadTemplate) Description() string {
+	return "oss_head"
+}
+
+func (p *ossHeadTemplate) GenTask(env *dvar.EvalEnv) (task.TaskList, error) {
+	return task.TaskList{
+		&ossHeadTask{
+			t: p,
+		},
+	}, nil
+}
+
+func (t *ossHeadTask) Prepare(_ context.Context, env *dvar.EvalEnv) error {
+	if v, err := ossGetPath("oss_head", &t.t.path, env); err != nil {
+		return err
+	} else {
+		t.path = v
+	}
+	return nil
+}
+
+func (t *ossHeadTask) doRunHead(ctx context.Context) *ossHeadDefine {
+	stat := &ossHeadDefine{}
+
+	startTs := time.Now().UnixMilli()
+	obj, err := t.t.client.Head(ctx, t.path)
+	endTs := time.Now().UnixMilli()
+
+	stat.Timestamp = startTs
+	if err != nil {
+		stat.RespErr = fmt.Sprintf("%s", err)
+		stat.RespOK = false
+		stat.RespObjSize = int64(-1)
+		stat.RespRT = -1
+	} else {
+		obj.Reader().Close()
+		header := obj.Headers()
+
+		stat.RespOK = true
+		stat.RespObjSize = obj.Size()
+		stat.RespETag = header.Get("ETag")
+		stat.RespContentType = header.Get("Content-Type")
+		stat.RespMTime = ossTime(obj.ModifyTime())
+		stat.RespHeader = header
+		stat.RespRT = endTs - startTs
+	}
+	return stat
+}
+
+func (t *ossHeadTask) Run(ctx context.Context, env *dvar.EvalEnv) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	env.RecordHistoricalResult("oss_head", util.ToMapInterface(t.doRunHead(ctx)))
+	return t.t.check.Run(env)
+}
+
+func (t *ossHeadTask) Description() string {
+	return "oss_head"
+}
+
+func init() {
+	task.RegisterTaskFactory("oss_head", &ossHeadTaskFactory{})
+}
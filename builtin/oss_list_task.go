@@ -0,0 +1,140 @@
+package builtin
+
+import (
+	"github.com/dianpeng/hi-doctor/check"
+	"github.com/dianpeng/hi-doctor/dvar"
+	"github.com/dianpeng/hi-doctor/oss"
+	"github.com/dianpeng/hi-doctor/spec"
+	"github.com/dianpeng/hi-doctor/task"
+	"github.com/dianpeng/hi-doctor/util"
+
+	"context"
+	"fmt"
+	"time"
+)
+
+// OSS list task, lists the object keys with the prefix
+
+const ossListDefaultMax = 1000
+
+type ossListTemplate struct {
+	client oss.Oss   // oss client
+	prefix dvar.DVar // prefix of the object keys, empty means all
+	max    int       // max # of keys to list
+	check  check.Check
+}
+
+type ossListDefine struct {
+	RespErr   string   `json:"resp_err"`
+	RespOK    bool     `json:"resp_ok"`
+	RespCount int      `json:"resp_count"`
+	RespKeys  []string `json:"resp_keys"`
+	Timestamp int64    `json:"timestamp"`
+	RespRT    int64    `json:"resp_rt"`
+}
+
+type ossListTask struct {
+	t      *ossListTemplate
+	prefix string
+}
+
+type ossListTaskFactory struct{}
+
+func (f *ossListTaskFactory) Compile(
+	x spec.TaskOption,
+	c *spec.Check,
+) (task.TaskPlanner, error) {
+	opt := oss.Option(x)
+	tmpl := &ossListTemplate{
+		max: ossListDefaultMax,
+	}
+
+	if cli, err := ossNewClient("oss_list", opt); err != nil {
+		return nil, err
+	} else {
+		tmpl.client = cli
+	}
+	if dv, err := ossCompileString("oss_list", opt, "prefix"); err != nil {
+		return nil, err
+	} else {
+		tmpl.prefix = dv
+	}
+	if v, ok := opt["max"]; ok {
+		if max, ok := util.ToInt(v, true); !ok || max <= 0 {
+			return nil, fmt.Errorf("oss_list max must be positive integer")
+		} else {
+			tmpl.max = int(max)
+		}
+	}
+	if ck, err := check.CompileCheck(c); err != nil {
+		return nil, fmt.Errorf("oss_list check compile fail: %s", err)
+	} else {
+		tmpl.check = ck
+	}
+	return tmpl, nil
+}
+
+func (f *ossListTaskFactory) SanityCheck(x spec.TaskOption) error {
+	return ossSanityCheck("oss_list", oss.Option(x))
+}
+
+func (p *ossListTemplate) Description() string {
+	return "oss_list"
+}
+
+func (p *ossListTemplate) GenTask(env *dvar.EvalEnv) (task.TaskList, error) {
+	return task.TaskList{
+		&ossListTask{
+			t: p,
+		},
+	}, nil
+}
+
+func (t *ossListTask) Prepare(_ context.Context, env *dvar.EvalEnv) error {
+	if v, err := t.t.prefix.Value(env); err != nil {
+		return fmt.Errorf("oss_list prefix execution failed: %s", err)
+	} else {
+		t.prefix = v.String()
+	}
+	return nil
+}
+
+func (t *ossListTask) doRunList(ctx context.Context) *ossListDefine {
+	stat := &ossListDefine{
+		RespKeys: []string{},
+	}
+
+	startTs := time.Now().UnixMilli()
+	keys, err := t.t.client.List(ctx, t.prefix, t.t.max)
+	endTs := time.Now().UnixMilli()
+
+	stat.Timestamp = startTs
+	if err != nil {
+		stat.RespErr = fmt.Sprintf("%s", err)
+		stat.RespOK = false
+		stat.RespCount = -1
+		stat.RespRT = -1
+	} else {
+		stat.RespOK = true
+		stat.RespCount = len(keys)
+		stat.RespKeys = keys
+		stat.RespRT = endTs - startTs
+	}
+	return stat
+}
+
+func (t *ossListTask) Run(ctx context.Context, env *dvar.EvalEnv) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	env.RecordHistoricalResult("oss_list", util.ToMapInterface(t.doRunList(ctx)))
+	return t.t.check.Run(env)
+}
+
+func (t *ossListTask) Description() string {
+	return "oss_list"
+}
+
+func init() {
+	task.RegisterTaskFactory("oss_list", &ossListTaskFactory{})
+}
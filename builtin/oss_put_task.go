@@ -8,6 +8,7 @@ import (
 	"github.com/dianpeng/hi-doctor/task"
 	"github.com/dianpeng/hi-doctor/util"
 
+	"context"
 	"fmt"
 	"strings"
 	"time"
@@ -131,7 +132,7 @@ func (p *ossPutTemplate) GenTask(env *dvar.EvalEnv) (task.TaskList, error) {
 }
 
 // OSSGetTask
-func (t *ossPutTask) Prepare(env *dvar.EvalEnv) error {
+func (t *ossPutTask) Prepare(_ context.Context, env *dvar.EvalEnv) error {
 	if v, err := ossGetPath("oss_put", &t.t.path, env); err != nil {
 		return err
 	} else {
@@ -147,11 +148,11 @@ func (t *ossPutTask) Prepare(env *dvar.EvalEnv) error {
 	return nil
 }
 
-func (t *ossPutTask) doRunGet(env *dvar.EvalEnv) *ossPutDefine {
+func (t *ossPutTask) doRunGet(ctx context.Context, env *dvar.EvalEnv) *ossPutDefine {
 	stat := &ossPutDefine{}
 
 	startTs := time.Now().UnixMilli()
-	err := t.t.client.Put(t.path, strings.NewReader(t.object), int64(len(t.object)))
+	err := t.t.client.Put(ctx, t.path, strings.NewReader(t.object), int64(len(t.object)))
 	endTs := time.Now().UnixMilli()
 
 	if err != nil {
@@ -169,15 +170,18 @@ func (t *ossPutTask) doRunGet(env *dvar.EvalEnv) *ossPutDefine {
 	return stat
 }
 
-func (t *ossPutTask) runGet(env *dvar.EvalEnv) error {
-	def := t.doRunGet(env)
+func (t *ossPutTask) runGet(ctx context.Context, env *dvar.EvalEnv) error {
+	def := t.doRunGet(ctx, env)
 	stat := util.ToMapInterface(def)
 	env.RecordHistoricalResult("oss_put", stat)
 	return nil
 }
 
-func (t *ossPutTask) Run(env *dvar.EvalEnv) error {
-	if err := t.runGet(env); err != nil {
+func (t *ossPutTask) Run(ctx context.Context, env *dvar.EvalEnv) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := t.runGet(ctx, env); err != nil {
 		return err
 	}
 	if err := t.t.check.Run(env); err != nil {
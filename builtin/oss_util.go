@@ -2,12 +2,71 @@ package builtin
 
 import (
 	"github.com/dianpeng/hi-doctor/dvar"
+	"github.com/dianpeng/hi-doctor/oss"
 
 	"fmt"
+	"time"
 )
 
 // helper functions for working with oss related functions
 
+// create the oss client based on the provider field of the option
+func ossNewClient(jobName string, opt oss.Option) (oss.Oss, error) {
+	name, ok := opt.GetProvider()
+	if !ok {
+		return nil, fmt.Errorf("%s provider field is not in option", jobName)
+	}
+	factory := oss.GetOSSFactory(name)
+	if factory == nil {
+		return nil, fmt.Errorf("%s provider(%s) is unknown to us", jobName, name)
+	}
+	cli, err := factory.Create(opt)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"%s provider %s client creation failed %s",
+			jobName,
+			name,
+			err,
+		)
+	}
+	return cli, nil
+}
+
+// compile the string field of the option, missing field is an empty literal
+func ossCompileString(
+	jobName string,
+	opt oss.Option,
+	field string,
+) (dvar.DVar, error) {
+	v, ok := opt[field]
+	if !ok {
+		return dvar.NewDVarLit(""), nil
+	}
+	str, ok := v.(string)
+	if !ok {
+		return dvar.DVar{}, fmt.Errorf("%s %s must be string", jobName, field)
+	}
+	dv, err := dvar.NewDVarStringContext(str)
+	if err != nil {
+		return dvar.DVar{}, fmt.Errorf("%s %s compiles fail: %s", jobName, field, err)
+	}
+	return dv, nil
+}
+
+func ossSanityCheck(jobName string, opt oss.Option) error {
+	if !opt.HasProvider() {
+		return fmt.Errorf("%s option does not have provider field", jobName)
+	}
+	return nil
+}
+
+func ossTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
 func ossGetPath(
 	jobName string,
 	localPath *dvar.DVar,
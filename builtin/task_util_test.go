@@ -0,0 +1,94 @@
+package builtin
+
+import (
+	"github.com/dianpeng/hi-doctor/dvar"
+	"github.com/dianpeng/hi-doctor/spec"
+	"github.com/dianpeng/hi-doctor/task"
+	"gopkg.in/yaml.v3"
+
+	"context"
+	"net"
+	"strconv"
+	"testing"
+)
+
+// compile the task of the type from its option in yaml, then generate and
+// prepare it against the target. The target is the same as an inline target of
+// the job, ie port_list is a []interface{}
+func testPrepareTask(
+	t *testing.T,
+	ty string,
+	option string,
+	target map[string]interface{},
+) (task.Task, *dvar.EvalEnv) {
+	t.Helper()
+
+	opt := spec.TaskOption{}
+	if err := yaml.Unmarshal([]byte(option), &opt); err != nil {
+		t.Fatalf("%s option is invalid: %s", ty, err)
+	}
+
+	f := task.GetTaskFactory(ty)
+	if f == nil {
+		t.Fatalf("%s task is not registered", ty)
+	}
+	if err := f.SanityCheck(opt); err != nil {
+		t.Fatalf("%s sanity check failed: %s", ty, err)
+	}
+	planner, err := f.Compile(opt, nil)
+	if err != nil {
+		t.Fatalf("%s compile failed: %s", ty, err)
+	}
+
+	env := dvar.NewEvalEnv()
+	for k, v := range target {
+		env.Set("target", k, dvar.NewInterfaceVal(v))
+	}
+
+	tasks, err := planner.GenTask(env)
+	if err != nil {
+		t.Fatalf("%s generate task failed: %s", ty, err)
+	}
+	if len(tasks) != 1 {
+		t.Fatalf("%s # of tasks: got %d, want 1", ty, len(tasks))
+	}
+	if err := tasks[0].Prepare(context.Background(), env); err != nil {
+		t.Fatalf("%s prepare failed: %s", ty, err)
+	}
+	return tasks[0], env
+}
+
+// run the http task of the option against the target
+func testRunHttpTask(
+	t *testing.T,
+	option string,
+	target map[string]interface{},
+) *httpTaskResult {
+	t.Helper()
+
+	tk, env := testPrepareTask(t, "http", option, target)
+	stat, err := tk.(*httpTask).doRunHttp(context.Background(), env)
+	if err != nil {
+		t.Fatalf("http run failed: %s", err)
+	}
+	return stat
+}
+
+// target of the test server
+func testServerTarget(t *testing.T, addr net.Addr) map[string]interface{} {
+	t.Helper()
+
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		t.Fatalf("invalid server address %s: %s", addr, err)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatalf("invalid server port %s: %s", port, err)
+	}
+	return map[string]interface{}{
+		"name": "local",
+		"ip":   host,
+		"port": p,
+	}
+}
@@ -0,0 +1,535 @@
+package builtin
+
+import (
+	"github.com/dianpeng/hi-doctor/check"
+	"github.com/dianpeng/hi-doctor/dvar"
+	"github.com/dianpeng/hi-doctor/spec"
+	"github.com/dianpeng/hi-doctor/task"
+	"github.com/dianpeng/hi-doctor/util"
+
+	"github.com/mitchellh/mapstructure"
+
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"regexp"
+	"time"
+)
+
+// TCP script task, dial target.ip:port and then run a sequence of steps
+// against the connection, each step either sends data, expects data or
+// upgrades the connection to tls. The data matched by an expect step with a
+// capture name is exposed as tcp_script.captures.<name>, which is a list of
+// the whole match followed by the regex groups if any.
+//
+//   steps:
+//     - send: "PING\r\n"
+//     - expect:
+//         regex: '^\+(PONG)\r\n'
+//         capture: pong
+//     - send_hex: 0a0b
+//     - expect:
+//         length: 4
+//         timeout: 2
+//     - starttls: true
+
+const (
+	tcpScriptSend = iota
+	tcpScriptExpect
+	tcpScriptStartTLS
+)
+
+var tcpScriptStepName = []string{
+	"send",
+	"expect",
+	"starttls",
+}
+
+// max # of bytes buffered while waiting for an expect step to match
+const tcpScriptMaxBuffer = 1 << 20
+
+type tcpScriptExpectDefine struct {
+	Regex   string `mapstructure:"regex"`
+	Bytes   string `mapstructure:"bytes"`
+	Hex     string `mapstructure:"hex"`
+	Length  int    `mapstructure:"length"`
+	Timeout int64  `mapstructure:"timeout"`
+	Capture string `mapstructure:"capture"`
+}
+
+type tcpScriptStepDefine struct {
+	Send     string                 `mapstructure:"send"`
+	SendHex  string                 `mapstructure:"send_hex"`
+	Expect   *tcpScriptExpectDefine `mapstructure:"expect"`
+	StartTLS bool                   `mapstructure:"starttls"`
+}
+
+type tcpScriptDefine struct {
+	Name    string                `mapstructure:"name"`
+	Timeout int64                 `mapstructure:"timeout"`
+	Port    uint16                `mapstructure:"port"`
+	TLS     tlsClientDefine       `mapstructure:"tls"`
+	Steps   []tcpScriptStepDefine `mapstructure:"steps"`
+}
+
+type tcpScriptStep struct {
+	kind int
+
+	// send
+	send    dvar.DVar
+	sendHex []byte
+
+	// expect, one of regex, bytes and length
+	regex   *regexp.Regexp
+	bytes   []byte
+	length  int
+	timeout time.Duration
+	capture string
+}
+
+type tcpScriptTemplate struct {
+	name      string
+	timeout   time.Duration
+	port      uint16
+	steps     []tcpScriptStep
+	tlsEnable bool
+	tlsVerify bool
+	tlsSNI    dvar.DVar
+	tlsConfig *tls.Config
+	check     check.Check
+}
+
+type tcpScriptTask struct {
+	t       *tcpScriptTemplate
+	address string
+	port    uint16
+	sni     string
+	send    [][]byte // data of each send step, indexed by step
+}
+
+type tcpScriptStepResult struct {
+	Kind  string `json:"kind"`
+	OK    bool   `json:"ok"`
+	RT    int64  `json:"rt"`
+	Data  string `json:"data"`
+	Error string `json:"error"`
+}
+
+type tcpScriptResult struct {
+	Timestamp    int64  `json:"timestamp"`
+	RT           int64  `json:"rt"`
+	ConnectRT    int64  `json:"connect_rt"`
+	Address      string `json:"address"`
+	Port         uint16 `json:"port"`
+	LocalAddress string `json:"local_address"`
+	OK           bool   `json:"ok"`
+	Error        string `json:"error"`
+	FailedStep   int    `json:"failed_step"`
+	TLS          bool   `json:"tls"`
+	TLSVersion   string `json:"tls_version"`
+
+	Step     []tcpScriptStepResult `json:"step"`
+	Captures map[string][]string   `json:"captures"`
+}
+
+type tcpScriptTaskFactory struct{}
+
+func (f *tcpScriptTaskFactory) SanityCheck(spec.TaskOption) error {
+	return nil
+}
+
+func compileTcpScriptStep(
+	idx int,
+	d *tcpScriptStepDefine,
+	timeout time.Duration,
+) (tcpScriptStep, error) {
+	out := tcpScriptStep{}
+	n := 0
+
+	if d.Send != "" {
+		n++
+		out.kind = tcpScriptSend
+		if dv, err := dvar.NewDVarStringContext(d.Send); err != nil {
+			return out, fmt.Errorf("tcp_script steps[%d].send compile failed: %s", idx, err)
+		} else {
+			out.send = dv
+		}
+	}
+	if d.SendHex != "" {
+		n++
+		out.kind = tcpScriptSend
+		if v, err := hex.DecodeString(d.SendHex); err != nil {
+			return out, fmt.Errorf("tcp_script steps[%d].send_hex is invalid: %s", idx, err)
+		} else {
+			out.sendHex = v
+		}
+	}
+	if d.StartTLS {
+		n++
+		out.kind = tcpScriptStartTLS
+	}
+
+	if e := d.Expect; e != nil {
+		n++
+		out.kind = tcpScriptExpect
+		out.capture = e.Capture
+		out.timeout = timeout
+		if e.Timeout > 0 {
+			out.timeout = time.Duration(e.Timeout) * time.Second
+		}
+
+		m := 0
+		if e.Regex != "" {
+			m++
+			if re, err := regexp.Compile(e.Regex); err != nil {
+				return out, fmt.Errorf("tcp_script steps[%d].expect.regex is invalid: %s", idx, err)
+			} else {
+				out.regex = re
+			}
+		}
+		if e.Bytes != "" {
+			m++
+			out.bytes = []byte(e.Bytes)
+		}
+		if e.Hex != "" {
+			m++
+			if v, err := hex.DecodeString(e.Hex); err != nil {
+				return out, fmt.Errorf("tcp_script steps[%d].expect.hex is invalid: %s", idx, err)
+			} else {
+				out.bytes = v
+			}
+		}
+		if e.Length > 0 {
+			m++
+			out.length = e.Length
+		}
+		if m != 1 {
+			return out, fmt.Errorf(
+				"tcp_script steps[%d].expect must have exactly one of regex, bytes, hex and length",
+				idx,
+			)
+		}
+	}
+
+	if n != 1 {
+		return out, fmt.Errorf(
+			"tcp_script steps[%d] must have exactly one of send, send_hex, expect and starttls",
+			idx,
+		)
+	}
+	return out, nil
+}
+
+func (f *tcpScriptTaskFactory) Compile(
+	x spec.TaskOption,
+	c *spec.Check,
+) (task.TaskPlanner, error) {
+	opt := &tcpScriptDefine{
+		Timeout: 30,
+	}
+	if err := mapstructure.Decode(x, opt); err != nil {
+		return nil, fmt.Errorf("tcp_script, invalid option input: %s", err)
+	}
+
+	out := &tcpScriptTemplate{
+		name:      opt.Name,
+		timeout:   time.Duration(opt.Timeout) * time.Second,
+		port:      opt.Port,
+		tlsEnable: opt.TLS.Enable,
+		tlsVerify: opt.TLS.Verify,
+	}
+
+	for i := range opt.Steps {
+		if step, err := compileTcpScriptStep(i, &opt.Steps[i], out.timeout); err != nil {
+			return nil, err
+		} else {
+			out.steps = append(out.steps, step)
+		}
+	}
+
+	if dv, err := dvar.NewDVarStringContext(opt.TLS.Option.SNI); err != nil {
+		return nil, fmt.Errorf("tcp_script tls.sni compile failed: %s", err)
+	} else {
+		out.tlsSNI = dv
+	}
+	if cfg, err := compileTLSConfig("tcp_script", &opt.TLS.Option); err != nil {
+		return nil, err
+	} else {
+		out.tlsConfig = cfg
+	}
+
+	if ck, err := check.CompileCheck(c); err != nil {
+		return nil, fmt.Errorf("tcp_script, check compilation fail: %s", err)
+	} else {
+		out.check = ck
+	}
+	return out, nil
+}
+
+func (f *tcpScriptTemplate) Description() string {
+	return fmt.Sprintf("tcp_script(%s)", f.name)
+}
+
+func (f *tcpScriptTemplate) GenTask(env *dvar.EvalEnv) (task.TaskList, error) {
+	return task.TaskList{
+		&tcpScriptTask{
+			t: f,
+		},
+	}, nil
+}
+
+func (t *tcpScriptTask) name() string {
+	return t.t.name
+}
+
+func (t *tcpScriptTask) Prepare(_ context.Context, env *dvar.EvalEnv) error {
+	jobName := fmt.Sprintf("tcp_script(%s)", t.name())
+
+	if addr, err := targetAddress(jobName, env); err != nil {
+		return err
+	} else {
+		t.address = addr
+	}
+
+	// port, the task's port takes priority, then the target's
+	if t.t.port != 0 {
+		t.port = t.t.port
+	} else if port, err := targetPort(jobName, env, 0); err != nil {
+		return err
+	} else {
+		t.port = port
+	}
+
+	// sni, fallback to the target's hostname if any
+	if vv, err := t.t.tlsSNI.Value(env); err != nil {
+		return fmt.Errorf("%s tls.sni execution failed: %s", jobName, err)
+	} else if sni := vv.String(); sni != "" {
+		t.sni = sni
+	} else {
+		hostV := env.GetDef("target", "hostname", dvar.NewStringVal(""))
+		t.sni = hostV.String()
+	}
+
+	// data to send
+	t.send = make([][]byte, len(t.t.steps))
+	for i := range t.t.steps {
+		step := &t.t.steps[i]
+		if step.kind != tcpScriptSend {
+			continue
+		}
+		if step.sendHex != nil {
+			t.send[i] = step.sendHex
+		} else if vv, err := step.send.Value(env); err != nil {
+			return fmt.Errorf("%s steps[%d].send execution failed: %s", jobName, i, err)
+		} else {
+			t.send[i] = []byte(vv.String())
+		}
+	}
+	return nil
+}
+
+func (t *tcpScriptTask) Description() string {
+	return fmt.Sprintf("tcp_script[%s]", t.name())
+}
+
+// the connection and the data received but not consumed yet
+type tcpScriptConn struct {
+	conn    net.Conn
+	pending []byte
+}
+
+func (t *tcpScriptTask) handshake(c *tcpScriptConn, stat *tcpScriptResult) error {
+	cfg := tlsClientConfig(t.t.tlsConfig, t.sni, t.t.tlsVerify)
+	tlsConn := tls.Client(c.conn, cfg)
+	tlsConn.SetDeadline(time.Now().Add(t.t.timeout))
+	if err := tlsConn.Handshake(); err != nil {
+		return fmt.Errorf("tls handshake failed: %s", err)
+	}
+	tlsConn.SetDeadline(time.Time{})
+
+	c.conn = tlsConn
+	stat.TLS = true
+	stat.TLSVersion = util.GetTLSVersionName(tlsConn.ConnectionState().Version)
+	return nil
+}
+
+// match the expect step against the pending data, returns the # of bytes
+// consumed and the captures, or -1 if not matched yet
+func (s *tcpScriptStep) match(pending []byte) (int, []string) {
+	switch {
+	case s.regex != nil:
+		loc := s.regex.FindSubmatchIndex(pending)
+		if loc == nil {
+			return -1, nil
+		}
+		out := []string{}
+		for i := 0; i < len(loc); i += 2 {
+			if loc[i] < 0 {
+				out = append(out, "")
+			} else {
+				out = append(out, string(pending[loc[i]:loc[i+1]]))
+			}
+		}
+		return loc[1], out
+
+	case s.bytes != nil:
+		idx := bytes.Index(pending, s.bytes)
+		if idx < 0 {
+			return -1, nil
+		}
+		end := idx + len(s.bytes)
+		return end, []string{string(pending[:end])}
+
+	default:
+		if len(pending) < s.length {
+			return -1, nil
+		}
+		return s.length, []string{string(pending[:s.length])}
+	}
+}
+
+func (t *tcpScriptTask) expect(
+	c *tcpScriptConn,
+	step *tcpScriptStep,
+) (string, []string, error) {
+	deadline := time.Now().Add(step.timeout)
+	buf := make([]byte, 4096)
+
+	for {
+		if n, captures := step.match(c.pending); n >= 0 {
+			data := string(c.pending[:n])
+			c.pending = c.pending[n:]
+			return data, captures, nil
+		}
+		if len(c.pending) > tcpScriptMaxBuffer {
+			return "", nil, fmt.Errorf("no match within %d bytes", tcpScriptMaxBuffer)
+		}
+
+		c.conn.SetReadDeadline(deadline)
+		n, err := c.conn.Read(buf)
+		c.pending = append(c.pending, buf[:n]...)
+		if err != nil {
+			if n > 0 {
+				continue
+			}
+			return "", nil, fmt.Errorf("%s, received %q", err, c.pending)
+		}
+	}
+}
+
+func (t *tcpScriptTask) runStep(
+	c *tcpScriptConn,
+	idx int,
+	stat *tcpScriptResult,
+) (tcpScriptStepResult, error) {
+	step := &t.t.steps[idx]
+	out := tcpScriptStepResult{
+		Kind: tcpScriptStepName[step.kind],
+	}
+
+	var err error
+	switch step.kind {
+	case tcpScriptSend:
+		c.conn.SetWriteDeadline(time.Now().Add(t.t.timeout))
+		_, err = c.conn.Write(t.send[idx])
+		out.Data = string(t.send[idx])
+
+	case tcpScriptExpect:
+		var captures []string
+		out.Data, captures, err = t.expect(c, step)
+		if err == nil && step.capture != "" {
+			stat.Captures[step.capture] = captures
+		}
+
+	case tcpScriptStartTLS:
+		if len(c.pending) != 0 {
+			err = fmt.Errorf("starttls with %d bytes not consumed", len(c.pending))
+		} else {
+			err = t.handshake(c, stat)
+		}
+	}
+
+	if err != nil {
+		out.Error = fmt.Sprintf("%s", err)
+	} else {
+		out.OK = true
+	}
+	return out, err
+}
+
+func (t *tcpScriptTask) runScript(ctx context.Context) *tcpScriptResult {
+	stat := &tcpScriptResult{
+		Address:    t.address,
+		Port:       t.port,
+		FailedStep: -1,
+		Step:       []tcpScriptStepResult{},
+		Captures:   make(map[string][]string),
+	}
+
+	start := time.Now()
+	stat.Timestamp = start.UnixMilli()
+	defer func() {
+		stat.RT = time.Since(start).Milliseconds()
+	}()
+
+	addrAndPort := net.JoinHostPort(t.address, fmt.Sprintf("%d", t.port))
+	d := net.Dialer{Timeout: t.t.timeout}
+	conn, err := d.DialContext(ctx, "tcp", addrAndPort)
+	stat.ConnectRT = time.Since(start).Milliseconds()
+	if err != nil {
+		stat.Error = fmt.Sprintf("%s", err)
+		return stat
+	}
+	stat.LocalAddress = conn.LocalAddr().String()
+
+	c := &tcpScriptConn{
+		conn: conn,
+	}
+	// the connection may be upgraded to tls, closes whatever is the latest
+	stop := closeOnDone(ctx, conn)
+	defer func() {
+		stop()
+		c.conn.Close()
+	}()
+
+	if t.t.tlsEnable {
+		if err := t.handshake(c, stat); err != nil {
+			stat.Error = fmt.Sprintf("%s", err)
+			return stat
+		}
+	}
+
+	for i := range t.t.steps {
+		stepStart := time.Now()
+		r, err := t.runStep(c, i, stat)
+		r.RT = time.Since(stepStart).Milliseconds()
+		stat.Step = append(stat.Step, r)
+
+		if err != nil {
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
+			stat.FailedStep = i
+			stat.Error = fmt.Sprintf("steps[%d] %s failed: %s", i, r.Kind, err)
+			return stat
+		}
+	}
+
+	stat.OK = true
+	return stat
+}
+
+func (t *tcpScriptTask) Run(ctx context.Context, env *dvar.EvalEnv) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	env.RecordHistoricalResult("tcp_script", util.ToMapInterface(t.runScript(ctx)))
+	return t.t.check.Run(env)
+}
+
+func init() {
+	task.RegisterTaskFactory("tcp_script", &tcpScriptTaskFactory{})
+}
@@ -0,0 +1,186 @@
+package builtin
+
+import (
+	"github.com/dianpeng/hi-doctor/dvar"
+	"github.com/dianpeng/hi-doctor/spec"
+	"github.com/dianpeng/hi-doctor/task"
+
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// redis alike conversation with a STARTTLS upgrade in the middle
+const tcpScriptRedisOption = `
+name: redis
+timeout: 5
+steps:
+  - send: "PING\r\n"
+  - expect:
+      regex: '^\+(PONG)\r\n'
+      capture: pong
+  - send: "INFO $<<target.name>>\r\n"
+  - expect:
+      regex: 'redis_version:([0-9.]+)\r\n'
+      capture: version
+  - send: "STARTTLS\r\n"
+  - expect:
+      bytes: "+OK\r\n"
+  - starttls: true
+  - send_hex: 42494e0d0a
+  - expect:
+      hex: "0001"
+  - expect:
+      length: 2
+      capture: rest
+`
+
+// waits for something never sent
+const tcpScriptNeverOption = `
+steps:
+  - send: "PING\r\n"
+  - expect:
+      bytes: NEVER
+      timeout: 1
+`
+
+func testSelfSignedCert(t *testing.T) tls.Certificate {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate failed: %s", err)
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}
+}
+
+func serveTestRedis(conn net.Conn, cert tls.Certificate) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch line {
+		case "PING\r\n":
+			conn.Write([]byte("+PONG\r\n"))
+		case "INFO local\r\n":
+			conn.Write([]byte("# Server\r\nredis_version:7.0.1\r\n"))
+		case "STARTTLS\r\n":
+			conn.Write([]byte("+OK\r\n"))
+			tlsConn := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{cert}})
+			if tlsConn.Handshake() != nil {
+				return
+			}
+			conn = tlsConn
+			r = bufio.NewReader(conn)
+		case "BIN\r\n":
+			conn.Write([]byte{0, 1, 2, 3})
+		}
+	}
+}
+
+func TestTcpScript(t *testing.T) {
+	cert := testSelfSignedCert(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %s", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestRedis(conn, cert)
+		}
+	}()
+
+	target := testServerTarget(t, ln.Addr())
+
+	tk, _ := testPrepareTask(t, "tcp_script", tcpScriptRedisOption, target)
+	stat := tk.(*tcpScriptTask).runScript(context.Background())
+	if !stat.OK {
+		t.Fatalf("redis, ok: got false, want true, error: %s", stat.Error)
+	}
+	if !stat.TLS {
+		t.Errorf("redis, tls: got false, want true")
+	}
+	if got := stat.Captures["pong"]; len(got) != 2 || got[1] != "PONG" {
+		t.Errorf("redis, captures.pong: got %q, want [.., PONG]", got)
+	}
+	if got := stat.Captures["version"]; len(got) != 2 || got[1] != "7.0.1" {
+		t.Errorf("redis, captures.version: got %q, want [.., 7.0.1]", got)
+	}
+	if got := stat.Captures["rest"]; len(got) != 1 || got[0] != "\x02\x03" {
+		t.Errorf("redis, captures.rest: got %q, want [\\x02\\x03]", got)
+	}
+	if len(stat.Step) < 4 {
+		t.Fatalf("redis, # of steps: got %d, want at least 4", len(stat.Step))
+	}
+	if got, want := stat.Step[3].Data, "# Server\r\nredis_version:7.0.1\r\n"; got != want {
+		t.Errorf("redis, step[3].data: got %q, want %q", got, want)
+	}
+
+	tk, _ = testPrepareTask(t, "tcp_script", tcpScriptNeverOption, target)
+	stat = tk.(*tcpScriptTask).runScript(context.Background())
+	if stat.OK {
+		t.Errorf("never, ok: got true, want false")
+	}
+	if stat.FailedStep != 1 {
+		t.Errorf("never, failed_step: got %d, want 1", stat.FailedStep)
+	}
+	if len(stat.Step) < 2 {
+		t.Fatalf("never, # of steps: got %d, want at least 2", len(stat.Step))
+	}
+	if stat.Step[1].Data != "" {
+		t.Errorf("never, step[1].data: got %q, want empty", stat.Step[1].Data)
+	}
+}
+
+func TestTcpScriptPreparePort(t *testing.T) {
+	tk, _ := testPrepareTask(t, "tcp_script", tcpScriptNeverOption, map[string]interface{}{
+		"ip":        "127.0.0.1",
+		"port_list": []interface{}{6379, 6380},
+	})
+	if got := tk.(*tcpScriptTask).port; got != 6379 {
+		t.Errorf("port of port_list: got %d, want 6379", got)
+	}
+
+	// the port is required
+	planner, err := task.GetTaskFactory("tcp_script").Compile(spec.TaskOption{
+		"steps": []interface{}{map[string]interface{}{"send": "PING"}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("compile failed: %s", err)
+	}
+	env := dvar.NewEvalEnv()
+	env.Set("target", "ip", dvar.NewStringVal("127.0.0.1"))
+	tasks, err := planner.GenTask(env)
+	if err != nil {
+		t.Fatalf("generate task failed: %s", err)
+	}
+	if err := tasks[0].Prepare(context.Background(), env); err == nil {
+		t.Errorf("prepare without port: got no error, want error")
+	}
+}
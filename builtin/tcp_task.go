@@ -9,6 +9,7 @@ import (
 
 	"github.com/mitchellh/mapstructure"
 
+	"context"
 	"fmt"
 	"net"
 	"time"
@@ -54,7 +55,7 @@ func (f *tcpTaskFactory) Compile(
 	opt := &tcpTaskDefine{
 		Timeout: 30,
 	}
-	err := mapstructure.Decode(opt, opt)
+	err := mapstructure.Decode(x, opt)
 	if err != nil {
 		return nil, fmt.Errorf("tcp_task, invalid option input: %s", err)
 	}
@@ -88,45 +89,22 @@ func (t *tcpTask) name() string {
 	return t.t.name
 }
 
-func (t *tcpTask) Prepare(env *dvar.EvalEnv) error {
-	pr := []uint16{}
-	addr := ""
-
-	// 1) check whether has a port_list field, if so use it
-	// 2) or check whether env has a port field, if so use it
-	if dv, ok := env.Get("target", "port"); ok {
-		if port, ok := dv.Port(); !ok {
-			return fmt.Errorf("tcp_task(%s), invalid port number", t.name())
-		} else {
-			pr = append(pr, port)
-		}
-	} else if dv, ok := env.Get("target", "port_list"); ok {
-		if prList, ok := dv.PortList(); !ok {
-			return fmt.Errorf("tcp_task(%s), invalid port list", t.name())
-		} else {
-			pr = prList
-		}
+func (t *tcpTask) Prepare(_ context.Context, env *dvar.EvalEnv) error {
+	jobName := fmt.Sprintf("tcp_task(%s)", t.name())
+
+	// 1) check whether env has a port field, if so use it
+	// 2) or check whether has a port_list field, if so use it
+	if pr, err := targetPortList(jobName, env); err != nil {
+		return err
 	} else {
-		return fmt.Errorf(
-			"tcp_task(%s) does not have port/port_list definition",
-			t.name(),
-		)
+		t.portRange = pr
 	}
 
-	// addrese
-	if dv, ok := env.Get("target", "addr"); ok {
-		addr = dv.String()
-	} else if dv, ok := env.Get("target", "ip"); ok {
-		addr = dv.String()
+	if addr, err := targetAddress(jobName, env); err != nil {
+		return err
 	} else {
-		return fmt.Errorf(
-			"tcp_task(%s) does not have proper address define, either address/ip should be defined",
-			t.name(),
-		)
+		t.address = addr
 	}
-
-	t.address = addr
-	t.portRange = pr
 	return nil
 }
 
@@ -134,8 +112,12 @@ func (t *tcpTask) Description() string {
 	return fmt.Sprintf("tcp_task[%s]", t.name())
 }
 
-func (t *tcpTask) runTcpTask(env *dvar.EvalEnv, port uint16) *tcpTaskResult {
-	addrAndPort := fmt.Sprintf("%s:%d", t.address, port)
+func (t *tcpTask) runTcpTask(
+	ctx context.Context,
+	env *dvar.EvalEnv,
+	port uint16,
+) *tcpTaskResult {
+	addrAndPort := net.JoinHostPort(t.address, fmt.Sprintf("%d", port))
 	stat := &tcpTaskResult{
 		Port:    port,
 		Address: t.address,
@@ -143,7 +125,7 @@ func (t *tcpTask) runTcpTask(env *dvar.EvalEnv, port uint16) *tcpTaskResult {
 
 	start := time.Now().UnixMilli()
 	d := net.Dialer{Timeout: time.Duration(t.t.timeout) * time.Second}
-	conn, err := d.Dial("tcp", addrAndPort)
+	conn, err := d.DialContext(ctx, "tcp", addrAndPort)
 	end := time.Now().UnixMilli()
 	defer func() {
 		if conn != nil {
@@ -165,10 +147,14 @@ func (t *tcpTask) runTcpTask(env *dvar.EvalEnv, port uint16) *tcpTaskResult {
 	return stat
 }
 
-func (t *tcpTask) Run(env *dvar.EvalEnv) error {
+func (t *tcpTask) Run(ctx context.Context, env *dvar.EvalEnv) error {
 	for _, port := range t.portRange {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		// run the tcp task
-		stat := util.ToMapInterface(t.runTcpTask(env, port))
+		stat := util.ToMapInterface(t.runTcpTask(ctx, env, port))
 
 		// record the result
 		env.RecordHistoricalResult(
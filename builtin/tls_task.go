@@ -0,0 +1,250 @@
+package builtin
+
+import (
+	"github.com/dianpeng/hi-doctor/check"
+	"github.com/dianpeng/hi-doctor/dvar"
+	"github.com/dianpeng/hi-doctor/spec"
+	"github.com/dianpeng/hi-doctor/task"
+	"github.com/dianpeng/hi-doctor/util"
+
+	"github.com/mitchellh/mapstructure"
+
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+)
+
+// TLS task, perform a tls handshake against target.ip:port and expose the
+// negotiated parameters along with the peer's certificate chain
+
+const tlsDefPort = 443
+
+type tlsTaskTemplate struct {
+	name    string
+	timeout int64
+	port    uint16
+	sni     dvar.DVar
+	config  *tls.Config
+	check   check.Check
+}
+
+type tlsTask struct {
+	t       *tlsTaskTemplate
+	address string
+	port    uint16
+	sni     string
+}
+
+type tlsTaskDefine struct {
+	Name    string          `mapstructure:"name"`
+	Timeout int64           `mapstructure:"timeout"`
+	Port    uint16          `mapstructure:"port"`
+	TLS     tlsOptionDefine `mapstructure:",squash"`
+}
+
+type tlsTaskResult struct {
+	Timestamp   int64  `json:"timestamp"`
+	RT          int64  `json:"rt"`
+	ConnectRT   int64  `json:"connect_rt"`
+	HandshakeRT int64  `json:"handshake_rt"`
+	Address     string `json:"address"`
+	Port        uint16 `json:"port"`
+	ServerName  string `json:"server_name"`
+	OK          bool   `json:"ok"`
+	Error       string `json:"error"`
+
+	Version            string `json:"version"`
+	CipherSuite        string `json:"cipher"`
+	NegotiatedProtocol string `json:"alpn"`
+	OCSPStapled        bool   `json:"ocsp_stapled"`
+
+	Verified     bool          `json:"verified"`
+	VerifyError  string        `json:"verify_error"`
+	DaysToExpiry int64         `json:"days_to_expiry"` // of the leaf
+	Chain        []tlsCertInfo `json:"chain"`
+}
+
+type tlsTaskFactory struct{}
+
+func (f *tlsTaskFactory) SanityCheck(spec.TaskOption) error {
+	return nil
+}
+
+func (f *tlsTaskFactory) Compile(
+	x spec.TaskOption,
+	c *spec.Check,
+) (task.TaskPlanner, error) {
+	opt := &tlsTaskDefine{
+		Timeout: 30,
+	}
+	err := mapstructure.Decode(x, opt)
+	if err != nil {
+		return nil, fmt.Errorf("tls_task, invalid option input: %s", err)
+	}
+
+	out := &tlsTaskTemplate{
+		name:    opt.Name,
+		timeout: opt.Timeout,
+		port:    opt.Port,
+	}
+
+	if dv, err := dvar.NewDVarStringContext(opt.TLS.SNI); err != nil {
+		return nil, fmt.Errorf("tls_task.SNI compile failed: %s", err)
+	} else {
+		out.sni = dv
+	}
+
+	if cfg, err := compileTLSConfig("tls_task", &opt.TLS); err != nil {
+		return nil, err
+	} else {
+		out.config = cfg
+	}
+
+	if ck, err := check.CompileCheck(c); err != nil {
+		return nil, fmt.Errorf("tls_task, check compilation fail: %s", err)
+	} else {
+		out.check = ck
+	}
+	return out, nil
+}
+
+func (f *tlsTaskTemplate) Description() string {
+	return fmt.Sprintf("tls_task(%s)", f.name)
+}
+
+func (f *tlsTaskTemplate) GenTask(env *dvar.EvalEnv) (task.TaskList, error) {
+	return task.TaskList{
+		&tlsTask{
+			t: f,
+		},
+	}, nil
+}
+
+func (t *tlsTask) name() string {
+	return t.t.name
+}
+
+func (t *tlsTask) Prepare(_ context.Context, env *dvar.EvalEnv) error {
+	jobName := fmt.Sprintf("tls_task(%s)", t.name())
+
+	if addr, err := targetAddress(jobName, env); err != nil {
+		return err
+	} else {
+		t.address = addr
+	}
+
+	// port, the task's port takes priority, then the target's and lastly 443
+	if t.t.port != 0 {
+		t.port = t.t.port
+	} else if port, err := targetPort(jobName, env, tlsDefPort); err != nil {
+		return err
+	} else {
+		t.port = port
+	}
+
+	// sni, fallback to the target's hostname if any
+	if vv, err := t.t.sni.Value(env); err != nil {
+		return fmt.Errorf("%s.SNI execution failed: %s", jobName, err)
+	} else if sni := vv.String(); sni != "" {
+		t.sni = sni
+	} else {
+		hostV := env.GetDef("target", "hostname", dvar.NewStringVal(""))
+		t.sni = hostV.String()
+	}
+	return nil
+}
+
+func (t *tlsTask) Description() string {
+	return fmt.Sprintf("tls_task[%s]", t.name())
+}
+
+func (t *tlsTask) runTlsTask(ctx context.Context, env *dvar.EvalEnv) *tlsTaskResult {
+	stat := &tlsTaskResult{
+		Address:    t.address,
+		Port:       t.port,
+		ServerName: t.sni,
+		Chain:      []tlsCertInfo{},
+	}
+
+	addrAndPort := net.JoinHostPort(t.address, fmt.Sprintf("%d", t.port))
+	timeout := time.Duration(t.t.timeout) * time.Second
+
+	start := time.Now()
+	stat.Timestamp = start.UnixMilli()
+
+	d := net.Dialer{Timeout: timeout}
+	conn, err := d.DialContext(ctx, "tcp", addrAndPort)
+	connected := time.Now()
+	stat.ConnectRT = connected.Sub(start).Milliseconds()
+
+	if err != nil {
+		stat.RT = stat.ConnectRT
+		stat.Error = fmt.Sprintf("%s", err)
+		stat.OK = false
+		return stat
+	}
+
+	cfg := t.t.config.Clone()
+	cfg.ServerName = t.sni
+
+	tlsConn := tls.Client(conn, cfg)
+	defer tlsConn.Close()
+
+	tlsConn.SetDeadline(start.Add(timeout))
+	err = tlsConn.HandshakeContext(ctx)
+	end := time.Now()
+
+	stat.HandshakeRT = end.Sub(connected).Milliseconds()
+	stat.RT = end.Sub(start).Milliseconds()
+
+	if err != nil {
+		stat.Error = fmt.Sprintf("%s", err)
+		stat.OK = false
+		return stat
+	}
+	stat.OK = true
+
+	state := tlsConn.ConnectionState()
+	stat.Version = util.GetTLSVersionName(state.Version)
+	stat.CipherSuite = util.GetTLSCipherSuitesName(state.CipherSuite)
+	stat.NegotiatedProtocol = state.NegotiatedProtocol
+	stat.OCSPStapled = len(state.OCSPResponse) != 0
+
+	for _, c := range state.PeerCertificates {
+		stat.Chain = append(stat.Chain, newTLSCertInfo(c, end))
+	}
+	if len(stat.Chain) != 0 {
+		stat.DaysToExpiry = stat.Chain[0].DaysToExpiry
+	}
+
+	if err := verifyTLSChain(state.PeerCertificates, cfg.RootCAs, t.sni); err != nil {
+		stat.Verified = false
+		stat.VerifyError = fmt.Sprintf("%s", err)
+	} else {
+		stat.Verified = true
+	}
+	return stat
+}
+
+func (t *tlsTask) Run(ctx context.Context, env *dvar.EvalEnv) error {
+	// run the tls handshake
+	stat := util.ToMapInterface(t.runTlsTask(ctx, env))
+
+	// record the result
+	env.RecordHistoricalResult(
+		"tls",
+		stat,
+	)
+
+	// run the check
+	if err := t.t.check.Run(env); err != nil {
+		return err
+	}
+	return nil
+}
+
+func init() {
+	task.RegisterTaskFactory("tls", &tlsTaskFactory{})
+}
@@ -0,0 +1,190 @@
+package builtin
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"math"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func testTlsTask(t *testing.T, server *httptest.Server, option *tlsOptionDefine, sni string) *tlsTask {
+	config, err := compileTLSConfig("test", option)
+	if err != nil {
+		t.Fatalf("compileTLSConfig: %s", err)
+	}
+
+	host, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("invalid server address: %s", err)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		t.Fatalf("invalid server port: %s", err)
+	}
+
+	return &tlsTask{
+		t: &tlsTaskTemplate{
+			name:    "test",
+			timeout: 5,
+			config:  config,
+		},
+		address: host,
+		port:    uint16(p),
+		sni:     sni,
+	}
+}
+
+// the server's certificate as the CA bundle, it is self signed
+func testTlsCAFile(t *testing.T, server *httptest.Server) string {
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: server.Certificate().Raw,
+	})
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("write ca file: %s", err)
+	}
+	return path
+}
+
+func TestTlsTaskChain(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer server.Close()
+
+	cert := server.Certificate()
+	task := testTlsTask(t, server, &tlsOptionDefine{CAFile: testTlsCAFile(t, server)}, "example.com")
+
+	stat := task.runTlsTask(context.Background(), nil)
+	if !stat.OK {
+		t.Fatalf("ok: got false, want true, error: %s", stat.Error)
+	}
+	if !stat.Verified {
+		t.Errorf("verified: got false, want true, verify_error: %s", stat.VerifyError)
+	}
+	if stat.VerifyError != "" {
+		t.Errorf("verify_error: got %q, want empty", stat.VerifyError)
+	}
+	if stat.ServerName != "example.com" {
+		t.Errorf("server_name: got %q, want %q", stat.ServerName, "example.com")
+	}
+
+	if len(stat.Chain) != 1 {
+		t.Fatalf("chain length: got %d, want 1", len(stat.Chain))
+	}
+	leaf := stat.Chain[0]
+	if leaf.Subject != cert.Subject.String() {
+		t.Errorf("chain[0].subject: got %q, want %q", leaf.Subject, cert.Subject.String())
+	}
+	if leaf.Issuer != cert.Issuer.String() {
+		t.Errorf("chain[0].issuer: got %q, want %q", leaf.Issuer, cert.Issuer.String())
+	}
+	if leaf.SerialNumber != cert.SerialNumber.String() {
+		t.Errorf("chain[0].serial_number: got %q, want %q", leaf.SerialNumber, cert.SerialNumber.String())
+	}
+	if leaf.NotAfter != cert.NotAfter.UnixMilli() {
+		t.Errorf("chain[0].not_after: got %d, want %d", leaf.NotAfter, cert.NotAfter.UnixMilli())
+	}
+	if leaf.IsCA != cert.IsCA {
+		t.Errorf("chain[0].is_ca: got %t, want %t", leaf.IsCA, cert.IsCA)
+	}
+	if leaf.KeyType == "" || leaf.KeySize == 0 {
+		t.Errorf("chain[0].key_type/key_size: got %q/%d, want non-empty", leaf.KeyType, leaf.KeySize)
+	}
+	hasSAN := false
+	for _, san := range leaf.SANs {
+		if san == "example.com" {
+			hasSAN = true
+		}
+	}
+	if !hasSAN {
+		t.Errorf("chain[0].sans: got %v, want to contain example.com", leaf.SANs)
+	}
+
+	want := int64(math.Floor(time.Until(cert.NotAfter).Hours() / 24))
+	if stat.DaysToExpiry != want {
+		t.Errorf("days_to_expiry: got %d, want %d", stat.DaysToExpiry, want)
+	}
+	if leaf.DaysToExpiry != stat.DaysToExpiry {
+		t.Errorf("chain[0].days_to_expiry: got %d, want %d", leaf.DaysToExpiry, stat.DaysToExpiry)
+	}
+}
+
+func TestTlsTaskNotVerified(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer server.Close()
+
+	// the handshake succeeds regardless, the chain is not trusted by the
+	// system's root or does not match the sni
+	for _, c := range []struct {
+		name   string
+		option *tlsOptionDefine
+		sni    string
+	}{
+		{"untrusted", &tlsOptionDefine{}, "example.com"},
+		{"mismatched sni", &tlsOptionDefine{CAFile: testTlsCAFile(t, server)}, "www.example.org"},
+	} {
+		stat := testTlsTask(t, server, c.option, c.sni).runTlsTask(context.Background(), nil)
+		if !stat.OK {
+			t.Errorf("%s, ok: got false, want true, error: %s", c.name, stat.Error)
+			continue
+		}
+		if stat.Verified {
+			t.Errorf("%s, verified: got true, want false", c.name)
+		}
+		if stat.VerifyError == "" {
+			t.Errorf("%s, verify_error: got empty, want non-empty", c.name)
+		}
+		if len(stat.Chain) != 1 {
+			t.Errorf("%s, chain length: got %d, want 1", c.name, len(stat.Chain))
+		}
+	}
+}
+
+func TestTlsCertInfoDaysToExpiry(t *testing.T) {
+	now := time.Now()
+	for _, c := range []struct {
+		notAfter time.Time
+		want     int64
+	}{
+		{now.Add(49 * time.Hour), 2},
+		{now.Add(23 * time.Hour), 0},
+		{now.Add(-3 * time.Hour), -1},
+		{now.Add(-25 * time.Hour), -2},
+	} {
+		info := newTLSCertInfo(&x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			NotAfter:     c.notAfter,
+		}, now)
+		if info.DaysToExpiry != c.want {
+			t.Errorf("days_to_expiry of %s: got %d, want %d", c.notAfter.Sub(now), info.DaysToExpiry, c.want)
+		}
+	}
+}
+
+func TestTlsTaskPreparePort(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		option string
+		target map[string]interface{}
+		want   uint16
+	}{
+		{"default", ``, map[string]interface{}{"ip": "127.0.0.1"}, 443},
+		{"port", ``, map[string]interface{}{"ip": "127.0.0.1", "port": 8443}, 8443},
+		{"port_list", ``, map[string]interface{}{"addr": "localhost", "port_list": []interface{}{9443, 10443}}, 9443},
+		{"option", `port: 7443`, map[string]interface{}{"ip": "127.0.0.1", "port": 8443}, 7443},
+	} {
+		tk, _ := testPrepareTask(t, "tls", c.option, c.target)
+		if got := tk.(*tlsTask).port; got != c.want {
+			t.Errorf("%s, port: got %d, want %d", c.name, got, c.want)
+		}
+	}
+}
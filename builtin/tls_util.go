@@ -0,0 +1,179 @@
+package builtin
+
+import (
+	"github.com/dianpeng/hi-doctor/util"
+
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"math"
+	"time"
+)
+
+// helper functions for working with tls related options, shared by the tasks
+// that need to perform a tls handshake
+
+type tlsOptionDefine struct {
+	SNI          string   `mapstructure:"sni"`
+	CAFile       string   `mapstructure:"ca_file"`
+	CertFile     string   `mapstructure:"cert_file"`
+	KeyFile      string   `mapstructure:"key_file"`
+	MinVersion   string   `mapstructure:"min_version"`
+	MaxVersion   string   `mapstructure:"max_version"`
+	CipherSuites []string `mapstructure:"cipher_suites"`
+	ALPN         []string `mapstructure:"alpn"`
+}
+
+// compile the tls option into a tls.Config. The certificate verification is
+// always disabled in the returned config, the caller is expected to verify the
+// peer chain by itself against the RootCAs field, which is nil when no CA
+// bundle is specified, ie uses system's root
+func compileTLSConfig(jobName string, d *tlsOptionDefine) (*tls.Config, error) {
+	cfg := &tls.Config{
+		InsecureSkipVerify: true,
+	}
+
+	if d.CAFile != "" {
+		if pool, err := util.LoadCertPool(d.CAFile); err != nil {
+			return nil, fmt.Errorf("%s tls.ca_file load failed: %s", jobName, err)
+		} else {
+			cfg.RootCAs = pool
+		}
+	}
+
+	if d.CertFile != "" || d.KeyFile != "" {
+		if cert, err := tls.LoadX509KeyPair(d.CertFile, d.KeyFile); err != nil {
+			return nil, fmt.Errorf("%s tls.cert_file/key_file load failed: %s", jobName, err)
+		} else {
+			cfg.Certificates = []tls.Certificate{cert}
+		}
+	}
+
+	if d.MinVersion != "" {
+		if v, ok := util.GetTLSVersionByName(d.MinVersion); !ok {
+			return nil, fmt.Errorf("%s tls.min_version %s is unknown", jobName, d.MinVersion)
+		} else {
+			cfg.MinVersion = v
+		}
+	}
+
+	if d.MaxVersion != "" {
+		if v, ok := util.GetTLSVersionByName(d.MaxVersion); !ok {
+			return nil, fmt.Errorf("%s tls.max_version %s is unknown", jobName, d.MaxVersion)
+		} else {
+			cfg.MaxVersion = v
+		}
+	}
+
+	for _, name := range d.CipherSuites {
+		if v, ok := util.GetTLSCipherSuiteByName(name); !ok {
+			return nil, fmt.Errorf("%s tls.cipher_suites %s is unknown", jobName, name)
+		} else {
+			cfg.CipherSuites = append(cfg.CipherSuites, v)
+		}
+	}
+
+	cfg.NextProtos = d.ALPN
+	return cfg, nil
+}
+
+// tls option of the tasks establishing the connection by themselves, the
+// handshake is performed when enable is set and the peer chain is verified
+// when verify is set
+type tlsClientDefine struct {
+	Enable bool            `mapstructure:"enable"`
+	Verify bool            `mapstructure:"verify"`
+	Option tlsOptionDefine `mapstructure:",squash"`
+}
+
+// config used for a single connection, derived from the compiled config. When
+// verify is set, the peer chain is verified against the RootCAs of the config
+// during the handshake
+func tlsClientConfig(base *tls.Config, sni string, verify bool) *tls.Config {
+	cfg := base.Clone()
+	cfg.ServerName = sni
+	if verify {
+		roots := cfg.RootCAs
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			return verifyTLSChain(cs.PeerCertificates, roots, sni)
+		}
+	}
+	return cfg
+}
+
+// verify the peer's chain, the first certificate is the leaf and the rest are
+// treated as intermediates. If dnsName is empty, the hostname is not verified
+func verifyTLSChain(
+	chain []*x509.Certificate,
+	roots *x509.CertPool,
+	dnsName string,
+) error {
+	if len(chain) == 0 {
+		return fmt.Errorf("peer does not present any certificate")
+	}
+
+	inter := x509.NewCertPool()
+	for _, c := range chain[1:] {
+		inter.AddCert(c)
+	}
+
+	_, err := chain[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		DNSName:       dnsName,
+		Intermediates: inter,
+	})
+	return err
+}
+
+type tlsCertInfo struct {
+	Subject            string   `json:"subject"`
+	CommonName         string   `json:"common_name"`
+	SANs               []string `json:"sans"`
+	Issuer             string   `json:"issuer"`
+	SerialNumber       string   `json:"serial_number"`
+	NotBefore          int64    `json:"not_before"`
+	NotAfter           int64    `json:"not_after"`
+	DaysToExpiry       int64    `json:"days_to_expiry"`
+	KeyType            string   `json:"key_type"`
+	KeySize            int      `json:"key_size"`
+	SignatureAlgorithm string   `json:"signature_algorithm"`
+	IsCA               bool     `json:"is_ca"`
+}
+
+func newTLSCertInfo(c *x509.Certificate, now time.Time) tlsCertInfo {
+	out := tlsCertInfo{
+		Subject:            c.Subject.String(),
+		CommonName:         c.Subject.CommonName,
+		SANs:               []string{},
+		Issuer:             c.Issuer.String(),
+		SerialNumber:       c.SerialNumber.String(),
+		NotBefore:          c.NotBefore.UnixMilli(),
+		NotAfter:           c.NotAfter.UnixMilli(),
+		DaysToExpiry:       int64(math.Floor(c.NotAfter.Sub(now).Hours() / 24)),
+		SignatureAlgorithm: c.SignatureAlgorithm.String(),
+		IsCA:               c.IsCA,
+	}
+
+	out.SANs = append(out.SANs, c.DNSNames...)
+	for _, ip := range c.IPAddresses {
+		out.SANs = append(out.SANs, ip.String())
+	}
+
+	switch pub := c.PublicKey.(type) {
+	case *rsa.PublicKey:
+		out.KeyType = "RSA"
+		out.KeySize = pub.N.BitLen()
+	case *ecdsa.PublicKey:
+		out.KeyType = "ECDSA"
+		out.KeySize = pub.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		out.KeyType = "Ed25519"
+		out.KeySize = 256
+	default:
+		out.KeyType = "unknown"
+	}
+	return out
+}
@@ -0,0 +1,292 @@
+package builtin
+
+import (
+	"github.com/dianpeng/hi-doctor/check"
+	"github.com/dianpeng/hi-doctor/dvar"
+	"github.com/dianpeng/hi-doctor/spec"
+	"github.com/dianpeng/hi-doctor/task"
+	"github.com/dianpeng/hi-doctor/util"
+
+	"github.com/mitchellh/mapstructure"
+
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+// UDP task, sends count probes with the payload to each port of the target
+// and waits for a response of each. Each probe uses its own socket, so a late
+// response of the previous probe is never taken as the response of the next
+// one. The port unreachable reported by ICMP is surfaced by the connected
+// socket as connection refused.
+
+type udpTaskTemplate struct {
+	name        string
+	timeout     time.Duration // wait for response of each probe
+	interval    time.Duration // between probes
+	count       int
+	maxResponse int
+	payload     dvar.DVar
+	payloadHex  []byte
+	check       check.Check
+}
+
+type udpTask struct {
+	t         *udpTaskTemplate
+	address   string
+	portRange []uint16
+	payload   []byte
+}
+
+type udpTaskDefine struct {
+	Name        string `mapstructure:"name"`
+	Timeout     int64  `mapstructure:"timeout"`  // in seconds
+	Interval    int64  `mapstructure:"interval"` // in ms
+	Count       int    `mapstructure:"count"`
+	MaxResponse int    `mapstructure:"max_response"`
+	Payload     string `mapstructure:"payload"`
+	PayloadHex  string `mapstructure:"payload_hex"`
+}
+
+type udpTaskResult struct {
+	Timestamp    int64  `json:"timestamp"`
+	Port         uint16 `json:"port"`
+	Address      string `json:"address"`
+	LocalAddress string `json:"local_address"`
+	OK           bool   `json:"ok"`
+	Error        string `json:"error"`
+
+	Sent        int     `json:"sent"`
+	Received    int     `json:"received"`
+	Loss        float64 `json:"loss"`
+	Unreachable bool    `json:"unreachable"`
+
+	// rt of the probes responded, in ms
+	RT    int64   `json:"rt"`
+	RTMin int64   `json:"rt_min"`
+	RTMax int64   `json:"rt_max"`
+	RTs   []int64 `json:"rts"`
+
+	// the last response
+	Response     string `json:"response"`
+	ResponseSize int    `json:"response_size"`
+}
+
+type udpTaskFactory struct{}
+
+func (f *udpTaskFactory) SanityCheck(spec.TaskOption) error {
+	return nil
+}
+
+func (f *udpTaskFactory) Compile(
+	x spec.TaskOption,
+	c *spec.Check,
+) (task.TaskPlanner, error) {
+	opt := &udpTaskDefine{
+		Timeout:     5,
+		Interval:    100,
+		Count:       1,
+		MaxResponse: 65535,
+	}
+	if err := mapstructure.Decode(x, opt); err != nil {
+		return nil, fmt.Errorf("udp_task, invalid option input: %s", err)
+	}
+	if opt.Timeout <= 0 || opt.Count <= 0 || opt.Interval < 0 || opt.MaxResponse <= 0 {
+		return nil, fmt.Errorf("udp_task, timeout, count and max_response must be positive")
+	}
+	if opt.Payload != "" && opt.PayloadHex != "" {
+		return nil, fmt.Errorf("udp_task, payload and payload_hex cannot be both specified")
+	}
+
+	out := &udpTaskTemplate{
+		name:        opt.Name,
+		timeout:     time.Duration(opt.Timeout) * time.Second,
+		interval:    time.Duration(opt.Interval) * time.Millisecond,
+		count:       opt.Count,
+		maxResponse: opt.MaxResponse,
+	}
+
+	if opt.PayloadHex != "" {
+		if v, err := hex.DecodeString(opt.PayloadHex); err != nil {
+			return nil, fmt.Errorf("udp_task, payload_hex is invalid: %s", err)
+		} else {
+			out.payloadHex = v
+		}
+	} else if dv, err := dvar.NewDVarStringContext(opt.Payload); err != nil {
+		return nil, fmt.Errorf("udp_task, payload compile failed: %s", err)
+	} else {
+		out.payload = dv
+	}
+
+	if ck, err := check.CompileCheck(c); err != nil {
+		return nil, fmt.Errorf("udp_task, check compilation fail: %s", err)
+	} else {
+		out.check = ck
+	}
+	return out, nil
+}
+
+func (f *udpTaskTemplate) Description() string {
+	return fmt.Sprintf("udp_task(%s)", f.name)
+}
+
+func (f *udpTaskTemplate) GenTask(env *dvar.EvalEnv) (task.TaskList, error) {
+	return task.TaskList{
+		&udpTask{
+			t: f,
+		},
+	}, nil
+}
+
+func (t *udpTask) name() string {
+	return t.t.name
+}
+
+func (t *udpTask) Prepare(_ context.Context, env *dvar.EvalEnv) error {
+	jobName := fmt.Sprintf("udp_task(%s)", t.name())
+
+	if pr, err := targetPortList(jobName, env); err != nil {
+		return err
+	} else {
+		t.portRange = pr
+	}
+
+	if addr, err := targetAddress(jobName, env); err != nil {
+		return err
+	} else {
+		t.address = addr
+	}
+
+	if t.t.payloadHex != nil {
+		t.payload = t.t.payloadHex
+	} else if vv, err := t.t.payload.Value(env); err != nil {
+		return fmt.Errorf("%s payload execution failed: %s", jobName, err)
+	} else {
+		t.payload = []byte(vv.String())
+	}
+	return nil
+}
+
+func (t *udpTask) Description() string {
+	return fmt.Sprintf("udp_task[%s]", t.name())
+}
+
+// send a single probe, returns the response
+func (t *udpTask) probe(
+	ctx context.Context,
+	addrAndPort string,
+	stat *udpTaskResult,
+) ([]byte, error) {
+	d := net.Dialer{Timeout: t.t.timeout}
+	conn, err := d.DialContext(ctx, "udp", addrAndPort)
+	if err != nil {
+		return nil, err
+	}
+	defer closeOnDone(ctx, conn)()
+	stat.LocalAddress = conn.LocalAddr().String()
+
+	conn.SetDeadline(time.Now().Add(t.t.timeout))
+	if _, err := conn.Write(t.payload); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, t.t.maxResponse)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+func (t *udpTask) runUdpTask(ctx context.Context, port uint16) *udpTaskResult {
+	addrAndPort := net.JoinHostPort(t.address, fmt.Sprintf("%d", port))
+	stat := &udpTaskResult{
+		Port:    port,
+		Address: t.address,
+		RT:      -1,
+		RTMin:   -1,
+		RTMax:   -1,
+		RTs:     []int64{},
+	}
+	stat.Timestamp = time.Now().UnixMilli()
+
+	total := int64(0)
+	for i := 0; i < t.t.count; i++ {
+		if i != 0 && t.t.interval > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(t.t.interval):
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			stat.Error = fmt.Sprintf("%s", err)
+			break
+		}
+
+		start := time.Now()
+		resp, err := t.probe(ctx, addrAndPort, stat)
+		rt := time.Since(start).Milliseconds()
+		stat.Sent++
+
+		if err != nil {
+			if errors.Is(err, syscall.ECONNREFUSED) {
+				stat.Unreachable = true
+			}
+			stat.Error = fmt.Sprintf("%s", err)
+			continue
+		}
+
+		stat.Received++
+		stat.RTs = append(stat.RTs, rt)
+		stat.Response = string(resp)
+		stat.ResponseSize = len(resp)
+
+		total += rt
+		if stat.RTMin < 0 || rt < stat.RTMin {
+			stat.RTMin = rt
+		}
+		if rt > stat.RTMax {
+			stat.RTMax = rt
+		}
+	}
+
+	if stat.Sent > 0 {
+		stat.Loss = float64(stat.Sent-stat.Received) / float64(stat.Sent)
+	}
+	if stat.Received > 0 {
+		stat.OK = true
+		stat.RT = total / int64(stat.Received)
+	}
+	return stat
+}
+
+func (t *udpTask) Run(ctx context.Context, env *dvar.EvalEnv) error {
+	for _, port := range t.portRange {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// run the udp probes
+		stat := util.ToMapInterface(t.runUdpTask(ctx, port))
+
+		// record the result
+		env.RecordHistoricalResult(
+			"udp",
+			stat,
+		)
+
+		// run the check
+		if err := t.t.check.Run(env); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func init() {
+	task.RegisterTaskFactory("udp", &udpTaskFactory{})
+}
@@ -0,0 +1,77 @@
+package builtin
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+// the first port echos, the second one is closed and reported as unreachable
+func TestUdpTask(t *testing.T) {
+	echo, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %s", err)
+	}
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := echo.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			echo.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	// grab a free port and release it
+	closed, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %s", err)
+	}
+	closedPort := closed.LocalAddr().(*net.UDPAddr).Port
+	closed.Close()
+
+	tk, _ := testPrepareTask(t, "udp", `
+timeout: 1
+count: 3
+interval: 10
+payload: "hello $<<target.name>>"
+`, map[string]interface{}{
+		"name":      "local",
+		"ip":        "127.0.0.1",
+		"port_list": []interface{}{echo.LocalAddr().(*net.UDPAddr).Port, closedPort},
+	})
+	udp := tk.(*udpTask)
+
+	stat := udp.runUdpTask(context.Background(), udp.portRange[0])
+	if !stat.OK {
+		t.Errorf("echo, ok: got false, want true, error: %s", stat.Error)
+	}
+	if stat.Received != 3 {
+		t.Errorf("echo, received: got %d, want 3", stat.Received)
+	}
+	if stat.Loss != 0 {
+		t.Errorf("echo, loss: got %v, want 0", stat.Loss)
+	}
+	if stat.Response != "hello local" {
+		t.Errorf("echo, response: got %q, want %q", stat.Response, "hello local")
+	}
+	if stat.ResponseSize != 11 {
+		t.Errorf("echo, response_size: got %d, want 11", stat.ResponseSize)
+	}
+
+	stat = udp.runUdpTask(context.Background(), udp.portRange[1])
+	if stat.OK {
+		t.Errorf("closed, ok: got true, want false")
+	}
+	if !stat.Unreachable {
+		t.Errorf("closed, unreachable: got false, want true")
+	}
+	if stat.Sent != 3 {
+		t.Errorf("closed, sent: got %d, want 3", stat.Sent)
+	}
+	if stat.Loss != 1 {
+		t.Errorf("closed, loss: got %v, want 1", stat.Loss)
+	}
+}
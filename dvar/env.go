@@ -8,6 +8,7 @@ import (
 	"io"
 	"math/rand"
 	"os"
+	"reflect"
 	"strings"
 	"time"
 
@@ -17,7 +18,8 @@ import (
 )
 
 type EvalEnv struct {
-	data map[string]interface{} // for expr library
+	data     map[string]interface{} // for expr library
+	observer func(string, map[string]interface{})
 }
 
 type fieldMap map[string]interface{}
@@ -57,6 +59,95 @@ func (e *EvalEnv) InheritFromEnv(base *EvalEnv) {
 	}
 }
 
+// Like InheritFromEnv, but each namespace of the base is shallow copied instead
+// of been shared. Writing into any namespace of the new env, ie local, global
+// or the task result, will never be observed by the base or any sibling env
+func (e *EvalEnv) InheritFromEnvIsolated(base *EvalEnv) {
+	for k, v := range base.data {
+		if _, ok := e.data[k]; ok {
+			continue
+		}
+		if field, ok := v.(fieldMap); ok {
+			cp := make(fieldMap, len(field))
+			for kk, vv := range field {
+				cp[kk] = vv
+			}
+			e.data[k] = cp
+		} else {
+			e.data[k] = v
+		}
+	}
+}
+
+// Merge the modification of the env back into dst. The snapshot must be taken
+// by Snapshot right after the env is created, and only the keys of namespace
+// whose value differs from the snapshot are merged, so the modification done
+// to dst by others meanwhile is kept. Namespace listed in skip is not merged
+func (e *EvalEnv) MergeInto(dst *EvalEnv, snapshot *EvalEnv, skip ...string) {
+	skipSet := make(map[string]bool)
+	for _, x := range skip {
+		skipSet[x] = true
+	}
+
+	for k, v := range e.data {
+		if skipSet[k] {
+			continue
+		}
+		field, ok := v.(fieldMap)
+		if !ok {
+			continue
+		}
+		old := snapshot.getField(k)
+		for kk, vv := range field {
+			if old != nil {
+				if oldV, ok := old[kk]; ok && isSameValue(oldV, vv) {
+					continue
+				}
+			}
+			dst.getOrCreateField(k)[kk] = vv
+		}
+	}
+}
+
+// Shallow copy of the env, used along with MergeInto
+func (e *EvalEnv) Snapshot() *EvalEnv {
+	x := &EvalEnv{
+		data: make(map[string]interface{}),
+	}
+	x.InheritFromEnvIsolated(e)
+	return x
+}
+
+// whether 2 values are the same one, reference types are compared by identity
+func isSameValue(a, b interface{}) (same bool) {
+	// struct holding uncomparable value panics
+	defer func() {
+		if recover() != nil {
+			same = false
+		}
+	}()
+
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	va := reflect.ValueOf(a)
+	vb := reflect.ValueOf(b)
+	if va.Type() != vb.Type() {
+		return false
+	}
+	switch va.Kind() {
+	case reflect.Map, reflect.Func, reflect.Pointer, reflect.Chan, reflect.UnsafePointer:
+		return va.Pointer() == vb.Pointer()
+	case reflect.Slice:
+		return va.Pointer() == vb.Pointer() && va.Len() == vb.Len()
+	default:
+		if va.Type().Comparable() {
+			return a == b
+		}
+		return false
+	}
+}
+
 func (e *EvalEnv) getField(field string) fieldMap {
 	v, ok := e.data[field]
 	if !ok {
@@ -84,22 +175,80 @@ func (e *EvalEnv) GetNamespace(field string) map[string]interface{} {
 //   2) Set the last field under namespace specified to point to xxx
 //   3) inline all key value inside of xxx under namespace specified
 //      *name collision should be handled by the caller*
+//   4) do the same under tasks.<id>, if the running task has an id, ie
+//      task.id is not empty
 
 func (e *EvalEnv) RecordHistoricalResult(
 	field string,
 	stat map[string]interface{},
 ) {
-	ns := e.GetNamespace(field)
+	recordHistoricalResult(e.GetNamespace(field), stat)
+	if ns := e.taskNamespace(); ns != nil {
+		recordHistoricalResult(ns, stat)
+	}
+	if e.observer != nil {
+		e.observer(field, stat)
+	}
+}
+
+// Set the result recorded before as the current one of the namespace, ie the
+// last field and the inlined key value, the history is left untouched. Used to
+// run the check against a result again
+func (e *EvalEnv) SetCurrentResult(
+	field string,
+	stat map[string]interface{},
+) {
+	setCurrentResult(e.GetNamespace(field), stat)
+	if ns := e.taskNamespace(); ns != nil {
+		setCurrentResult(ns, stat)
+	}
+}
+
+// Observe the results recorded by RecordHistoricalResult, nil stops observing.
+// The observer is not inherited by the snapshot of the env
+func (e *EvalEnv) ObserveResult(fn func(string, map[string]interface{})) {
+	e.observer = fn
+}
+
+// tasks of the same type do not overwrite each other under its id, nil if the
+// running task does not have an id
+func (e *EvalEnv) taskNamespace() map[string]interface{} {
+	id := e.GetDef("task", "id", NewStringVal(""))
+	if id.String() == "" {
+		return nil
+	}
+	tasks := e.GetNamespace("tasks")
+	ns, ok := tasks[id.String()].(map[string]interface{})
+	if !ok {
+		ns = make(map[string]interface{})
+		tasks[id.String()] = ns
+	}
+	return ns
+}
+
+func recordHistoricalResult(
+	ns map[string]interface{},
+	stat map[string]interface{},
+) {
 	if old, ok := ns["history"]; ok {
 		oldV, ok := old.([]map[string]interface{})
 		if !ok {
 			panic("invalid runtime history interface")
 		}
-		oldV = append(oldV, stat)
-		ns["history"] = oldV
+		// always copy, the old history may be shared with a forked env
+		newV := make([]map[string]interface{}, len(oldV), len(oldV)+1)
+		copy(newV, oldV)
+		ns["history"] = append(newV, stat)
 	} else {
 		ns["history"] = []map[string]interface{}{stat}
 	}
+	setCurrentResult(ns, stat)
+}
+
+func setCurrentResult(
+	ns map[string]interface{},
+	stat map[string]interface{},
+) {
 	ns["last"] = stat
 	for k, v := range stat {
 		ns[k] = v
@@ -160,6 +309,19 @@ func (e *EvalEnv) GetDef(field, key string, def Val) Val {
 	}
 }
 
+// Proxy used by the http requests, target.proxy takes priority over the job's
+// proxy, ie info.proxy. Empty means no proxy
+func (e *EvalEnv) Proxy() string {
+	for _, field := range []string{"target", "info"} {
+		if v, ok := e.Get(field, "proxy"); ok && v.IsString() {
+			if proxy := v.String(); proxy != "" {
+				return proxy
+			}
+		}
+	}
+	return ""
+}
+
 func (e *EvalEnv) Del(field, key string) {
 	f := e.getField(field)
 	if f != nil {
@@ -326,9 +488,26 @@ func addBaseLibraryHttp(env *EvalEnv) {
 			url string,
 			expectStatus int,
 		) map[string]interface{} {
-			resp, err := http.Get(url)
 			out := make(map[string]interface{})
 
+			// routed via the proxy of the target or the job if any
+			client := http.DefaultClient
+			if proxy, err := util.ParseProxy(env.Proxy()); err != nil {
+				out["resp_err"] = fmt.Sprintf("%s", err)
+				out["resp_ok"] = false
+				return out
+			} else if proxy != nil {
+				transport := &http.Transport{
+					Proxy: http.ProxyURL(proxy),
+				}
+				defer transport.CloseIdleConnections()
+				client = &http.Client{
+					Transport: transport,
+				}
+			}
+
+			resp, err := client.Get(url)
+
 			if err != nil {
 				out["resp_err"] = fmt.Sprintf("%s", err)
 				out["resp_ok"] = false
@@ -0,0 +1,87 @@
+package exec
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// Values captured by the job for the test to assert one by one, ie
+//
+//   - testcapture.Set('forward.status', tasks.forward.resp_status)
+//
+// The numbers of the task results are float64, since the results are recorded
+// via json
+type testCapture struct {
+	sync.Mutex
+	value map[string]interface{}
+}
+
+// *Executor -> *testCapture, the extension is created for each env
+var testCaptured sync.Map
+
+type testCaptureFactory struct{}
+
+func (t *testCaptureFactory) Create(e *Executor) Extension {
+	v, _ := testCaptured.LoadOrStore(e, &testCapture{
+		value: make(map[string]interface{}),
+	})
+	c := v.(*testCapture)
+
+	lib := make(map[string]interface{})
+	lib["Set"] = func(key string, v interface{}) bool {
+		c.Lock()
+		defer c.Unlock()
+		c.value[key] = v
+		return true
+	}
+	return Extension{
+		Name:    "testcapture",
+		Inline:  true,
+		Library: lib,
+	}
+}
+
+func (t *testCaptureFactory) Description() string {
+	return "testcapture"
+}
+
+func init() {
+	AddExtension("testcapture", &testCaptureFactory{})
+}
+
+// compare the values captured by the job of the executor with the expected, a
+// nil value means the key must not be captured, ie the task is skipped
+func expectCaptured(t *testing.T, e *Executor, want map[string]interface{}) {
+	t.Helper()
+
+	got := map[string]interface{}{}
+	if v, ok := testCaptured.Load(e); ok {
+		c := v.(*testCapture)
+		c.Lock()
+		for k, v := range c.value {
+			got[k] = v
+		}
+		c.Unlock()
+	}
+
+	key := []string{}
+	for k := range want {
+		key = append(key, k)
+	}
+	sort.Strings(key)
+
+	for _, k := range key {
+		v, ok := got[k]
+		if want[k] == nil {
+			if ok {
+				t.Errorf("%s: got %#v, want nothing", k, v)
+			}
+		} else if !ok {
+			t.Errorf("%s: got nothing, want %#v", k, want[k])
+		} else if !reflect.DeepEqual(v, want[k]) {
+			t.Errorf("%s: got %#v, want %#v", k, v, want[k])
+		}
+	}
+}
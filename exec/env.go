@@ -2,6 +2,10 @@ package exec
 
 import (
 	"github.com/dianpeng/hi-doctor/dvar"
+	"github.com/dianpeng/hi-doctor/util"
+
+	"fmt"
+	"time"
 )
 
 // Extension, for any user that is outside of the builtin. It is allowed to
@@ -65,17 +69,19 @@ func addBaseLibraryLog(e *Executor, env *dvar.EvalEnv) {
 	}
 }
 
+// The var library is bound to the env it lives in, so each env, especially the
+// ones used by the batches running in parallel, only modifies itself
 func addBaseLibraryVar(e *Executor, env *dvar.EvalEnv) {
 	lib := env.GetNamespace("var")
 	{
 		lib["SetLocal"] = func(name string, v interface{}) bool {
 			vv := dvar.NewInterfaceVal(v) // never crash
-			e.curEnv().Set("local", name, vv)
+			env.Set("local", name, vv)
 			return true
 		}
 		lib["SetGlobal"] = func(name string, v interface{}) bool {
 			vv := dvar.NewInterfaceVal(v) // never crash
-			e.curEnv().Set("global", name, vv)
+			e.setGlobal(env, name, vv)
 			return true
 		}
 	}
@@ -174,11 +180,17 @@ func addTriggerLibrary(e *Executor, env *dvar.EvalEnv) {
 const (
 	schedulerTypeSeq = iota
 	schedulerTypeParallel
+	schedulerTypeRateLimit
+	schedulerTypeSpread
 )
 
 type schedulerOpt struct {
 	ty       int
 	maxCount int
+	qps      float64
+	burst    int
+	spread   time.Duration
+	err      error // invalid argument, reported when the scheduler is created
 }
 
 func addSchedulerLibrary(env *dvar.EvalEnv) {
@@ -195,12 +207,56 @@ func addSchedulerLibrary(env *dvar.EvalEnv) {
 			maxCount: max,
 		}
 	}
+	// the paced schedulers take an optional # of batches running at the same
+	// time, default to pacedSchedulerDefaultParallel
+	field["RateLimit"] = func(qps interface{}, burst int, max ...int) *schedulerOpt {
+		opt := &schedulerOpt{
+			ty:       schedulerTypeRateLimit,
+			maxCount: schedulerParallel(max),
+			burst:    burst,
+		}
+		if v, ok := util.ToReal(qps, false); ok {
+			opt.qps = v
+		} else {
+			opt.err = fmt.Errorf("scheduler.RateLimit, invalid qps %v", qps)
+		}
+		return opt
+	}
+
+	// duration is either a duration string, ie "5m", or # of seconds
+	field["Spread"] = func(d interface{}, max ...int) *schedulerOpt {
+		spread, err := toSchedulerDuration(d)
+		return &schedulerOpt{
+			ty:       schedulerTypeSpread,
+			maxCount: schedulerParallel(max),
+			spread:   spread,
+			err:      err,
+		}
+	}
+}
+
+func schedulerParallel(max []int) int {
+	if len(max) == 0 {
+		return 0
+	}
+	return max[0]
+}
+
+func toSchedulerDuration(d interface{}) (time.Duration, error) {
+	if str, ok := d.(string); ok {
+		return time.ParseDuration(str)
+	}
+	if sec, ok := util.ToReal(d, false); ok {
+		return time.Duration(sec * float64(time.Second)), nil
+	}
+	return 0, fmt.Errorf("scheduler.Spread, invalid duration %v", d)
 }
 
 // add those extra information into the map
 func addInfo(e *Executor, env *dvar.EvalEnv) {
 	info := env.GetNamespace("info")
 	info["origin"] = e.p.Info.Origin
+	info["proxy"] = e.proxy
 }
 
 func addBaseLibrary(e *Executor, env *dvar.EvalEnv) {
@@ -224,9 +280,17 @@ func newEvalEnv(e *Executor) *dvar.EvalEnv {
 	return x
 }
 
+// env for each task batch. The batch owns a private copy of every namespace of
+// the base, including a snapshot of the global, so batches running in parallel
+// never touch the same map. The var library is rebound to the new env
 func newEvalEnvFromBase(e *Executor, base *dvar.EvalEnv) *dvar.EvalEnv {
 	x := dvar.NewEvalEnv()
-	x.InheritFromEnv(base)
+	addBaseLibraryVar(e, x)
+
+	// the base's global may be written by other batches concurrently
+	e.globalMutex.Lock()
+	defer e.globalMutex.Unlock()
+	x.InheritFromEnvIsolated(base)
 	return x
 }
 
@@ -4,9 +4,12 @@ import (
 	"github.com/dianpeng/hi-doctor/dvar"
 	"github.com/dianpeng/hi-doctor/plan"
 	"github.com/dianpeng/hi-doctor/storage"
+	"github.com/dianpeng/hi-doctor/task"
 	"github.com/dianpeng/hi-doctor/trace"
 	"github.com/dianpeng/hi-doctor/trigger"
+	"github.com/dianpeng/hi-doctor/util"
 
+	"context"
 	"encoding/json"
 	"fmt"
 	"sync"
@@ -50,9 +53,28 @@ type Executor struct {
 	p        *plan.Plan
 	assets   dvar.ValMap
 	storage  map[string]storage.Storage
-	curE     []*dvar.EvalEnv
 	runMutex sync.Mutex
 
+	// env of the active phase, the global variable stored inside of it is
+	// shared by all the batches and must be accessed with the globalMutex
+	activeEnv   *dvar.EvalEnv
+	globalMutex sync.Mutex
+
+	// summary of the current run, updated by the scheduler
+	summary *runSummary
+
+	// proxy of the current run, empty means no proxy
+	proxy string
+
+	// resources shared by the tasks across the runs, ie connection pools,
+	// released when the plan is stopped or the executor is done
+	shared *task.Shared
+
+	// closed once the executor will not run anymore, ie it fails to start or
+	// the one shot trigger is done
+	done     chan struct{}
+	doneOnce sync.Once
+
 	// Opaque structure for any extension to be used
 	Blackboard map[string]interface{}
 	Log        trace.Trace
@@ -63,24 +85,22 @@ func (e *Executor) Plan() *plan.Plan {
 }
 
 // ----------------------------------------------------------------------------
-// during the lifetime, we need to modify the eval environment multiple times
-func (e *Executor) curEnv() *dvar.EvalEnv {
-	return e.curE[len(e.curE)-1]
-}
-
-func (e *Executor) pushCurEnv(env *dvar.EvalEnv) {
-	e.curE = append(e.curE, env)
-}
-
-func (e *Executor) popCurEnv() {
-	e.curE = e.curE[0 : len(e.curE)-1]
+// set the global variable. A batch only owns a snapshot of the global, so the
+// write goes to the env itself and also the active env, then it is visible to
+// the batches started afterwards and the finally block
+func (e *Executor) setGlobal(env *dvar.EvalEnv, name string, v dvar.Val) {
+	e.globalMutex.Lock()
+	defer e.globalMutex.Unlock()
+
+	env.Set("global", name, v)
+	if e.activeEnv != nil && e.activeEnv != env {
+		e.activeEnv.Set("global", name, v)
+	}
 }
 
 // ----------------------------------------------------------------------------
 func (e *Executor) runGuard() (bool, error) {
 	env := newEvalEnvForGuard(e)
-	e.pushCurEnv(env)
-	defer e.popCurEnv()
 
 	out, err := e.p.Guard.Value(env)
 	if err != nil {
@@ -203,6 +223,7 @@ func (e *Executor) setupTrigger(env *dvar.EvalEnv) error {
 	case triggerTypeNow:
 		if err := trigger.Now(func() {
 			e.runActive()
+			e.finish()
 		}); err != nil {
 			return fmt.Errorf("executor.trigger invalid now: %s", err)
 		} else {
@@ -219,8 +240,6 @@ func (e *Executor) setupTrigger(env *dvar.EvalEnv) error {
 
 func (e *Executor) runTrigger() error {
 	env := newEvalEnvForTrigger(e)
-	e.pushCurEnv(env)
-	defer e.popCurEnv()
 
 	// (0) run the storage before running the trigger
 	if err := e.runStorage(env); err != nil {
@@ -256,16 +275,34 @@ func (e *Executor) runGlobal(env *dvar.EvalEnv) error {
 	return e.runVarMap("global", e.p.Global, env)
 }
 
+// the job's proxy, exposed as info.proxy to the env created afterwards, ie the
+// target and the batches
+func (e *Executor) runProxy(env *dvar.EvalEnv) error {
+	vv, err := e.p.Proxy.Value(env)
+	if err != nil {
+		return fmt.Errorf("executor.proxy execution failed: %s", err)
+	}
+	proxy := vv.String()
+	if _, err := util.ParseProxy(proxy); err != nil {
+		return fmt.Errorf("executor.proxy invalid: %s", err)
+	}
+	e.proxy = proxy
+	env.Set("info", "proxy", dvar.NewStringVal(proxy))
+	return nil
+}
+
 func (e *Executor) populateTaskList(
 	env *dvar.EvalEnv,
 	insTarget InspectionTarget,
 ) (ScheduleItemList, error) {
 	tlist := ScheduleItemList{}
 	for _, v := range insTarget {
-		batch := ScheduleBatch{}
+		batch := ScheduleBatch{
+			Graph: e.p.TaskGraph,
+		}
 
 		v.SetupEnv(env)
-		for _, pi := range e.p.TaskPlannerList {
+		for idx, pi := range e.p.TaskPlannerList {
 			p := pi.Planner
 
 			// evaluate the guard
@@ -289,9 +326,16 @@ func (e *Executor) populateTaskList(
 			}
 
 			batch.Batch = append(batch.Batch, ScheduleItem{
+				Index:      idx,
+				Id:         pi.Id,
 				Guard:      e.p.Guard,
 				TargetItem: v,
 				Task:       t,
+				Retry:      pi.Retry,
+				Timeout:    pi.Timeout,
+				OnError:    pi.OnError,
+				DependsOn:  pi.DependsOn,
+				RunIf:      pi.RunIf,
 			})
 		}
 		v.DelEnv(env)
@@ -330,7 +374,10 @@ func (e *Executor) runTargetInspectionOnRawData(
 	return e.populateTaskList(env, insTarget)
 }
 
-func (e *Executor) runTargetFetch(baseEnv *dvar.EvalEnv) (ScheduleItemList, error) {
+func (e *Executor) runTargetFetch(
+	ctx context.Context,
+	baseEnv *dvar.EvalEnv,
+) (ScheduleItemList, error) {
 	env := newEvalEnvForTarget(e)
 	env.InheritFromEnv(baseEnv)
 
@@ -339,7 +386,7 @@ func (e *Executor) runTargetFetch(baseEnv *dvar.EvalEnv) (ScheduleItemList, erro
 		return nil, fmt.Errorf("executor.target create fetcher failed: %s", err)
 	}
 
-	data, err := fetcher.Obtain()
+	data, err := fetcher.Obtain(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("executor.target fetch obtain failed: %s", err)
 	}
@@ -382,9 +429,12 @@ func (e *Executor) runTargetCount(baseEnv *dvar.EvalEnv) (ScheduleItemList, erro
 	return e.populateTaskList(env, insTarget)
 }
 
-func (e *Executor) runTarget(baseEnv *dvar.EvalEnv) (ScheduleItemList, error) {
+func (e *Executor) runTarget(
+	ctx context.Context,
+	baseEnv *dvar.EvalEnv,
+) (ScheduleItemList, error) {
 	if e.p.Target.Fetch != nil {
-		return e.runTargetFetch(baseEnv)
+		return e.runTargetFetch(ctx, baseEnv)
 	}
 	if e.p.Target.Inline != nil {
 		return e.runTargetInline(baseEnv)
@@ -404,9 +454,22 @@ func (e *Executor) runScheduler(env *dvar.EvalEnv) (Scheduler, error) {
 	} else {
 		if dv.IsAny() {
 			if vv, ok := dv.GetAny().(*schedulerOpt); ok {
+				if vv.err != nil {
+					return nil, fmt.Errorf("executor.scheduler invalid: %s", vv.err)
+				}
 				switch vv.ty {
 				case schedulerTypeParallel:
 					return newParScheduler(vv.maxCount), nil
+				case schedulerTypeRateLimit:
+					if vv.qps <= 0 {
+						return nil, fmt.Errorf("executor.scheduler invalid rate limit qps: %v", vv.qps)
+					}
+					return newRateLimitScheduler(vv.qps, vv.burst, vv.maxCount), nil
+				case schedulerTypeSpread:
+					if vv.spread < 0 {
+						return nil, fmt.Errorf("executor.scheduler invalid spread: %s", vv.spread)
+					}
+					return newSpreadScheduler(vv.spread, vv.maxCount), nil
 				default:
 					return newSeqScheduler(), nil
 				}
@@ -435,12 +498,16 @@ func (e *Executor) OnAfterTaskBatch(env *dvar.EvalEnv) error {
 	return nil
 }
 
-func (e *Executor) doRunActive() error {
+func (e *Executor) doRunActive(ctx context.Context) error {
+	ctx = task.WithShared(ctx, e.shared)
+
 	env := newEvalEnvForActive(e)
 	env.InheritInNamespace("assets", e.assets)
 
-	e.pushCurEnv(env)
-	defer e.popCurEnv()
+	e.activeEnv = env
+	defer func() {
+		e.activeEnv = nil
+	}()
 
 	// 0) define all the storage
 	if err := e.defineStorage(env); err != nil {
@@ -452,24 +519,47 @@ func (e *Executor) doRunActive() error {
 		return err
 	}
 
-	// 2) select scheduler
+	// 2) setup the proxy, shared by the target fetch and the tasks
+	if err := e.runProxy(env); err != nil {
+		return err
+	}
+
+	// 3) select scheduler
 	scheduler, err := e.runScheduler(env)
 	if err != nil {
 		return err
 	}
 
-	// 3) run the target and generate probing target
-	tlist, err := e.runTarget(env)
+	// 4) run the target and generate probing target
+	tlist, err := e.runTarget(ctx, env)
 	if err != nil {
 		return err
 	}
 
-	// 4) run the probing task
-	if err := scheduler.Run(e, tlist, env, e); err != nil {
+	// 5) run the probing task, failures which do not abort the run are
+	// collected into the summary, exposed to the finally block
+	e.summary = newRunSummary()
+	err = scheduler.Run(ctx, e, tlist, env, e)
+
+	summary := e.summary.Summary()
+	e.p.ExecuteInfo.LastSummary = summary
+	for k, v := range util.ToMapInterface(summary) {
+		env.Set("summary", k, dvar.NewInterfaceVal(v))
+	}
+	if err != nil {
 		return err
 	}
+	if summary.FailureCount > 0 {
+		e.Log.Warn(
+			"%d task(s) failed without aborting the job, batch: %d, task: %d, skipped: %d",
+			summary.FailureCount,
+			summary.BatchCount,
+			summary.TaskCount,
+			summary.SkippedTask,
+		)
+	}
 
-	// 5) run the finally code block
+	// 6) run the finally code block
 	if err := e.runFinally(env); err != nil {
 		return err
 	}
@@ -482,10 +572,23 @@ func (e *Executor) runActive() {
 	e.runMutex.Lock()
 	defer e.runMutex.Unlock()
 
+	// the plan may have been stopped while the trigger is firing
+	if e.p.Context().Err() != nil {
+		e.Log.Info("trigger fired, but job is stopped")
+		return
+	}
+
 	e.Log.Info("trigger fired, job start to execute")
 
+	ctx := e.p.Context()
+	if e.p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.p.Timeout)
+		defer cancel()
+	}
+
 	start := time.Now()
-	err := e.doRunActive()
+	err := e.doRunActive(ctx)
 	done := time.Now()
 
 	e.p.ExecuteInfo.SetLastDuration(done.Sub(start))
@@ -515,14 +618,36 @@ func (e *Executor) TracePrefix() string {
 	)
 }
 
+func (e *Executor) finish() {
+	e.doneOnce.Do(func() {
+		close(e.done)
+	})
+}
+
 func (e *Executor) Start() error {
+	go func() {
+		select {
+		case <-e.p.Context().Done():
+			break
+		case <-e.done:
+			break
+		}
+		e.shared.Release()
+	}()
+
 	if toggle, err := e.runGuard(); err != nil {
+		e.finish()
 		return err
 	} else if !toggle {
+		e.finish()
 		return nil // shortcut
 	}
 
-	return e.runTrigger()
+	if err := e.runTrigger(); err != nil {
+		e.finish()
+		return err
+	}
+	return nil
 }
 
 func NewExecutor(assets dvar.ValMap, p *plan.Plan) *Executor {
@@ -530,6 +655,8 @@ func NewExecutor(assets dvar.ValMap, p *plan.Plan) *Executor {
 		p:          p,
 		assets:     assets,
 		storage:    make(map[string]storage.Storage),
+		shared:     task.NewShared(),
+		done:       make(chan struct{}),
 		Blackboard: make(map[string]interface{}),
 	}
 	exec.Log = trace.NewTrace(exec)
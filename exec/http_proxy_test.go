@@ -0,0 +1,107 @@
+package exec
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// the job's proxy is used by the target fetch, the tasks and http.Get, unless
+// overridden
+const httpProxyJob = `
+name: http_proxy
+
+proxy: http://127.0.0.1:%[1]s
+
+target:
+  format: json_v1
+  fetch:
+    uri: http://127.0.0.1:%[2]s/targets
+
+task:
+  - type: http
+    id: forward
+    option:
+      method: GET
+      path: /hello
+  - type: http
+    id: direct
+    option:
+      method: GET
+      path: /hello
+      proxy: direct
+  - type: code
+    option:
+      code_block:
+        - testcapture.Set('forward.body', tasks.forward.resp_body)
+        - testcapture.Set('forward.via', tasks.forward.resp_header['X-Via-Proxy'][0])
+        - testcapture.Set('forward.req_proxy', tasks.forward.req_proxy)
+        - testcapture.Set('direct.body', tasks.direct.resp_body)
+        - testcapture.Set('direct.proxied', tasks.direct.resp_header['X-Via-Proxy'] != nil)
+        - testcapture.Set('direct.req_proxy', tasks.direct.req_proxy)
+        - testcapture.Set('get.via', http.HeaderGet(http.Get('http://127.0.0.1:%[2]s/hello', 200).resp_header, 'X-Via-Proxy'))
+
+trigger: trigger.Now()
+`
+
+// a forward proxy, the request is marked with the Via header
+func testForwardProxy(w http.ResponseWriter, r *http.Request) {
+	req, _ := http.NewRequest(r.Method, r.URL.String(), r.Body)
+	req.Header = r.Header.Clone()
+	req.Header.Set("Via", "1.1 test-proxy")
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("X-Via-Proxy", "1")
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+func TestHttpProxy(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(testForwardProxy))
+	defer proxy.Close()
+	_, proxyPort, _ := net.SplitHostPort(proxy.Listener.Addr().String())
+
+	var origin *httptest.Server
+	origin = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/targets":
+			// the target list is only available via the proxy
+			if r.Header.Get("Via") == "" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			_, port, _ := net.SplitHostPort(origin.Listener.Addr().String())
+			fmt.Fprintf(w, `[{"name": "plain", "ip": "127.0.0.1", "port": %s}]`, port)
+		default:
+			w.Write([]byte("hello"))
+		}
+	}))
+	defer origin.Close()
+	_, originPort, _ := net.SplitHostPort(origin.Listener.Addr().String())
+
+	e := newTestExecutor(t, fmt.Sprintf(httpProxyJob, proxyPort, originPort))
+	if err := e.doRunActive(context.Background()); err != nil {
+		t.Fatalf("run failed: %s", err)
+	}
+
+	expectCaptured(t, e, map[string]interface{}{
+		"forward.body":      "hello",
+		"forward.via":       "1",
+		"forward.req_proxy": "http://127.0.0.1:" + proxyPort,
+		"direct.body":       "hello",
+		"direct.proxied":    false,
+		"direct.req_proxy":  "",
+		"get.via":           "1",
+	})
+}
@@ -0,0 +1,65 @@
+package exec
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// the transport is shared by the tasks of the job, so the 2nd request reuses
+// the connection of the 1st one
+const httpTransportJob = `
+name: http_transport
+
+target:
+  format: json_v1
+  inline:
+    - name: plain
+      ip: 127.0.0.1
+      port: %s
+
+task:
+  - type: http
+    id: cold
+    option:
+      method: GET
+      path: /
+  - type: http
+    id: warm
+    option:
+      method: GET
+      path: /
+  - type: code
+    option:
+      code_block:
+        - testcapture.Set('cold.ok', tasks.cold.resp_ok)
+        - testcapture.Set('cold.reused', tasks.cold.resp_conn_reused)
+        - testcapture.Set('warm.ok', tasks.warm.resp_ok)
+        - testcapture.Set('warm.reused', tasks.warm.resp_conn_reused)
+
+trigger: trigger.Now()
+`
+
+func TestHttpTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	e := newTestExecutor(t, fmt.Sprintf(httpTransportJob, port))
+	defer e.shared.Release()
+	if err := e.doRunActive(context.Background()); err != nil {
+		t.Fatalf("run failed: %s", err)
+	}
+
+	expectCaptured(t, e, map[string]interface{}{
+		"cold.ok":     true,
+		"cold.reused": false,
+		"warm.ok":     true,
+		"warm.reused": true,
+	})
+}
@@ -0,0 +1,96 @@
+package exec
+
+import (
+	"github.com/dianpeng/hi-doctor/storage"
+
+	"context"
+	"fmt"
+	"testing"
+)
+
+// 3 targets, each runs 2 code tasks and the target "bad" fails the first one
+const onErrorJob = `
+name: on_error
+on_error: %s
+
+storage:
+  after: storage.Int(0)
+
+target:
+  format: json_v1
+  inline:
+    - name: good0
+    - name: bad
+      bad: true
+    - name: good1
+
+task:
+  - type: code
+    on_error: %s
+    option:
+      code_block:
+        - "target.bad == true ? Fail() : true"
+  - type: code
+    option:
+      code_block:
+        - storage.after.CheckedAdd(1, 100)
+        - testcapture.Set(target.name + '.error', task.error != '')
+
+trigger: trigger.Now()
+`
+
+func TestOnError(t *testing.T) {
+	for _, x := range []struct {
+		job     string
+		task    string
+		fail    bool
+		count   int64
+		skipped int64
+		after   int64 // # of runs of the 2nd task
+	}{
+		{"abort", "", true, 3, 0, 1},
+		{"skip_task", "", false, 6, 0, 3},
+		{"skip_batch", "", false, 5, 1, 2},
+		{"continue", "", false, 6, 0, 3},
+
+		// task level policy overrides the job's
+		{"abort", "continue", false, 6, 0, 3},
+	} {
+		name := x.job + "/" + x.task
+		e := newTestExecutor(t, fmt.Sprintf(onErrorJob, x.job, x.task))
+		err := e.doRunActive(context.Background())
+		if got := err != nil; got != x.fail {
+			t.Errorf("%s, run failed: got %t, want %t, error: %v", name, got, x.fail, err)
+		}
+
+		s := e.p.ExecuteInfo.LastSummary
+		if s.TaskCount != x.count {
+			t.Errorf("%s, summary task_count: got %d, want %d", name, s.TaskCount, x.count)
+		}
+		if s.SkippedTask != x.skipped {
+			t.Errorf("%s, summary skipped_task: got %d, want %d", name, s.SkippedTask, x.skipped)
+		}
+		if s.FailureCount != 1 {
+			t.Fatalf("%s, summary failure_count: got %d, want 1", name, s.FailureCount)
+		}
+		if s.Failure[0].Target != "bad" {
+			t.Errorf("%s, failure target: got %q, want %q", name, s.Failure[0].Target, "bad")
+		}
+		if s.Failure[0].BatchIndex != 1 {
+			t.Errorf("%s, failure batch_index: got %d, want 1", name, s.Failure[0].BatchIndex)
+		}
+
+		if got := e.storage["after"].(storage.Primitive).Get(); got != x.after {
+			t.Errorf("%s, runs of the 2nd task: got %v, want %d", name, got, x.after)
+		}
+
+		// task.error is visible to the task after the failed one
+		if x.after == 3 {
+			expectCaptured(t, e, map[string]interface{}{
+				"bad.error":   true,
+				"good0.error": false,
+				"good1.error": false,
+			})
+		}
+	}
+}
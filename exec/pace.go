@@ -0,0 +1,76 @@
+package exec
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Pacing of the scheduler. Hammering thousands of targets in the same second
+// skews their metrics and may trip their own rate limiter, so the scheduler is
+// allowed to either cap the # of task starts per second or spread the batches
+// evenly over a time window
+
+// rate limiter, implemented as GCRA which is equivalent to a token bucket that
+// is full at the beginning. Each task start consumes one token
+type rateLimiter struct {
+	sync.Mutex
+	interval  time.Duration // time to generate one token, ie 1/qps
+	tolerance time.Duration // (burst-1) * interval
+	tat       time.Time     // theoretical arrival time of the next start
+}
+
+func newRateLimiter(qps float64, burst int) *rateLimiter {
+	if burst <= 0 {
+		burst = 1
+	}
+	interval := time.Duration(float64(time.Second) / qps)
+	return &rateLimiter{
+		interval:  interval,
+		tolerance: time.Duration(burst-1) * interval,
+	}
+}
+
+// reserve a start, returns how long the caller needs to wait
+func (r *rateLimiter) reserve(now time.Time) time.Duration {
+	r.Lock()
+	defer r.Unlock()
+
+	start := r.tat.Add(-r.tolerance)
+	if start.Before(now) {
+		start = now
+	}
+	if r.tat.Before(start) {
+		r.tat = start
+	}
+	r.tat = r.tat.Add(r.interval)
+	return start.Sub(now)
+}
+
+func (r *rateLimiter) Wait(ctx context.Context) error {
+	return sleepContext(ctx, r.reserve(time.Now()))
+}
+
+// offset of the batch idx when the total batches are spread evenly over the
+// window
+func spreadOffset(window time.Duration, idx, total int) time.Duration {
+	if total <= 0 {
+		return 0
+	}
+	return time.Duration(int64(window) / int64(total) * int64(idx))
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
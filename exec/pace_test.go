@@ -0,0 +1,114 @@
+package exec
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterReserve(t *testing.T) {
+	r := newRateLimiter(10, 3)
+	now := time.Now()
+
+	// the burst is available immediately, then one start per 100ms
+	expect := []time.Duration{0, 0, 0, 100, 200, 300}
+	for i, x := range expect {
+		if d := r.reserve(now); d != x*time.Millisecond {
+			t.Fatalf("start %d should wait %dms, but got %s", i, x, d)
+		}
+	}
+
+	// tokens are refilled after idle
+	if d := r.reserve(now.Add(time.Second)); d != 0 {
+		t.Fatalf("start after idle should not wait, but got %s", d)
+	}
+}
+
+func TestSpreadOffset(t *testing.T) {
+	for i, x := range []time.Duration{0, 250, 500, 750} {
+		if d := spreadOffset(time.Second, i, 4); d != x*time.Millisecond {
+			t.Fatalf("batch %d should start at %dms, but got %s", i, x, d)
+		}
+	}
+}
+
+const pacedSchedulerJob = `
+name: paced_scheduler
+target:
+  format: json_v1
+  count: %d
+scheduler: %s
+trigger: trigger.Now()
+task:
+  - type: code
+    option:
+      code_block:
+        - var.SetLocal('me', task.batch_index)
+`
+
+func TestPacedScheduler(t *testing.T) {
+	for _, x := range []struct {
+		scheduler string
+		atLeast   time.Duration
+	}{
+		{"scheduler.RateLimit(20, 2)", 400 * time.Millisecond},
+		{"scheduler.Spread('500ms')", 400 * time.Millisecond},
+		{"scheduler.Spread(0.5)", 400 * time.Millisecond},
+	} {
+		e := newTestExecutor(t, fmt.Sprintf(pacedSchedulerJob, 10, x.scheduler))
+		start := time.Now()
+		if err := e.doRunActive(context.Background()); err != nil {
+			t.Fatalf("%s run failed: %s", x.scheduler, err)
+		}
+		if d := time.Since(start); d < x.atLeast {
+			t.Fatalf("%s should take at least %s, but got %s", x.scheduler, x.atLeast, d)
+		}
+	}
+
+	for _, x := range []string{"scheduler.RateLimit(0, 1)", "scheduler.Spread('xx')"} {
+		e := newTestExecutor(t, fmt.Sprintf(pacedSchedulerJob, 1, x))
+		if err := e.doRunActive(context.Background()); err == nil {
+			t.Fatalf("%s should fail", x)
+		}
+	}
+}
+
+func TestPacedSchedulerAbort(t *testing.T) {
+	e := newTestExecutor(t, fmt.Sprintf(pacedSchedulerJob, 10, "scheduler.Spread('10s')"))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := e.doRunActive(ctx); err == nil {
+		t.Fatalf("aborted job should fail")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("aborted job should return promptly, but took %s", d)
+	}
+}
+
+func TestPacedSchedulerParallel(t *testing.T) {
+	for _, x := range []struct {
+		scheduler string
+		want      int
+	}{
+		{"scheduler.RateLimit(20, 2)", pacedSchedulerDefaultParallel},
+		{"scheduler.RateLimit(20, 2, 3)", 3},
+		{"scheduler.Spread('1m')", pacedSchedulerDefaultParallel},
+		{"scheduler.Spread('1m', 5)", 5},
+	} {
+		e := newTestExecutor(t, fmt.Sprintf(pacedSchedulerJob, 1000, x.scheduler))
+		s, err := e.runScheduler(newEvalEnvForActive(e))
+		if err != nil {
+			t.Fatalf("%s, create scheduler failed: %s", x.scheduler, err)
+		}
+		p, ok := s.(*parScheduler)
+		if !ok {
+			t.Fatalf("%s, scheduler: got %T, want *parScheduler", x.scheduler, s)
+		}
+		if got := p.getWorkerSize(make(ScheduleItemList, 1000)); got != x.want {
+			t.Errorf("%s, # of workers: got %d, want %d", x.scheduler, got, x.want)
+		}
+	}
+}
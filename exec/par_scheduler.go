@@ -1,33 +1,48 @@
 package exec
 
 import (
+	"context"
 	"fmt"
 	"github.com/alitto/pond"
 	"github.com/dianpeng/hi-doctor/dvar"
+	"time"
 )
 
+// # of batches running at the same time of the paced schedulers, unless
+// specified otherwise
+const pacedSchedulerDefaultParallel = 64
+
 type parScheduler struct {
-	maxJob int
+	maxJob int // 0 means no limit, ie one worker per batch
+
+	// optional pacing
+	limiter *rateLimiter  // cap of task starts per second across all batches
+	spread  time.Duration // spread the batch starts evenly over the window
 }
 
 func (p *parScheduler) getPipelineSize(x ScheduleItemList) int {
 	return len(x)
 }
 
-func (p *parScheduler) getBatchTaskSize(x ScheduleBatch) int {
-	cnt := 0
-	for _, x0 := range x.Batch {
-		cnt += len(x0.Task)
+func (p *parScheduler) getWorkerSize(x ScheduleItemList) int {
+	if p.maxJob > 0 {
+		return p.maxJob
 	}
-	return cnt
+	if len(x) == 0 {
+		return 1
+	}
+	return len(x)
 }
 
 type parCtx struct {
-	err   error
-	batch ScheduleBatch
+	err      error
+	batch    ScheduleBatch
+	batchIdx int64
+	taskIdx  int64 // index of the first task inside of the batch
 }
 
 func (p *parScheduler) Run(
+	ctx context.Context,
 	e *Executor,
 	x ScheduleItemList,
 	base *dvar.EvalEnv,
@@ -37,7 +52,7 @@ func (p *parScheduler) Run(
 	var batchIdx int64 = 0
 	ctxList := []*parCtx{}
 
-	pool := pond.New(p.maxJob, p.getPipelineSize(x))
+	pool := pond.New(p.getWorkerSize(x), p.getPipelineSize(x))
 	defer func() {
 		pool.StopAndWait()
 		if e := recover(); e != nil {
@@ -46,71 +61,78 @@ func (p *parScheduler) Run(
 			for _, y := range ctxList {
 				if y.err != nil {
 					outE = y.err // just pick the first one which has an error
+					break
 				}
 			}
 		}
 	}()
 
-	for _, batch := range x {
-		ctx := &parCtx{
-			err:   nil,
-			batch: batch,
+	start := time.Now()
+
+	for i, batch := range x {
+		// wait till the batch's slot when spreading, nothing is submitted
+		// afterwards if the job is aborted meanwhile
+		if p.spread > 0 {
+			if err := sleepContext(ctx, time.Until(start.Add(spreadOffset(p.spread, i, len(x))))); err != nil {
+				return err
+			}
+		}
+
+		pctx := &parCtx{
+			err:      nil,
+			batch:    batch,
+			batchIdx: batchIdx,
+			taskIdx:  taskIdx,
 		}
-		ctxList = append(ctxList, ctx)
+		ctxList = append(ctxList, pctx)
 
 		runBatch := func() {
+			// the batch may sit in the queue for a while, skip it if aborted
+			if err := ctx.Err(); err != nil {
+				pctx.err = err
+				return
+			}
+
+			// the batch only touches its own env, since it runs concurrently
+			// with the other batches
 			env := newEvalEnvFromBase(e, base)
-			e.pushCurEnv(env)
-			env.Set("task", "batch_index", dvar.NewIntVal(batchIdx))
 
 			// event handling
 			if err := l.OnBeforeTaskBatch(env); err != nil {
-				e.popCurEnv()
-				ctx.err = err
+				pctx.err = err
 				return
 			}
 
 			// the batch execution
-			for _, v := range ctx.batch.Batch {
-				target := v.TargetItem
-				taskList := v.Task
-
-				// make sure the batch run linearly
-				for _, task := range taskList {
-					env.Set("task", "task_index", dvar.NewIntVal(taskIdx))
-
-					// task execution
-					target.SetupEnv(env)
-					if err := task.Prepare(env); err != nil {
-						e.popCurEnv()
-						ctx.err = err
-						return
-					}
-					if err := task.Run(env); err != nil {
-						e.popCurEnv()
-						ctx.err = err
-						return
-					}
-					target.DelEnv(env)
-					taskIdx++
-				}
+			var beforeTask func(context.Context) error
+			if p.limiter != nil {
+				beforeTask = p.limiter.Wait
+			}
+			if err := runScheduleBatch(
+				ctx,
+				e,
+				env,
+				&pctx.batch,
+				pctx.batchIdx,
+				pctx.taskIdx,
+				beforeTask,
+			); err != nil {
+				pctx.err = err
+				return
 			}
 
 			// event handling
 			if err := l.OnAfterTaskBatch(env); err != nil {
-				e.popCurEnv()
-				ctx.err = err
+				pctx.err = err
 				return
 			}
-
-			e.popCurEnv()
 		}
 
 		// submit the task
 		pool.Submit(runBatch)
 
 		batchIdx++
-		taskIdx += int64(p.getBatchTaskSize(batch))
+		taskIdx += int64(batch.TaskSize())
 	}
 
 	return nil
@@ -124,3 +146,28 @@ func newParScheduler(x int) *parScheduler {
 		maxJob: x,
 	}
 }
+
+func pacedSchedulerParallel(x int) int {
+	if x <= 0 {
+		return pacedSchedulerDefaultParallel
+	}
+	return x
+}
+
+// at most max batches run in parallel, but the task starts across all the
+// batches are capped by qps
+func newRateLimitScheduler(qps float64, burst int, max int) *parScheduler {
+	return &parScheduler{
+		maxJob:  pacedSchedulerParallel(max),
+		limiter: newRateLimiter(qps, burst),
+	}
+}
+
+// at most max batches run in parallel, each starts at its own slot of the
+// window, or later if all the workers are busy
+func newSpreadScheduler(d time.Duration, max int) *parScheduler {
+	return &parScheduler{
+		maxJob: pacedSchedulerParallel(max),
+		spread: d,
+	}
+}
@@ -0,0 +1,174 @@
+package exec
+
+import (
+	"github.com/dianpeng/hi-doctor/dvar"
+	"github.com/dianpeng/hi-doctor/loader"
+	"github.com/dianpeng/hi-doctor/plan"
+	"github.com/dianpeng/hi-doctor/storage"
+
+	// for the code task
+	_ "github.com/dianpeng/hi-doctor/builtin"
+
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The following test is meant to be run with the race detector, ie go test
+// -race, each batch writes its local, the global and the storage concurrently
+
+const parSchedulerJob = `
+name: par_scheduler
+storage:
+  counter: storage.Int(0)
+  seen: storage.MapBoolean()
+
+global:
+  last: -1
+
+local:
+  me: -1
+
+target:
+  format: json_v1
+  count: %d
+
+scheduler: scheduler.Parallel(8)
+
+trigger: trigger.Now()
+
+task:
+  - type: code
+    option:
+      code_block:
+        - var.SetLocal('me', task.batch_index)
+        - testsync.Sleep(5)
+        - var.SetGlobal('last', task.batch_index)
+        - storage.counter.CheckedAdd(1, 1000000)
+  - type: code
+    option:
+      code_block:
+        - storage.seen.Set(Str(task.batch_index), local.me == task.batch_index && task.task_index == task.batch_index * 2 + 1)
+`
+
+// make sure the batches do overlap with each other
+type testSyncFactory struct{}
+
+func (t *testSyncFactory) Create(e *Executor) Extension {
+	lib := make(map[string]interface{})
+	lib["Sleep"] = func(ms int) bool {
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+		return true
+	}
+	lib["Fail"] = func(msg string) (bool, error) {
+		return false, fmt.Errorf("%s", msg)
+	}
+	return Extension{
+		Name:    "testsync",
+		Inline:  true,
+		Library: lib,
+	}
+}
+
+func (t *testSyncFactory) Description() string {
+	return "testsync"
+}
+
+func init() {
+	AddExtension("testsync", &testSyncFactory{})
+}
+
+func newTestExecutor(t *testing.T, job string) *Executor {
+	m, err := loader.ParseData(job)
+	if err != nil {
+		t.Fatalf("parse job failed: %s", err)
+	}
+	p, err := plan.Compile(m)
+	if err != nil {
+		t.Fatalf("compile job failed: %s", err)
+	}
+	e := NewExecutor(make(dvar.ValMap), p)
+	if err := e.runStorage(newEvalEnvForTrigger(e)); err != nil {
+		t.Fatalf("storage failed: %s", err)
+	}
+	return e
+}
+
+func TestParSchedulerRace(t *testing.T) {
+	count := 64
+	e := newTestExecutor(t, fmt.Sprintf(parSchedulerJob, count))
+
+	if err := e.doRunActive(context.Background()); err != nil {
+		t.Fatalf("run failed: %s", err)
+	}
+
+	if v := e.storage["counter"].(storage.Primitive).Get(); v != int64(count) {
+		t.Fatalf("storage.counter should be %d, but got %v", count, v)
+	}
+
+	seen := e.storage["seen"].(storage.Map)
+	for i := 0; i < count; i++ {
+		if v := seen.Get(fmt.Sprintf("%d", i)).Get(); v != true {
+			t.Fatalf("batch %d observes the environment of other batch", i)
+		}
+	}
+}
+
+func TestParSchedulerGlobal(t *testing.T) {
+	e := newTestExecutor(t, fmt.Sprintf(parSchedulerJob, 16))
+
+	env := newEvalEnvForActive(e)
+	e.activeEnv = env
+	defer func() {
+		e.activeEnv = nil
+	}()
+
+	// the global written by the batch is visible to the base afterwards,
+	// while the local is not
+	batch := newEvalEnvFromBase(e, env)
+	lib := batch.GetNamespace("var")
+	lib["SetLocal"].(func(string, interface{}) bool)("x", 1)
+	lib["SetGlobal"].(func(string, interface{}) bool)("y", 2)
+
+	if _, ok := env.Get("local", "x"); ok {
+		t.Fatalf("local of the batch leaks into the base")
+	}
+	for _, x := range []*dvar.EvalEnv{env, batch} {
+		v, ok := x.Get("global", "y")
+		if !ok {
+			t.Fatalf("global of the batch is not written through")
+		}
+		if y, _ := v.Int(); y != 2 {
+			t.Fatalf("global.y should be 2, but got %v", v.Interface())
+		}
+	}
+}
+
+// every batch fails, the later batches fail earlier
+const parSchedulerErrorJob = `
+name: par_scheduler_error
+target:
+  format: json_v1
+  count: 4
+scheduler: scheduler.Parallel(4)
+trigger: trigger.Now()
+task:
+  - type: code
+    option:
+      code_block:
+        - testsync.Sleep((4 - task.batch_index) * 20)
+        - testsync.Fail('batch ' + Str(task.batch_index))
+`
+
+func TestParSchedulerFirstError(t *testing.T) {
+	e := newTestExecutor(t, parSchedulerErrorJob)
+	err := e.doRunActive(context.Background())
+	if err == nil {
+		t.Fatalf("run: got no error, want error")
+	}
+	if !strings.Contains(err.Error(), "batch 0") {
+		t.Errorf("run error: got %q, want the one of batch 0", err)
+	}
+}
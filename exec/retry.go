@@ -0,0 +1,91 @@
+package exec
+
+import (
+	"github.com/dianpeng/hi-doctor/dvar"
+	"github.com/dianpeng/hi-doctor/plan"
+	"github.com/dianpeng/hi-doctor/task"
+
+	"context"
+	"fmt"
+	"time"
+)
+
+// Run the task with its retry policy. Each try is a full Run of the task which
+// records its result into the history of its namespace, then the retry_on
+// expression is evaluated against it to decide whether the try failed. The
+// check of the task only runs after the last try, so a transient failure does
+// not fire the otherwise block. A try may record more than one result, ie the
+// port_list of tcp, so the check runs against each result of the last try in
+// order, as if the task ran without retry
+func runTaskWithRetry(
+	ctx context.Context,
+	env *dvar.EvalEnv,
+	r *plan.Retry,
+	t task.Task,
+) error {
+	attempt := 0
+	failure := 0
+	var lastErr error
+
+	type result struct {
+		field string
+		stat  map[string]interface{}
+	}
+	var lastResult []result
+
+	env.ObserveResult(func(field string, stat map[string]interface{}) {
+		lastResult = append(lastResult, result{field, stat})
+	})
+	defer env.ObserveResult(nil)
+
+	for {
+		env.Set("task", "attempt", dvar.NewIntVal(int64(attempt)))
+		lastResult = nil
+
+		failed := false
+		if err := t.Run(ctx, env); err != nil {
+			lastErr = err
+			failed = true
+		} else if v, err := r.RetryOn.Value(env); err != nil {
+			return fmt.Errorf("task.retry_on execution failed: %s", err)
+		} else {
+			lastErr = nil
+			failed = v.Boolean()
+		}
+
+		attempt++
+		if failed {
+			failure++
+		}
+		if !r.Opt.Next(attempt, failure, failed) {
+			break
+		}
+
+		// wait for the backoff, unless the task is aborted
+		timer := time.NewTimer(r.Opt.Backoff.Delay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("task(%s) aborted during retry: %s", t.Description(), ctx.Err())
+		case <-timer.C:
+			break
+		}
+	}
+
+	env.Set("task", "attempt_count", dvar.NewIntVal(int64(attempt)))
+	env.Set("task", "attempt_failure", dvar.NewIntVal(int64(failure)))
+
+	if lastErr != nil {
+		return lastErr
+	}
+	if len(lastResult) == 0 {
+		return r.Check.Run(env)
+	}
+	for _, x := range lastResult {
+		env.SetCurrentResult(x.field, x.stat)
+		if err := r.Check.Run(env); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package exec
+
+import (
+	"github.com/dianpeng/hi-doctor/storage"
+
+	"context"
+	"fmt"
+	"net"
+	"testing"
+)
+
+// the tcp task records one result per port, the second try is the last one and
+// the check must see both of its results
+const retryJob = `
+name: retry
+
+storage:
+  checked: storage.MapInt()
+  count: storage.Int(0)
+
+target:
+  format: json_v1
+  inline:
+    - name: local
+      ip: 127.0.0.1
+      port_list: [%d, %d]
+
+task:
+  - type: tcp
+    option:
+      timeout: 1
+    retry:
+      count: 3
+      retry_on: task.attempt == 0
+    check:
+      condition: "true"
+      then:
+        - storage.count.CheckedAdd(1, 100)
+        - "storage.checked.Set(tcp.port == %d ? 'first' : 'second', task.attempt)"
+
+trigger: trigger.Now()
+`
+
+func TestRetryCheckAllResult(t *testing.T) {
+	var port []int
+	for i := 0; i < 2; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("listen failed: %s", err)
+		}
+		defer l.Close()
+		port = append(port, l.Addr().(*net.TCPAddr).Port)
+	}
+
+	e := newTestExecutor(t, fmt.Sprintf(retryJob, port[0], port[1], port[0]))
+	if err := e.doRunActive(context.Background()); err != nil {
+		t.Fatalf("run failed: %s", err)
+	}
+
+	if got := e.storage["count"].(storage.Primitive).Get(); got != int64(2) {
+		t.Errorf("# of checks: got %v, want 2", got)
+	}
+	checked := e.storage["checked"].(storage.Map)
+	for _, key := range []string{"first", "second"} {
+		if got := checked.Get(key).Get(); got != int64(1) {
+			t.Errorf("check of the %s port: got %v, want it run after attempt 1", key, got)
+		}
+	}
+}
@@ -2,19 +2,44 @@ package exec
 
 import (
 	"github.com/dianpeng/hi-doctor/dvar"
+	"github.com/dianpeng/hi-doctor/plan"
 	"github.com/dianpeng/hi-doctor/task"
+
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
 )
 
 type ScheduleItem struct {
+	Index      int                   // index of the task planner
+	Id         string                // id of the task, empty if not specified
 	Guard      dvar.DVar             // guard of task
 	TargetItem *InspectionTargetItem // task information
 	Task       []task.Task           // task list itself, *must* be run in seq
+	Retry      *plan.Retry           // retry policy of the task, if any
+	Timeout    time.Duration         // timeout of the task, 0 means no timeout
+	OnError    int                   // on_error policy of the task
+	DependsOn  []int                 // index of the upstream task planner
+	RunIf      dvar.DVar             // the item only runs if it is true
 }
 
 // ScheduleBatch make sure everything inside of will be executed linearly,
-// regardlessly of what scheduler is been configured
+// regardlessly of what scheduler is been configured. If the job has task
+// dependency, the items of the batch runs as a DAG instead
 type ScheduleBatch struct {
 	Batch []ScheduleItem
+	Graph bool
+}
+
+// # of tasks inside of the batch
+func (s *ScheduleBatch) TaskSize() int {
+	cnt := 0
+	for _, x := range s.Batch {
+		cnt += len(x.Task)
+	}
+	return cnt
 }
 
 type ScheduleItemList []ScheduleBatch
@@ -24,6 +49,221 @@ type SchedulerEventListener interface {
 	OnAfterTaskBatch(*dvar.EvalEnv) error
 }
 
+// Scheduler runs the task list, and it should stop scheduling anything new once
+// the context is done
 type Scheduler interface {
-	Run(*Executor, ScheduleItemList, *dvar.EvalEnv, SchedulerEventListener) error
+	Run(context.Context, *Executor, ScheduleItemList, *dvar.EvalEnv, SchedulerEventListener) error
+}
+
+// summary of a run, shared by all the batches which may run in parallel
+type runSummary struct {
+	sync.Mutex
+	s plan.RunSummary
+}
+
+func newRunSummary() *runSummary {
+	return &runSummary{
+		s: plan.RunSummary{
+			Failure: []plan.RunFailure{},
+		},
+	}
+}
+
+func (r *runSummary) update(f func(*plan.RunSummary)) {
+	r.Lock()
+	defer r.Unlock()
+	f(&r.s)
+}
+
+func (r *runSummary) Summary() plan.RunSummary {
+	r.Lock()
+	defer r.Unlock()
+	out := r.s
+	out.Failure = append([]plan.RunFailure{}, r.s.Failure...)
+	return out
+}
+
+// returned by runScheduleItem when the rest of the batch should be skipped
+var errSkipBatch = errors.New("skip the rest of the batch")
+
+// Run all the items of the batch, shared by all the schedulers. The items run
+// linearly, unless the job has task dependency, then the batch runs as a DAG.
+// The failure of a task is handled by its on_error policy and recorded into
+// the summary, an error is returned only when the whole run should be aborted.
+// beforeTask, if not nil, is called before each task starts
+func runScheduleBatch(
+	ctx context.Context,
+	e *Executor,
+	env *dvar.EvalEnv,
+	batch *ScheduleBatch,
+	batchIdx int64,
+	taskIdx int64,
+	beforeTask func(context.Context) error,
+) error {
+	env.Set("task", "batch_index", dvar.NewIntVal(batchIdx))
+	env.Set("task", "error", dvar.NewStringVal(""))
+	e.summary.update(func(s *plan.RunSummary) {
+		s.BatchCount++
+	})
+
+	if batch.Graph {
+		return runScheduleGraph(ctx, e, env, batch, batchIdx, taskIdx, beforeTask)
+	}
+
+	for i := range batch.Batch {
+		v := &batch.Batch[i]
+
+		_, err := runScheduleItem(ctx, e.summary, env, v, batchIdx, taskIdx, beforeTask)
+		taskIdx += int64(len(v.Task))
+
+		if err == errSkipBatch {
+			skipped := int64(0)
+			for _, x := range batch.Batch[i+1:] {
+				skipped += int64(len(x.Task))
+			}
+			e.summary.update(func(s *plan.RunSummary) {
+				s.SkippedTask += skipped
+			})
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// status of a schedule item after it runs
+const (
+	itemDone   = iota // all the tasks succeed
+	itemFailed        // any task failed
+	itemNotRun        // run_if evaluates to false
+)
+
+// Run the tasks of the item linearly, once run_if evaluates to true. Returns
+// the status of the item, and an error if the batch should not move on, ie
+// errSkipBatch or the error which aborts the run
+func runScheduleItem(
+	ctx context.Context,
+	summary *runSummary,
+	env *dvar.EvalEnv,
+	v *ScheduleItem,
+	batchIdx int64,
+	taskIdx int64,
+	beforeTask func(context.Context) error,
+) (int, error) {
+	target := v.TargetItem
+
+	failure := func(idx int, desc string, err error, skipped int) error {
+		env.Set("task", "error", dvar.NewStringVal(err.Error()))
+		summary.update(func(s *plan.RunSummary) {
+			s.FailureCount++
+			s.SkippedTask += int64(skipped)
+			s.Failure = append(s.Failure, plan.RunFailure{
+				BatchIndex: batchIdx,
+				TaskIndex:  taskIdx + int64(idx),
+				Target:     target.Name,
+				Task:       desc,
+				Error:      err.Error(),
+			})
+		})
+
+		// the job is aborted, nothing should run anymore
+		if ctx.Err() != nil {
+			return err
+		}
+		switch v.OnError {
+		case plan.OnErrorContinue, plan.OnErrorSkipTask:
+			return nil
+		case plan.OnErrorSkipBatch:
+			return errSkipBatch
+		default:
+			return err
+		}
+	}
+
+	env.Set("task", "id", dvar.NewStringVal(v.Id))
+	env.Set("task", "task_index", dvar.NewIntVal(taskIdx))
+
+	// run_if is evaluated with the target of the item
+	target.SetupEnv(env)
+	runIf, err := v.RunIf.Value(env)
+	target.DelEnv(env)
+
+	if err != nil {
+		err = fmt.Errorf("task.run_if execution failed: %s", err)
+		return itemFailed, failure(0, "run_if", err, len(v.Task))
+	}
+	if !runIf.Boolean() {
+		summary.update(func(s *plan.RunSummary) {
+			s.SkippedTask += int64(len(v.Task))
+		})
+		return itemNotRun, nil
+	}
+
+	// make sure the item run linearly
+	status := itemDone
+	for j, t := range v.Task {
+		env.Set("task", "task_index", dvar.NewIntVal(taskIdx+int64(j)))
+		if beforeTask != nil {
+			if err := beforeTask(ctx); err != nil {
+				return itemFailed, err
+			}
+		}
+
+		// task execution
+		target.SetupEnv(env)
+		err := runScheduleTask(ctx, env, v, t)
+		target.DelEnv(env)
+
+		summary.update(func(s *plan.RunSummary) {
+			s.TaskCount++
+		})
+
+		if err == nil {
+			env.Set("task", "error", dvar.NewStringVal(""))
+			continue
+		}
+
+		status = itemFailed
+
+		// skip_task and skip_batch, the rest of the item is skipped
+		skipped := 0
+		if v.OnError == plan.OnErrorSkipTask || v.OnError == plan.OnErrorSkipBatch {
+			skipped = len(v.Task) - j - 1
+		}
+		if err := failure(j, t.Description(), err, skipped); err != nil {
+			return status, err
+		}
+		if skipped > 0 {
+			break
+		}
+	}
+	return status, nil
+}
+
+// run a single task of the schedule item, shared by all the schedulers
+func runScheduleTask(
+	ctx context.Context,
+	env *dvar.EvalEnv,
+	item *ScheduleItem,
+	t task.Task,
+) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("task(%s) aborted: %s", t.Description(), err)
+	}
+
+	if item.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, item.Timeout)
+		defer cancel()
+	}
+
+	if err := t.Prepare(ctx, env); err != nil {
+		return err
+	}
+	if item.Retry != nil {
+		return runTaskWithRetry(ctx, env, item.Retry, t)
+	}
+	return t.Run(ctx, env)
 }
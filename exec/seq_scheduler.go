@@ -2,6 +2,8 @@ package exec
 
 import (
 	"github.com/dianpeng/hi-doctor/dvar"
+
+	"context"
 )
 
 // basic scheduler
@@ -9,6 +11,7 @@ type seqScheduler struct {
 }
 
 func (_ *seqScheduler) Run(
+	ctx context.Context,
 	e *Executor,
 	x ScheduleItemList,
 	base *dvar.EvalEnv,
@@ -16,51 +19,31 @@ func (_ *seqScheduler) Run(
 ) error {
 
 	var taskIdx int64 = 0
-	var batchIdx int64 = 0
 
-	for _, batch := range x {
+	for batchIdx := range x {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		batch := &x[batchIdx]
 		env := newEvalEnvFromBase(e, base)
-		e.pushCurEnv(env)
-		env.Set("task", "batch_index", dvar.NewIntVal(batchIdx))
 
 		// event handling
 		if err := l.OnBeforeTaskBatch(env); err != nil {
-			e.popCurEnv()
 			return err
 		}
 
 		// the batch execution
-		for _, v := range batch.Batch {
-			target := v.TargetItem
-			taskList := v.Task
-
-			// make sure the batch run linearly
-			for _, task := range taskList {
-				env.Set("task", "task_index", dvar.NewIntVal(taskIdx))
-
-				// task execution
-				target.SetupEnv(env)
-				if err := task.Prepare(env); err != nil {
-					e.popCurEnv()
-					return err
-				}
-				if err := task.Run(env); err != nil {
-					e.popCurEnv()
-					return err
-				}
-				target.DelEnv(env)
-				taskIdx++
-			}
+		if err := runScheduleBatch(ctx, e, env, batch, int64(batchIdx), taskIdx, nil); err != nil {
+			return err
 		}
 
 		// event handling
 		if err := l.OnAfterTaskBatch(env); err != nil {
-			e.popCurEnv()
 			return err
 		}
 
-		batchIdx++
-		e.popCurEnv()
+		taskIdx += int64(batch.TaskSize())
 	}
 
 	return nil
@@ -0,0 +1,53 @@
+package exec
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+const sharedJob = `
+name: shared
+guard: %s
+
+target:
+  format: json_v1
+  count: 1
+
+trigger: trigger.Now()
+
+task:
+  - type: code
+    option:
+      code_block:
+        - 1 == 1
+`
+
+// the shared resources are released once the executor is done, even if the
+// plan is never stopped
+func TestSharedReleaseOnDone(t *testing.T) {
+	for _, guard := range []string{"true", "false"} {
+		e := newTestExecutor(t, fmt.Sprintf(sharedJob, guard))
+
+		released := make(chan struct{})
+		e.shared.Get("test", func() (interface{}, func()) {
+			return 1, func() {
+				close(released)
+			}
+		})
+
+		if err := e.Start(); err != nil {
+			t.Fatalf("guard %s, start failed: %s", guard, err)
+		}
+
+		select {
+		case <-released:
+			break
+		case <-time.After(5 * time.Second):
+			t.Fatalf("guard %s, shared resources are not released", guard)
+		}
+		if e.p.Context().Err() != nil {
+			t.Fatalf("guard %s, plan should not be stopped", guard)
+		}
+	}
+}
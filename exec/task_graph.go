@@ -0,0 +1,171 @@
+package exec
+
+import (
+	"github.com/dianpeng/hi-doctor/dvar"
+	"github.com/dianpeng/hi-doctor/plan"
+
+	"context"
+	"sync"
+)
+
+// Task graph of a batch. Once any task of the job declares depends_on, the
+// items of a batch run as a DAG. An item starts as soon as all its upstream
+// items succeed, so independent items run concurrently, and an item is skipped
+// if any of its upstream fails or does not run, due to its guard or run_if.
+//
+// Each item runs inside of its own env forked from the batch env, so the items
+// running concurrently never touch the same map. Once the item is done, its
+// modification is merged back into the batch env, then visible to the items
+// started afterwards, ie its downstream. Items running concurrently should use
+// tasks.<id> to access the result, since the type namespace, ie http, is
+// shared and the last one merged wins.
+
+// namespace owned by the item itself, never merged back into the batch env
+var graphEnvPrivate = []string{"task", "target", "var"}
+
+const (
+	graphNodePending = iota
+	graphNodeStarted
+	graphNodeSkipped
+)
+
+type graphNode struct {
+	item       *ScheduleItem
+	taskIdx    int64 // index of the first task of the item
+	pending    int   // # of upstream not done yet
+	downstream []int
+	state      int
+}
+
+type graphResult struct {
+	idx    int
+	status int
+	err    error
+}
+
+func runScheduleGraph(
+	ctx context.Context,
+	e *Executor,
+	env *dvar.EvalEnv,
+	batch *ScheduleBatch,
+	batchIdx int64,
+	taskIdx int64,
+	beforeTask func(context.Context) error,
+) error {
+	nodes := make([]graphNode, len(batch.Batch))
+	byPlanner := make(map[int]int)
+
+	for i := range batch.Batch {
+		v := &batch.Batch[i]
+		nodes[i].item = v
+		nodes[i].taskIdx = taskIdx
+		taskIdx += int64(len(v.Task))
+		byPlanner[v.Index] = i
+	}
+	// the upstream filtered out by its guard never runs, so the item is skipped
+	// like the one whose upstream does not run due to run_if
+	var filtered []int
+	for i := range nodes {
+		for _, dep := range nodes[i].item.DependsOn {
+			if j, ok := byPlanner[dep]; ok {
+				nodes[i].pending++
+				nodes[j].downstream = append(nodes[j].downstream, i)
+			} else {
+				filtered = append(filtered, i)
+			}
+		}
+	}
+
+	var envMutex sync.Mutex // guards the batch env
+	done := make(chan graphResult, len(nodes))
+	running := 0
+
+	start := func(i int) {
+		nodes[i].state = graphNodeStarted
+		running++
+
+		go func() {
+			envMutex.Lock()
+			fork := newEvalEnvFromBase(e, env)
+			envMutex.Unlock()
+			snapshot := fork.Snapshot()
+
+			status, err := runScheduleItem(
+				ctx,
+				e.summary,
+				fork,
+				nodes[i].item,
+				batchIdx,
+				nodes[i].taskIdx,
+				beforeTask,
+			)
+
+			envMutex.Lock()
+			fork.MergeInto(env, snapshot, graphEnvPrivate...)
+			envMutex.Unlock()
+
+			done <- graphResult{
+				idx:    i,
+				status: status,
+				err:    err,
+			}
+		}()
+	}
+
+	// skip the node and all its downstream
+	var skip func(int)
+	skip = func(i int) {
+		if nodes[i].state != graphNodePending {
+			return
+		}
+		nodes[i].state = graphNodeSkipped
+		e.summary.update(func(s *plan.RunSummary) {
+			s.SkippedTask += int64(len(nodes[i].item.Task))
+		})
+		for _, d := range nodes[i].downstream {
+			skip(d)
+		}
+	}
+
+	for _, i := range filtered {
+		skip(i)
+	}
+	for i := range nodes {
+		if nodes[i].pending == 0 && nodes[i].state == graphNodePending {
+			start(i)
+		}
+	}
+
+	// once stopped, ie abort or skip_batch, nothing new starts and the running
+	// ones are waited
+	var outE error
+	stopped := false
+
+	for running > 0 {
+		r := <-done
+		running--
+
+		if r.err != nil && !stopped {
+			stopped = true
+			if r.err != errSkipBatch {
+				outE = r.err
+			}
+		}
+
+		for _, d := range nodes[r.idx].downstream {
+			if r.status != itemDone {
+				skip(d)
+				continue
+			}
+			nodes[d].pending--
+			if nodes[d].pending == 0 && nodes[d].state == graphNodePending && !stopped {
+				start(d)
+			}
+		}
+	}
+
+	for i := range nodes {
+		skip(i)
+	}
+	return outE
+}
@@ -0,0 +1,179 @@
+package exec
+
+import (
+	"github.com/dianpeng/hi-doctor/dvar"
+	"github.com/dianpeng/hi-doctor/loader"
+	"github.com/dianpeng/hi-doctor/plan"
+
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// probe, then web and api in parallel, then final only if web passed. The bad
+// branch fails, the never branch does not run due to run_if and the off branch
+// is filtered out by its guard, so their downstream is skipped
+const taskGraphJob = `
+name: task_graph
+on_error: continue
+
+target:
+  format: json_v1
+  inline:
+    - name: local
+      ip: 127.0.0.1
+      port: %s
+
+task:
+  - type: http
+    id: probe
+    option:
+      method: GET
+      path: /
+
+  - type: http
+    id: web
+    depends_on: [probe]
+    option:
+      method: GET
+      path: /slow
+
+  - type: http
+    id: api
+    depends_on: [probe]
+    option:
+      method: GET
+      path: /slow
+
+  - type: code
+    id: final
+    depends_on: [web, api]
+    run_if: tasks.web.resp_status == 200
+    option:
+      code_block:
+        - testcapture.Set('final.api', tasks.api.resp_status)
+        - testcapture.Set('final.probe', tasks.probe.resp_status)
+
+  - type: code
+    id: bad
+    option:
+      code_block:
+        - Fail()
+
+  - type: code
+    depends_on: [bad]
+    option:
+      code_block:
+        - testcapture.Set('after_bad', true)
+
+  - type: code
+    id: never
+    depends_on: [probe]
+    run_if: "false"
+    option:
+      code_block:
+        - testcapture.Set('never', true)
+
+  - type: code
+    depends_on: [never]
+    option:
+      code_block:
+        - testcapture.Set('after_never', true)
+
+  - type: code
+    id: "off"
+    option:
+      code_block:
+        - testcapture.Set('off', true)
+
+  - type: code
+    depends_on: ["off"]
+    option:
+      code_block:
+        - testcapture.Set('after_off', true)
+
+trigger: trigger.Now()
+`
+
+func TestTaskGraph(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	e := newTestExecutor(t, fmt.Sprintf(taskGraphJob, port))
+	for i, pi := range e.p.TaskPlannerList {
+		if pi.Id == "off" {
+			e.p.TaskPlannerList[i].Guard, _ = dvar.NewDVarScriptContext("false")
+		}
+	}
+
+	start := time.Now()
+	if err := e.doRunActive(context.Background()); err != nil {
+		t.Fatalf("run failed: %s", err)
+	}
+	if d := time.Since(start); d >= 400*time.Millisecond {
+		t.Fatalf("web and api should run concurrently: got %s, want < 400ms", d)
+	}
+
+	// final runs with the result of upstream, the rest are skipped
+	expectCaptured(t, e, map[string]interface{}{
+		"final.api":   float64(200),
+		"final.probe": float64(200),
+		"after_bad":   nil,
+		"never":       nil,
+		"after_never": nil,
+		"off":         nil,
+		"after_off":   nil,
+	})
+
+	s := e.p.ExecuteInfo.LastSummary
+	if s.TaskCount != 5 {
+		t.Errorf("summary task_count: got %d, want 5", s.TaskCount)
+	}
+	if s.FailureCount != 1 {
+		t.Errorf("summary failure_count: got %d, want 1", s.FailureCount)
+	}
+	if s.SkippedTask != 4 {
+		t.Errorf("summary skipped_task: got %d, want 4", s.SkippedTask)
+	}
+}
+
+func TestTaskGraphCompile(t *testing.T) {
+	job := `
+name: task_graph
+target:
+  format: json_v1
+  count: 1
+task:
+  - type: code
+    id: a
+    depends_on: [%s]
+    option:
+      code_block:
+        - "true"
+  - type: code
+    id: b
+    depends_on: [a]
+    option:
+      code_block:
+        - "true"
+trigger: trigger.Now()
+`
+	for _, x := range []string{"b", "a", "c"} {
+		m, err := loader.ParseData(fmt.Sprintf(job, x))
+		if err != nil {
+			t.Fatalf("parse job failed: %s", err)
+		}
+		if _, err := plan.Compile(m); err == nil {
+			t.Fatalf("depends_on %s should fail", x)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package exec
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// login then call the api with the token obtained from the login, both are
+// http task and recorded under their own id
+const taskIdJob = `
+name: task_id
+
+target:
+  format: json_v1
+  inline:
+    - name: local
+      ip: 127.0.0.1
+      port: %s
+
+task:
+  - type: http
+    id: login
+    option:
+      method: GET
+      path: /login
+  - type: http
+    id: api
+    option:
+      method: GET
+      path: /api
+      header:
+        Authorization: ${tasks.login.resp_body}
+  - type: code
+    option:
+      code_block:
+        - testcapture.Set('login.status', tasks.login.resp_status)
+        - testcapture.Set('login.history.body', tasks.login.history[0].resp_body)
+        - testcapture.Set('api.status', tasks.api.resp_status)
+        - testcapture.Set('api.body', tasks.api.resp_body)
+        - testcapture.Set('http.status', http.resp_status)
+        - testcapture.Set('http.history.status', http.history[0].resp_status)
+
+trigger: trigger.Now()
+`
+
+func TestTaskId(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			w.WriteHeader(201)
+			w.Write([]byte("token"))
+		default:
+			if r.Header.Get("Authorization") != "token" {
+				w.WriteHeader(403)
+				return
+			}
+			w.Write([]byte("hello"))
+		}
+	}))
+	defer server.Close()
+
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	e := newTestExecutor(t, fmt.Sprintf(taskIdJob, port))
+	if err := e.doRunActive(context.Background()); err != nil {
+		t.Fatalf("run failed: %s", err)
+	}
+
+	// the namespace of the type keeps the results of both tasks
+	expectCaptured(t, e, map[string]interface{}{
+		"login.status":        float64(201),
+		"login.history.body":  "token",
+		"api.status":          float64(200),
+		"api.body":            "hello",
+		"http.status":         float64(200),
+		"http.history.status": float64(201),
+	})
+}
@@ -1,6 +1,7 @@
 package fetch
 
 import (
+	"context"
 	"fmt"
 	"net/url"
 
@@ -17,7 +18,7 @@ type Fetcher interface {
 
 	// obtain the resources, returns a byte array which contains all the needed
 	// data or an error
-	Obtain() ([]byte, error)
+	Obtain(context.Context) ([]byte, error)
 
 	Description() string
 }
@@ -26,8 +27,9 @@ type FetcherFactory interface {
 	Create(*dvar.EvalEnv) (Fetcher, error)
 }
 
+// The env is the one creating the fetcher, ie for the job level settings
 type FetcherFactoryCompiler interface {
-	Compile(*url.URL, *Fetch) (Fetcher, error)
+	Compile(*url.URL, *Fetch, *dvar.EvalEnv) (Fetcher, error)
 }
 
 type fetchFactory struct {
@@ -68,7 +70,7 @@ func (f *fetchFactory) Create(env *dvar.EvalEnv) (Fetcher, error) {
 		return nil, fmt.Errorf("fetch_factory.Create url scheme is unknown to us")
 	}
 
-	return ffc.Compile(u, f.model)
+	return ffc.Compile(u, f.model, env)
 }
 
 var fetcherFactoryCompiler map[string]FetcherFactoryCompiler = make(map[string]FetcherFactoryCompiler)
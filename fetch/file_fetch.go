@@ -1,10 +1,13 @@
 package fetch
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/url"
 	"os"
+
+	"github.com/dianpeng/hi-doctor/dvar"
 )
 
 type fileFetcher struct {
@@ -12,7 +15,7 @@ type fileFetcher struct {
 	model *Fetch
 }
 
-func (f *fileFetcher) Obtain() ([]byte, error) {
+func (f *fileFetcher) Obtain(_ context.Context) ([]byte, error) {
 	file, err := os.Open(f.path)
 	if err != nil {
 		return nil, fmt.Errorf("file_fetcher(%s) cannot open file: %s", f.path, err)
@@ -36,7 +39,7 @@ func compileFileFetcher(url *url.URL, model *Fetch) (Fetcher, error) {
 
 type filefetcherfactory struct{}
 
-func (_ *filefetcherfactory) Compile(n *url.URL, f *Fetch) (Fetcher, error) {
+func (_ *filefetcherfactory) Compile(n *url.URL, f *Fetch, _ *dvar.EvalEnv) (Fetcher, error) {
 	return compileFileFetcher(n, f)
 }
 
@@ -1,23 +1,47 @@
 package fetch
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+
+	"github.com/dianpeng/hi-doctor/dvar"
+	"github.com/dianpeng/hi-doctor/util"
 )
 
 type httpFetcher struct {
 	url *url.URL // url object
 
+	// proxy of the request, nil means the default client is used. It comes
+	// from option.proxy, otherwise the job's proxy
+	proxy *url.URL
+
 	// for now we do not support any sort of complicated method, in the future
 	// we can somehow add some simple authentication, like Basic, Digest, even
 	// JWT ect ...
 	model *Fetch
 }
 
-func (h *httpFetcher) Obtain() ([]byte, error) {
-	resp, err := http.Get(h.url.String())
+func (h *httpFetcher) Obtain(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.url.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("http fetcher(%s) fail %s", h.url.String(), err)
+	}
+
+	client := http.DefaultClient
+	if h.proxy != nil {
+		transport := &http.Transport{
+			Proxy: http.ProxyURL(h.proxy),
+		}
+		defer transport.CloseIdleConnections()
+		client = &http.Client{
+			Transport: transport,
+		}
+	}
+
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("http fetcher(%s) fail %s", h.url.String(), err)
 	}
@@ -32,9 +56,19 @@ func (h *httpFetcher) Description() string {
 	return fmt.Sprintf("http_fetch(%s)", h.url.String())
 }
 
-func compileHttpFetcher(url *url.URL, model *Fetch) (Fetcher, error) {
+func compileHttpFetcher(url *url.URL, model *Fetch, env *dvar.EvalEnv) (Fetcher, error) {
+	proxy := env.Proxy()
+	if v, ok := model.Option["proxy"].(string); ok && v != "" {
+		proxy = v
+	}
+	p, err := util.ParseProxy(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("http fetcher(%s) %s", url.String(), err)
+	}
+
 	return &httpFetcher{
 		url:   url,
+		proxy: p,
 		model: model,
 	}, nil
 }
@@ -42,8 +76,8 @@ func compileHttpFetcher(url *url.URL, model *Fetch) (Fetcher, error) {
 type httpfetcherfactory struct {
 }
 
-func (_ *httpfetcherfactory) Compile(n *url.URL, f *Fetch) (Fetcher, error) {
-	return compileHttpFetcher(n, f)
+func (_ *httpfetcherfactory) Compile(n *url.URL, f *Fetch, env *dvar.EvalEnv) (Fetcher, error) {
+	return compileHttpFetcher(n, f, env)
 }
 
 func init() {
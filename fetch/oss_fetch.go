@@ -1,7 +1,9 @@
 package fetch
 
 import (
+	"context"
 	"fmt"
+	"github.com/dianpeng/hi-doctor/dvar"
 	"github.com/dianpeng/hi-doctor/oss"
 	"io"
 	"net/url"
@@ -13,8 +15,8 @@ type ossFetcher struct {
 	model *Fetch
 }
 
-func (f *ossFetcher) Obtain() ([]byte, error) {
-	data, err := f.cli.Get(f.path)
+func (f *ossFetcher) Obtain(ctx context.Context) ([]byte, error) {
+	data, err := f.cli.Get(ctx, f.path)
 	if err != nil {
 		return nil, fmt.Errorf("oss_fetcher cannot load %s, %s", f.path, err)
 	}
@@ -32,7 +34,7 @@ func (f *ossFetcher) Description() string {
 
 type ossfetcherfactory struct{}
 
-func (_ *ossfetcherfactory) Compile(n *url.URL, f *Fetch) (Fetcher, error) {
+func (_ *ossfetcherfactory) Compile(n *url.URL, f *Fetch, _ *dvar.EvalEnv) (Fetcher, error) {
 	// The scheme is as following, oss://[bucket-name][/]path. Here the bucket
 	// name is been encoded as hostname of the URI/URL for now. This is duplicated
 	// from the option, field. We do a sanity check here
@@ -0,0 +1,282 @@
+package oss
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Local filesystem backed OSS, mainly for developing and testing the OSS based
+// job without any cloud account. Each bucket maps to a directory under the
+// root, ie root/bucket, and the object key maps to the file path relative to
+// the bucket directory. Option:
+//
+//   provider: local
+//   root: /path/to/root    # directory contains all the buckets
+//   bucket: my-bucket      # optional, the root itself is the bucket if empty
+
+// temporary file created during put, never listed
+const localTmpPrefix = ".hi-doctor-tmp-"
+
+// returned by the walk function of List to stop walking once enough keys are
+// listed, it is not an error of the List itself
+var errLocalListDone = errors.New("list done")
+
+type localOss struct {
+	dir string // directory of the bucket
+}
+
+type localObject struct {
+	path  string
+	file  *os.File
+	size  int64
+	mtime time.Time
+
+	headerOnce sync.Once
+	header     http.Header
+}
+
+func (o *localObject) Reader() io.ReadCloser {
+	if o.file == nil {
+		return http.NoBody
+	}
+	return o.file
+}
+
+func (o *localObject) Size() int64 {
+	return o.size
+}
+
+func (o *localObject) ModifyTime() time.Time {
+	return o.mtime
+}
+
+// headers mimics what a http based OSS returns, the etag is the md5 of the
+// content which is computed lazily
+func (o *localObject) Headers() http.Header {
+	o.headerOnce.Do(func() {
+		h := make(http.Header)
+		h.Set("Content-Length", fmt.Sprintf("%d", o.size))
+		h.Set("Last-Modified", o.mtime.UTC().Format(http.TimeFormat))
+
+		ctype := mime.TypeByExtension(path.Ext(o.path))
+		if ctype == "" {
+			ctype = "application/octet-stream"
+		}
+		h.Set("Content-Type", ctype)
+
+		if digest, err := localFileMd5(o.path); err == nil {
+			h.Set("ETag", fmt.Sprintf("\"%s\"", digest))
+		}
+		o.header = h
+	})
+	return o.header
+}
+
+func localFileMd5(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// object key to the file path, the key can never escape the bucket directory
+func (l *localOss) filePath(key string) (string, error) {
+	clean := strings.TrimPrefix(path.Clean("/"+key), "/")
+	if clean == "" {
+		return "", fmt.Errorf("oss(local) invalid object key %q", key)
+	}
+	return filepath.Join(l.dir, filepath.FromSlash(clean)), nil
+}
+
+func (l *localOss) Head(_ context.Context, key string) (Object, error) {
+	p, err := l.filePath(key)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := os.Stat(p)
+	if err != nil {
+		return nil, fmt.Errorf("oss(local) head %s failed: %s", key, err)
+	}
+	if st.IsDir() {
+		return nil, fmt.Errorf("oss(local) head %s failed: not an object", key)
+	}
+
+	return &localObject{
+		path:  p,
+		size:  st.Size(),
+		mtime: st.ModTime(),
+	}, nil
+}
+
+func (l *localOss) Get(_ context.Context, key string) (Object, error) {
+	p, err := l.filePath(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, fmt.Errorf("oss(local) get %s failed: %s", key, err)
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("oss(local) get %s failed: %s", key, err)
+	}
+	if st.IsDir() {
+		f.Close()
+		return nil, fmt.Errorf("oss(local) get %s failed: not an object", key)
+	}
+
+	return &localObject{
+		path:  p,
+		file:  f,
+		size:  st.Size(),
+		mtime: st.ModTime(),
+	}, nil
+}
+
+// the object is written into a temporary file and then renamed, so a reader
+// never observes a partial object
+func (l *localOss) Put(_ context.Context, key string, data io.Reader, size int64) error {
+	p, err := l.filePath(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return fmt.Errorf("oss(local) put %s failed: %s", key, err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p), localTmpPrefix)
+	if err != nil {
+		return fmt.Errorf("oss(local) put %s failed: %s", key, err)
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	n, err := io.Copy(tmp, data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("oss(local) put %s failed: %s", key, err)
+	}
+	if size >= 0 && n != size {
+		return fmt.Errorf("oss(local) put %s failed: size mismatch, expect %d, got %d", key, size, n)
+	}
+
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return fmt.Errorf("oss(local) put %s failed: %s", key, err)
+	}
+	return nil
+}
+
+func (l *localOss) Copy(ctx context.Context, src string, dst string) error {
+	obj, err := l.Get(ctx, src)
+	if err != nil {
+		return fmt.Errorf("oss(local) copy %s failed: %s", src, err)
+	}
+	body := obj.Reader()
+	defer body.Close()
+
+	return l.Put(ctx, dst, body, obj.Size())
+}
+
+// deleting a non-existed object is not an error, same as most of the OSS
+func (l *localOss) Del(_ context.Context, key string) error {
+	p, err := l.filePath(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("oss(local) del %s failed: %s", key, err)
+	}
+	return nil
+}
+
+func (l *localOss) Provider() string {
+	return "local"
+}
+
+// list all the object keys with the prefix recursively, in lexical order. max
+// less or equal to 0 means no limit
+func (l *localOss) List(_ context.Context, prefix string, max int) ([]string, error) {
+	prefix = strings.TrimPrefix(prefix, "/")
+	out := []string{}
+
+	err := filepath.WalkDir(l.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), localTmpPrefix) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(l.dir, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		out = append(out, key)
+		if max > 0 && len(out) >= max {
+			return errLocalListDone
+		}
+		return nil
+	})
+	if err != nil && err != errLocalListDone {
+		return nil, fmt.Errorf("oss(local) list %s failed: %s", prefix, err)
+	}
+	return out, nil
+}
+
+type localOssFactory struct{}
+
+func (f *localOssFactory) Create(opt Option) (Oss, error) {
+	root, ok := opt.getString("root")
+	if !ok || root == "" {
+		return nil, fmt.Errorf("oss(local) root is not specified")
+	}
+
+	dir := root
+	if bucket, ok := opt.GetBucket(); ok && bucket != "" {
+		if strings.ContainsAny(bucket, "/\\") || bucket == "." || bucket == ".." {
+			return nil, fmt.Errorf("oss(local) invalid bucket %q", bucket)
+		}
+		dir = filepath.Join(root, bucket)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("oss(local) bucket directory %s: %s", dir, err)
+	}
+	return &localOss{
+		dir: dir,
+	}, nil
+}
+
+func init() {
+	RegisterOSSFactory("local", &localOssFactory{})
+}
@@ -0,0 +1,83 @@
+package oss
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLocalOss(t *testing.T) {
+	root := t.TempDir()
+	f := GetOSSFactory("local")
+	if f == nil {
+		t.Fatalf("local provider is not registered")
+	}
+	cli, err := f.Create(Option{"root": root, "bucket": "b"})
+	if err != nil {
+		t.Fatalf("create failed: %s", err)
+	}
+	ctx := context.Background()
+
+	for _, x := range []string{"/a/1.json", "a/2.txt", "b/3.txt"} {
+		if err := cli.Put(ctx, x, strings.NewReader(x), int64(len(x))); err != nil {
+			t.Fatalf("put %s failed: %s", x, err)
+		}
+	}
+	if err := cli.Put(ctx, "bad", strings.NewReader("xx"), 1); err == nil {
+		t.Fatalf("put with wrong size should fail")
+	}
+	if _, err := os.Stat(filepath.Join(root, "b", "a", "1.json")); err != nil {
+		t.Fatalf("object is not under the bucket directory: %s", err)
+	}
+
+	obj, err := cli.Get(ctx, "a/1.json")
+	if err != nil {
+		t.Fatalf("get failed: %s", err)
+	}
+	data, _ := io.ReadAll(obj.Reader())
+	obj.Reader().Close()
+	if string(data) != "/a/1.json" || obj.Size() != 9 || obj.ModifyTime().IsZero() {
+		t.Fatalf("unexpected object: %s %d", data, obj.Size())
+	}
+	h := obj.Headers()
+	if h.Get("Content-Type") != "application/json" ||
+		h.Get("Content-Length") != "9" ||
+		h.Get("ETag") != "\"8938b6365b700893f1e3f860e46a1398\"" {
+		t.Fatalf("unexpected headers: %v", h)
+	}
+
+	if obj, err := cli.Head(ctx, "a/1.json"); err != nil || obj.Size() != 9 {
+		t.Fatalf("head failed: %v", err)
+	}
+	if err := cli.Copy(ctx, "a/1.json", "c/1.json"); err != nil {
+		t.Fatalf("copy failed: %s", err)
+	}
+	if obj, err := cli.Head(ctx, "c/1.json"); err != nil || obj.Headers().Get("ETag") != h.Get("ETag") {
+		t.Fatalf("copied object is not the same: %v", err)
+	}
+
+	if _, err := cli.Get(ctx, "../../etc/passwd"); err == nil {
+		t.Fatalf("key must not escape the bucket")
+	}
+
+	if l, _ := cli.List(ctx, "/a/", 0); !reflect.DeepEqual(l, []string{"a/1.json", "a/2.txt"}) {
+		t.Fatalf("unexpected list: %v", l)
+	}
+	if l, _ := cli.List(ctx, "", 2); len(l) != 2 {
+		t.Fatalf("list should be limited: %v", l)
+	}
+
+	if err := cli.Del(ctx, "a/1.json"); err != nil {
+		t.Fatalf("del failed: %s", err)
+	}
+	if err := cli.Del(ctx, "a/1.json"); err != nil {
+		t.Fatalf("del is not idempotent: %s", err)
+	}
+	if _, err := cli.Get(ctx, "a/1.json"); err == nil {
+		t.Fatalf("object should be deleted")
+	}
+}
@@ -1,6 +1,7 @@
 package oss
 
 import (
+	"context"
 	"io"
 	"net/http"
 	"time"
@@ -15,13 +16,19 @@ type Object interface {
 	Headers() http.Header
 }
 
+// The context aborts the request, the reader of the object returned by Get is
+// bound to it as well
 type Oss interface {
-	Get(string) (Object, error)
-	Put(string, io.Reader, int64) error
-	Del(string) error
+	Get(context.Context, string) (Object, error)
+	// metadata of the object only, the reader of the object is empty
+	Head(context.Context, string) (Object, error)
+	Put(context.Context, string, io.Reader, int64) error
+	// copy the object from the source to the destination within the bucket
+	Copy(context.Context, string, string) error
+	Del(context.Context, string) error
 	Provider() string
 	// list all the files in the current directory
-	List(string, int) ([]string, error)
+	List(context.Context, string, int) ([]string, error)
 }
 
 type OssFactory interface {
@@ -0,0 +1,532 @@
+package oss
+
+import (
+	"github.com/dianpeng/hi-doctor/util"
+	"github.com/mitchellh/mapstructure"
+
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// S3 compatible OSS, ie AWS S3, MinIO, Ceph RGW and most of the vendor cloud.
+// Requests are signed with SigV4 unless the access key is empty, which means
+// anonymous access. Option:
+//
+//   provider: s3
+//   endpoint: https://s3.us-east-1.amazonaws.com  # default from region
+//   region: us-east-1                             # default us-east-1
+//   bucket: my-bucket
+//   access_key: AK
+//   secret_key: SK
+//   session_token: token    # optional, temporary credential
+//   path_style: true        # endpoint/bucket/key, otherwise bucket.endpoint/key
+//   part_size: 8388608      # object larger than it is uploaded with multipart,
+//                           # at least 5MiB which is the minimum part size of S3
+//   timeout: 30             # in seconds, of connecting and waiting for the
+//                           # response header, the body is not limited so a
+//                           # large object can be streamed
+
+const (
+	s3DefaultRegion   = "us-east-1"
+	s3DefaultPartSize = 8 << 20
+	s3MinPartSize     = 5 << 20
+	s3DefaultTimeout  = 30
+	s3MaxListKeys     = 1000
+	s3AbortTimeout    = 10 * time.Second
+)
+
+type s3Define struct {
+	Endpoint     string `mapstructure:"endpoint"`
+	Region       string `mapstructure:"region"`
+	Bucket       string `mapstructure:"bucket"`
+	AccessKey    string `mapstructure:"access_key"`
+	SecretKey    string `mapstructure:"secret_key"`
+	SessionToken string `mapstructure:"session_token"`
+	PathStyle    bool   `mapstructure:"path_style"`
+	PartSize     int64  `mapstructure:"part_size"`
+	Timeout      int64  `mapstructure:"timeout"`
+}
+
+type s3Oss struct {
+	endpoint  *url.URL
+	bucket    string
+	pathStyle bool
+	partSize  int64
+	signer    *util.SigV4 // nil means anonymous
+	client    *http.Client
+}
+
+type s3Object struct {
+	resp  *http.Response
+	mtime time.Time
+}
+
+func (o *s3Object) Reader() io.ReadCloser {
+	return o.resp.Body
+}
+
+func (o *s3Object) Size() int64 {
+	return o.resp.ContentLength
+}
+
+func (o *s3Object) ModifyTime() time.Time {
+	return o.mtime
+}
+
+func (o *s3Object) Headers() http.Header {
+	return o.resp.Header
+}
+
+// xml documents of the S3 API
+
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+type s3ListResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+}
+
+type s3InitiateMultipartResult struct {
+	UploadId string `xml:"UploadId"`
+}
+
+type s3CompletePart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type s3CompleteMultipart struct {
+	XMLName xml.Name         `xml:"CompleteMultipartUpload"`
+	Part    []s3CompletePart `xml:"Part"`
+}
+
+// URL of the object, key is empty for the bucket itself
+func (s *s3Oss) url(key string, query url.Values) *url.URL {
+	u := *s.endpoint
+	base := strings.TrimSuffix(u.Path, "/")
+
+	if s.pathStyle {
+		u.Path = base + "/" + s.bucket + "/" + key
+	} else {
+		u.Host = s.bucket + "." + u.Host
+		u.Path = base + "/" + key
+	}
+	u.RawPath = ""
+	u.RawQuery = query.Encode()
+	return &u
+}
+
+func s3Key(key string) (string, error) {
+	key = strings.TrimLeft(key, "/")
+	if key == "" {
+		return "", fmt.Errorf("oss(s3) object key is empty")
+	}
+	return key, nil
+}
+
+// issue the request, any status other than 2xx is turned into error with the
+// S3 error code if any
+func (s *s3Oss) do(
+	ctx context.Context,
+	method string,
+	u *url.URL,
+	body []byte,
+	header http.Header,
+) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if s.signer != nil {
+		s.signer.Sign(req, util.SigV4PayloadHash(body), time.Now())
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		return resp, s3ResponseError(resp)
+	}
+	return resp, nil
+}
+
+func s3ResponseError(resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	e := s3Error{}
+	if xml.Unmarshal(data, &e) == nil && e.Code != "" {
+		return fmt.Errorf("status %d, %s: %s", resp.StatusCode, e.Code, e.Message)
+	}
+	return fmt.Errorf("status %d", resp.StatusCode)
+}
+
+// do the request and decode the xml response
+func (s *s3Oss) doXML(
+	ctx context.Context,
+	method string,
+	u *url.URL,
+	body []byte,
+	header http.Header,
+	out interface{},
+) error {
+	resp, err := s.do(ctx, method, u, body, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	// complete multipart and copy may fail with status 200 and an error
+	// document
+	e := s3Error{}
+	if xml.Unmarshal(data, &e) == nil && e.Code != "" {
+		return fmt.Errorf("%s: %s", e.Code, e.Message)
+	}
+	if out != nil {
+		if err := xml.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("invalid response: %s", err)
+		}
+	}
+	return nil
+}
+
+func (s *s3Oss) Get(ctx context.Context, key string) (Object, error) {
+	k, err := s3Key(key)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(ctx, "GET", s.url(k, nil), nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("oss(s3) get %s failed: %s", key, err)
+	}
+
+	mtime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return &s3Object{
+		resp:  resp,
+		mtime: mtime,
+	}, nil
+}
+
+func (s *s3Oss) Head(ctx context.Context, key string) (Object, error) {
+	k, err := s3Key(key)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(ctx, "HEAD", s.url(k, nil), nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("oss(s3) head %s failed: %s", key, err)
+	}
+
+	mtime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return &s3Object{
+		resp:  resp,
+		mtime: mtime,
+	}, nil
+}
+
+// server side copy within the bucket
+func (s *s3Oss) Copy(ctx context.Context, src string, dst string) error {
+	sk, err := s3Key(src)
+	if err != nil {
+		return err
+	}
+	dk, err := s3Key(dst)
+	if err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	header.Set("X-Amz-Copy-Source", (&url.URL{Path: "/" + s.bucket + "/" + sk}).EscapedPath())
+	if err := s.doXML(ctx, "PUT", s.url(dk, nil), nil, header, nil); err != nil {
+		return fmt.Errorf("oss(s3) copy %s to %s failed: %s", src, dst, err)
+	}
+	return nil
+}
+
+// read up to the part size into the buffer, a short read means the end of the
+// data. The buffer is reused by the following parts once the part is uploaded
+func (s *s3Oss) readPart(data io.Reader, buf []byte) ([]byte, error) {
+	n, err := io.ReadFull(data, buf[:s.partSize])
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return buf[:n], err
+}
+
+// object not larger than the part size is uploaded with a single PUT,
+// otherwise with multipart upload. The size is only used for verification,
+// negative size means unknown
+func (s *s3Oss) Put(ctx context.Context, key string, data io.Reader, size int64) error {
+	k, err := s3Key(key)
+	if err != nil {
+		return err
+	}
+
+	// at most 2 parts are held at the same time, the first one is uploaded
+	// while the second one tells whether multipart is needed
+	first, err := s.readPart(data, make([]byte, s.partSize))
+	if err != nil {
+		return fmt.Errorf("oss(s3) put %s failed: %s", key, err)
+	}
+	var next []byte
+	if int64(len(first)) == s.partSize {
+		if next, err = s.readPart(data, make([]byte, s.partSize)); err != nil {
+			return fmt.Errorf("oss(s3) put %s failed: %s", key, err)
+		}
+	}
+
+	if len(next) == 0 {
+		if size >= 0 && int64(len(first)) != size {
+			return fmt.Errorf("oss(s3) put %s failed: size mismatch, expect %d, got %d", key, size, len(first))
+		}
+		resp, err := s.do(ctx, "PUT", s.url(k, nil), first, nil)
+		if err != nil {
+			return fmt.Errorf("oss(s3) put %s failed: %s", key, err)
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	if err := s.putMultipart(ctx, k, data, size, first, next); err != nil {
+		return fmt.Errorf("oss(s3) put %s failed: %s", key, err)
+	}
+	return nil
+}
+
+func (s *s3Oss) putMultipart(
+	ctx context.Context,
+	key string,
+	data io.Reader,
+	size int64,
+	first []byte,
+	next []byte,
+) error {
+	init := s3InitiateMultipartResult{}
+	if err := s.doXML(ctx, "POST", s.url(key, url.Values{"uploads": {""}}), nil, nil, &init); err != nil {
+		return fmt.Errorf("initiate multipart upload: %s", err)
+	}
+	if init.UploadId == "" {
+		return fmt.Errorf("initiate multipart upload: empty upload id")
+	}
+
+	err := s.uploadParts(ctx, key, init.UploadId, data, size, first, next)
+	if err != nil {
+		// best effort, the parts uploaded are removed even if the context is
+		// done already
+		abort, cancel := context.WithTimeout(context.Background(), s3AbortTimeout)
+		defer cancel()
+		if resp, aerr := s.do(abort, "DELETE", s.url(key, url.Values{"uploadId": {init.UploadId}}), nil, nil); aerr == nil {
+			resp.Body.Close()
+		}
+	}
+	return err
+}
+
+func (s *s3Oss) uploadParts(
+	ctx context.Context,
+	key string,
+	uploadId string,
+	data io.Reader,
+	size int64,
+	first []byte,
+	next []byte,
+) error {
+	complete := s3CompleteMultipart{}
+	total := int64(0)
+	part := first
+
+	for len(part) != 0 {
+		num := len(complete.Part) + 1
+		u := s.url(key, url.Values{
+			"partNumber": {fmt.Sprintf("%d", num)},
+			"uploadId":   {uploadId},
+		})
+		resp, err := s.do(ctx, "PUT", u, part, nil)
+		if err != nil {
+			return fmt.Errorf("upload part %d: %s", num, err)
+		}
+		resp.Body.Close()
+
+		complete.Part = append(complete.Part, s3CompletePart{
+			PartNumber: num,
+			ETag:       resp.Header.Get("ETag"),
+		})
+		total += int64(len(part))
+
+		if next != nil {
+			part, next = next, nil
+		} else if int64(len(part)) < s.partSize {
+			break
+		} else if part, err = s.readPart(data, part); err != nil {
+			return err
+		}
+	}
+
+	if size >= 0 && total != size {
+		return fmt.Errorf("size mismatch, expect %d, got %d", size, total)
+	}
+
+	body, err := xml.Marshal(&complete)
+	if err != nil {
+		return err
+	}
+	if err := s.doXML(ctx, "POST", s.url(key, url.Values{"uploadId": {uploadId}}), body, nil, nil); err != nil {
+		return fmt.Errorf("complete multipart upload: %s", err)
+	}
+	return nil
+}
+
+// deleting a non-existed object is not an error
+func (s *s3Oss) Del(ctx context.Context, key string) error {
+	k, err := s3Key(key)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, "DELETE", s.url(k, nil), nil, nil)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil
+		}
+		return fmt.Errorf("oss(s3) del %s failed: %s", key, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *s3Oss) Provider() string {
+	return "s3"
+}
+
+// list the object keys with the prefix via ListObjectsV2, following the
+// continuation token until max keys are collected. max less or equal to 0
+// means no limit
+func (s *s3Oss) List(ctx context.Context, prefix string, max int) ([]string, error) {
+	prefix = strings.TrimLeft(prefix, "/")
+	out := []string{}
+	token := ""
+
+	for {
+		q := url.Values{
+			"list-type": {"2"},
+			"prefix":    {prefix},
+		}
+		keys := s3MaxListKeys
+		if max > 0 && max-len(out) < keys {
+			keys = max - len(out)
+		}
+		q.Set("max-keys", fmt.Sprintf("%d", keys))
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+
+		r := s3ListResult{}
+		if err := s.doXML(ctx, "GET", s.url("", q), nil, nil, &r); err != nil {
+			return nil, fmt.Errorf("oss(s3) list %s failed: %s", prefix, err)
+		}
+		for _, c := range r.Contents {
+			out = append(out, c.Key)
+		}
+
+		if max > 0 && len(out) >= max {
+			return out[:max], nil
+		}
+		if !r.IsTruncated || r.NextContinuationToken == "" {
+			return out, nil
+		}
+		token = r.NextContinuationToken
+	}
+}
+
+// the timeout only covers the connecting and the response header, the client's
+// Timeout would also cap the reading of the body which breaks the streaming
+// of a large object
+func s3Client(timeout time.Duration) *http.Client {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.DialContext = (&net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	tr.TLSHandshakeTimeout = timeout
+	tr.ResponseHeaderTimeout = timeout
+	return &http.Client{
+		Transport: tr,
+	}
+}
+
+type s3OssFactory struct{}
+
+func (f *s3OssFactory) Create(opt Option) (Oss, error) {
+	def := &s3Define{
+		Region:   s3DefaultRegion,
+		PartSize: s3DefaultPartSize,
+		Timeout:  s3DefaultTimeout,
+	}
+	if err := mapstructure.WeakDecode(map[string]interface{}(opt), def); err != nil {
+		return nil, fmt.Errorf("oss(s3) invalid option: %s", err)
+	}
+
+	if def.Bucket == "" {
+		return nil, fmt.Errorf("oss(s3) bucket is not specified")
+	}
+	if def.PartSize < s3MinPartSize {
+		return nil, fmt.Errorf("oss(s3) part_size must be at least %d", s3MinPartSize)
+	}
+	if def.Timeout <= 0 {
+		return nil, fmt.Errorf("oss(s3) timeout must be positive")
+	}
+	if def.Endpoint == "" {
+		def.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", def.Region)
+	}
+
+	endpoint, err := url.Parse(def.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("oss(s3) invalid endpoint: %s", err)
+	}
+	if (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("oss(s3) invalid endpoint %s", def.Endpoint)
+	}
+
+	out := &s3Oss{
+		endpoint:  endpoint,
+		bucket:    def.Bucket,
+		pathStyle: def.PathStyle,
+		partSize:  def.PartSize,
+		client:    s3Client(time.Duration(def.Timeout) * time.Second),
+	}
+	if def.AccessKey != "" {
+		out.signer = &util.SigV4{
+			AccessKey:    def.AccessKey,
+			SecretKey:    def.SecretKey,
+			SessionToken: def.SessionToken,
+			Region:       def.Region,
+			Service:      "s3",
+		}
+	}
+	return out, nil
+}
+
+func init() {
+	RegisterOSSFactory("s3", &s3OssFactory{})
+}
@@ -0,0 +1,288 @@
+package oss
+
+import (
+	"github.com/dianpeng/hi-doctor/util"
+
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// minimum S3 stand-in with path style addressing, verifies the signature and
+// pages the list by 2 keys
+type testS3 struct {
+	sync.Mutex
+	t         *testing.T
+	object    map[string]string
+	part      map[string]map[int]string
+	multipart int
+}
+
+func (s *testS3) verify(r *http.Request, body []byte) bool {
+	req, _ := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), nil)
+	for k, v := range r.Header {
+		req.Header[k] = v
+	}
+	now, _ := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+	signer := &util.SigV4{
+		AccessKey: "ak",
+		SecretKey: "sk",
+		Region:    "us-east-1",
+		Service:   "s3",
+	}
+	signer.Sign(req, util.SigV4PayloadHash(body), now)
+	return req.Header.Get("Authorization") == r.Header.Get("Authorization") &&
+		req.URL.RawPath == r.URL.EscapedPath()
+}
+
+func (s *testS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	if !s.verify(r, body) {
+		w.WriteHeader(403)
+		w.Write([]byte("<Error><Code>SignatureDoesNotMatch</Code></Error>"))
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+	q := r.URL.Query()
+
+	switch {
+	case r.Method == "GET" && key == "":
+		keys := []string{}
+		for k := range s.object {
+			if strings.HasPrefix(k, q.Get("prefix")) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		start, _ := strconv.Atoi(q.Get("continuation-token"))
+		max, _ := strconv.Atoi(q.Get("max-keys"))
+		if max > 2 {
+			max = 2
+		}
+		end := start + max
+		if end > len(keys) {
+			end = len(keys)
+		}
+		out := "<ListBucketResult>"
+		for _, k := range keys[start:end] {
+			out += "<Contents><Key>" + k + "</Key></Contents>"
+		}
+		if end < len(keys) {
+			out += fmt.Sprintf("<IsTruncated>true</IsTruncated><NextContinuationToken>%d</NextContinuationToken>", end)
+		}
+		w.Write([]byte(out + "</ListBucketResult>"))
+
+	case r.Method == "GET" || r.Method == "HEAD":
+		if v, ok := s.object[key]; ok {
+			w.Header().Set("Last-Modified", "Fri, 24 May 2013 00:00:00 GMT")
+			w.Write([]byte(v))
+		} else {
+			w.WriteHeader(404)
+			w.Write([]byte("<Error><Code>NoSuchKey</Code><Message>missing</Message></Error>"))
+		}
+
+	case r.Method == "PUT" && q.Has("uploadId"):
+		n, _ := strconv.Atoi(q.Get("partNumber"))
+		s.part[q.Get("uploadId")][n] = string(body)
+		w.Header().Set("ETag", fmt.Sprintf("\"etag-%d\"", n))
+
+	case r.Method == "PUT" && r.Header.Get("X-Amz-Copy-Source") != "":
+		src, _ := url.PathUnescape(strings.TrimPrefix(r.Header.Get("X-Amz-Copy-Source"), "/bucket/"))
+		if v, ok := s.object[src]; ok {
+			s.object[key] = v
+			w.Write([]byte("<CopyObjectResult></CopyObjectResult>"))
+		} else {
+			w.Write([]byte("<Error><Code>NoSuchKey</Code></Error>"))
+		}
+
+	case r.Method == "PUT":
+		s.object[key] = string(body)
+
+	case r.Method == "POST" && q.Has("uploads"):
+		s.multipart++
+		id := fmt.Sprintf("upload-%d", s.multipart)
+		s.part[id] = map[int]string{}
+		w.Write([]byte("<InitiateMultipartUploadResult><UploadId>" + id + "</UploadId></InitiateMultipartUploadResult>"))
+
+	case r.Method == "POST" && q.Has("uploadId"):
+		c := s3CompleteMultipart{}
+		xml.Unmarshal(body, &c)
+		data := ""
+		for i, p := range c.Part {
+			if p.PartNumber != i+1 || p.ETag != fmt.Sprintf("\"etag-%d\"", i+1) {
+				w.Write([]byte("<Error><Code>InvalidPart</Code></Error>"))
+				return
+			}
+			data += s.part[q.Get("uploadId")][p.PartNumber]
+		}
+		s.object[key] = data
+		w.Write([]byte("<CompleteMultipartUploadResult></CompleteMultipartUploadResult>"))
+
+	case r.Method == "DELETE":
+		if _, ok := s.object[key]; !ok {
+			w.WriteHeader(404)
+			return
+		}
+		delete(s.object, key)
+		w.WriteHeader(204)
+	}
+}
+
+func TestS3Oss(t *testing.T) {
+	s3 := &testS3{
+		t:      t,
+		object: map[string]string{},
+		part:   map[string]map[int]string{},
+	}
+	server := httptest.NewServer(s3)
+	defer server.Close()
+
+	cli, err := GetOSSFactory("s3").Create(Option{
+		"endpoint":   server.URL,
+		"bucket":     "bucket",
+		"access_key": "ak",
+		"secret_key": "sk",
+		"path_style": true,
+	})
+	if err != nil {
+		t.Fatalf("create failed: %s", err)
+	}
+	ctx := context.Background()
+
+	// below the minimum of the option, so multipart is used for tiny objects
+	cli.(*s3Oss).partSize = 4
+
+	objs := map[string]string{
+		"/dir/a b.txt":  "small",
+		"dir/big+1.txt": "0123456789",
+		"dir/c.txt":     "1234",
+		"other":         "",
+	}
+	for k, v := range objs {
+		if err := cli.Put(ctx, k, strings.NewReader(v), int64(len(v))); err != nil {
+			t.Fatalf("put %s failed: %s", k, err)
+		}
+	}
+	if s3.multipart != 2 || s3.object["dir/big+1.txt"] != "0123456789" {
+		t.Fatalf("large object should be uploaded with multipart: %d", s3.multipart)
+	}
+	if err := cli.Put(ctx, "bad", strings.NewReader("0123456789"), 3); err == nil {
+		t.Fatalf("put with wrong size should fail")
+	}
+
+	obj, err := cli.Get(ctx, "/dir/a b.txt")
+	if err != nil {
+		t.Fatalf("get failed: %s", err)
+	}
+	data, _ := io.ReadAll(obj.Reader())
+	obj.Reader().Close()
+	if string(data) != "small" || obj.Size() != 5 || obj.ModifyTime().Year() != 2013 {
+		t.Fatalf("unexpected object: %s", data)
+	}
+	if obj, err := cli.Head(ctx, "dir/big+1.txt"); err != nil || obj.Size() != 10 {
+		t.Fatalf("head failed: %v", err)
+	}
+	if err := cli.Copy(ctx, "/dir/a b.txt", "copy/a b.txt"); err != nil || s3.object["copy/a b.txt"] != "small" {
+		t.Fatalf("copy failed: %v", err)
+	}
+	if err := cli.Copy(ctx, "missing", "copy/missing"); err == nil {
+		t.Fatalf("copy missing object should fail")
+	}
+	if _, err := cli.Get(ctx, "missing"); err == nil || !strings.Contains(err.Error(), "NoSuchKey") {
+		t.Fatalf("get missing object should fail with NoSuchKey: %v", err)
+	}
+
+	if l, err := cli.List(ctx, "/dir/", 0); err != nil ||
+		!reflect.DeepEqual(l, []string{"dir/a b.txt", "dir/big+1.txt", "dir/c.txt"}) {
+		t.Fatalf("unexpected list: %v %v", l, err)
+	}
+	if l, _ := cli.List(ctx, "", 3); len(l) != 3 {
+		t.Fatalf("list should be limited: %v", l)
+	}
+
+	if err := cli.Del(ctx, "dir/c.txt"); err != nil {
+		t.Fatalf("del failed: %s", err)
+	}
+	if err := cli.Del(ctx, "dir/c.txt"); err != nil {
+		t.Fatalf("del is not idempotent: %s", err)
+	}
+}
+
+func TestS3OssPartSize(t *testing.T) {
+	for _, x := range []struct {
+		size string
+		ok   bool
+	}{
+		{"4", false},
+		{"5242879", false},
+		{"5242880", true},
+	} {
+		_, err := GetOSSFactory("s3").Create(Option{
+			"bucket":    "bucket",
+			"part_size": x.size,
+		})
+		if got := err == nil; got != x.ok {
+			t.Errorf("part_size %s, create succeeded: got %t, want %t, error: %v", x.size, got, x.ok, err)
+		}
+	}
+}
+
+func TestS3OssCanceled(t *testing.T) {
+	block := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer server.Close()
+	defer close(block)
+
+	cli, err := GetOSSFactory("s3").Create(Option{
+		"endpoint":   server.URL,
+		"bucket":     "bucket",
+		"path_style": true,
+	})
+	if err != nil {
+		t.Fatalf("create failed: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := cli.Get(ctx, "a"); err == nil {
+		t.Errorf("get with canceled context: got no error, want error")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("get with canceled context: got %s, want aborted at once", d)
+	}
+}
+
+func TestS3OssURL(t *testing.T) {
+	cli, err := GetOSSFactory("s3").Create(Option{
+		"region": "eu-west-1",
+		"bucket": "bucket",
+	})
+	if err != nil {
+		t.Fatalf("create failed: %s", err)
+	}
+	s := cli.(*s3Oss)
+	if u := s.url("a/b", nil).String(); u != "https://bucket.s3.eu-west-1.amazonaws.com/a/b" {
+		t.Fatalf("unexpected virtual host url: %s", u)
+	}
+	if s.signer != nil {
+		t.Fatalf("empty access key should be anonymous")
+	}
+}
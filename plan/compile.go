@@ -1,14 +1,18 @@
 package plan
 
 import (
+	"github.com/dianpeng/hi-doctor/check"
 	"github.com/dianpeng/hi-doctor/dvar"
 	"github.com/dianpeng/hi-doctor/fetch"
 	"github.com/dianpeng/hi-doctor/metrics"
 	"github.com/dianpeng/hi-doctor/spec"
 	"github.com/dianpeng/hi-doctor/task"
+	"github.com/dianpeng/hi-doctor/try"
 
 	"fmt"
+	"regexp"
 	"strings"
+	"time"
 )
 
 // compile a spec model to an internal representation, ie plan object
@@ -166,6 +170,11 @@ func (c *compiler) compileTarget() error {
 // ----------------------------------------------------------------------------
 // Schedule
 func (c *compiler) compileScheduler() error {
+	if c.model.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+	c.output.Timeout = time.Duration(c.model.Timeout) * time.Second
+
 	if dv, err := dvar.NewDVarScriptContext(c.model.Scheduler); err != nil {
 		return err
 	} else {
@@ -174,15 +183,134 @@ func (c *compiler) compileScheduler() error {
 	}
 }
 
+// ----------------------------------------------------------------------------
+// OnError
+func compileOnError(x string, def int) (int, error) {
+	switch x {
+	case "":
+		return def, nil
+	case "abort":
+		return OnErrorAbort, nil
+	case "skip_task":
+		return OnErrorSkipTask, nil
+	case "skip_batch":
+		return OnErrorSkipBatch, nil
+	case "continue":
+		return OnErrorContinue, nil
+	default:
+		return def, fmt.Errorf("on_error %s is unknown", x)
+	}
+}
+
+func (c *compiler) compileJobOnError() error {
+	if v, err := compileOnError(c.model.OnError, OnErrorAbort); err != nil {
+		return err
+	} else {
+		c.output.OnError = v
+		return nil
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Proxy
+func (c *compiler) compileProxy() error {
+	if dv, err := dvar.NewDVarStringContext(c.model.Proxy); err != nil {
+		return fmt.Errorf("proxy compile failed: %s", err)
+	} else {
+		c.output.Proxy = dv
+		return nil
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Retry
+func (c *compiler) compileRetry(
+	r *spec.Retry,
+	checkModel *spec.Check,
+) (*Retry, error) {
+	out := &Retry{}
+
+	switch r.Mode {
+	case "", "at_most":
+		out.Opt.Type = try.TryAtMost
+		break
+	case "exact":
+		out.Opt.Type = try.TryExact
+		break
+	default:
+		return nil, fmt.Errorf("retry.mode %s is unknown", r.Mode)
+	}
+
+	switch r.Backoff {
+	case "", "fixed":
+		out.Opt.Backoff.Type = try.BackoffFixed
+		break
+	case "exponential":
+		out.Opt.Backoff.Type = try.BackoffExponential
+		break
+	default:
+		return nil, fmt.Errorf("retry.backoff %s is unknown", r.Backoff)
+	}
+
+	if r.Count <= 0 {
+		return nil, fmt.Errorf("retry.count must be positive")
+	}
+	if r.Jitter < 0 || r.Jitter > 1 {
+		return nil, fmt.Errorf("retry.jitter must be in range [0, 1]")
+	}
+
+	out.Opt.TryCount = r.Count
+	out.Opt.Threshold = r.Threshold
+	out.Opt.Backoff.Interval = time.Duration(r.Interval) * time.Millisecond
+	out.Opt.Backoff.MaxInterval = time.Duration(r.MaxInterval) * time.Millisecond
+	out.Opt.Backoff.Jitter = r.Jitter
+
+	// without retry_on, only the error of the task is treated as failure
+	retryOn := r.RetryOn
+	if retryOn == "" {
+		retryOn = "false"
+	}
+	if dv, err := dvar.NewDVarScriptContext(retryOn); err != nil {
+		return nil, fmt.Errorf("retry.retry_on compile failed: %s", err)
+	} else {
+		out.RetryOn = dv
+	}
+
+	if ck, err := check.CompileCheck(checkModel); err != nil {
+		return nil, err
+	} else {
+		out.Check = ck
+	}
+	return out, nil
+}
+
 // ----------------------------------------------------------------------------
 // TaskList
+var taskIdPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
 func (c *compiler) compileTaskList() error {
+	ids := make(map[string]int)
+
 	for i, tany := range c.model.Task {
 		factory := task.GetTaskFactory(tany.Type)
 		if factory == nil {
 			return fmt.Errorf("task[%d] type %s unknown to us", i, tany.Type)
 		}
-		taskPlanner, err := factory.Compile(tany.Option, tany.Check)
+
+		// if the task has retry policy, the check is owned by the retry, since it
+		// should only run against the results of the last try
+		var retry *Retry
+		checkModel := tany.Check
+		if tany.Retry != nil {
+			r, err := c.compileRetry(tany.Retry, tany.Check)
+			if err != nil {
+				return fmt.Errorf("task[%d(%s)].%s", i, tany.Type, err)
+			}
+			retry = r
+			checkModel = nil
+		}
+
+		taskPlanner, err := factory.Compile(tany.Option, checkModel)
 		if err != nil {
 			return fmt.Errorf("task[%d(%s)] cannot be created: %s", i, tany.Type, err)
 		}
@@ -192,11 +320,98 @@ func (c *compiler) compileTaskList() error {
 			return fmt.Errorf("task[%d(%s)].guard cannot be created: %s", i, tany.Type, err)
 		}
 
+		if tany.Timeout < 0 {
+			return fmt.Errorf("task[%d(%s)].timeout must not be negative", i, tany.Type)
+		}
+
+		// id must be a valid identifier, since it is accessed as tasks.<id>
+		if tany.Id != "" {
+			if !taskIdPattern.MatchString(tany.Id) {
+				return fmt.Errorf("task[%d(%s)].id %s is not a valid identifier", i, tany.Type, tany.Id)
+			}
+			if _, ok := ids[tany.Id]; ok {
+				return fmt.Errorf("task[%d(%s)].id %s is duplicated", i, tany.Type, tany.Id)
+			}
+			ids[tany.Id] = i
+		}
+
+		onError, err := compileOnError(tany.OnError, c.output.OnError)
+		if err != nil {
+			return fmt.Errorf("task[%d(%s)].%s", i, tany.Type, err)
+		}
+
+		runIf := tany.RunIf
+		if runIf == "" {
+			runIf = "true"
+		}
+		runIfDVar, err := dvar.NewDVarScriptContext(runIf)
+		if err != nil {
+			return fmt.Errorf("task[%d(%s)].run_if cannot be created: %s", i, tany.Type, err)
+		}
+
 		c.output.TaskPlannerList = append(c.output.TaskPlannerList, TaskPlannerItem{
+			Id:      tany.Id,
 			Guard:   guard,
 			Planner: taskPlanner,
+			Retry:   retry,
+			Timeout: time.Duration(tany.Timeout) * time.Second,
+			OnError: onError,
+			RunIf:   runIfDVar,
 		})
 	}
+
+	return c.compileTaskGraph(ids)
+}
+
+// resolve the depends_on of each task and make sure the tasks form a DAG
+func (c *compiler) compileTaskGraph(ids map[string]int) error {
+	list := c.output.TaskPlannerList
+
+	for i, tany := range c.model.Task {
+		for _, dep := range tany.DependsOn {
+			idx, ok := ids[dep]
+			if !ok {
+				return fmt.Errorf("task[%d(%s)].depends_on %s is unknown", i, tany.Type, dep)
+			}
+			if idx == i {
+				return fmt.Errorf("task[%d(%s)].depends_on itself", i, tany.Type)
+			}
+			list[i].DependsOn = append(list[i].DependsOn, idx)
+			c.output.TaskGraph = true
+		}
+	}
+
+	// cycle detection, via dfs
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(list))
+
+	var visit func(int) error
+	visit = func(i int) error {
+		switch state[i] {
+		case visiting:
+			return fmt.Errorf("task[%d(%s)].depends_on forms a cycle", i, c.model.Task[i].Type)
+		case visited:
+			return nil
+		}
+		state[i] = visiting
+		for _, dep := range list[i].DependsOn {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		state[i] = visited
+		return nil
+	}
+
+	for i := range list {
+		if err := visit(i); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -263,6 +478,14 @@ func (c *compiler) compile() error {
 		return err
 	}
 
+	if err := c.compileJobOnError(); err != nil {
+		return err
+	}
+
+	if err := c.compileProxy(); err != nil {
+		return err
+	}
+
 	if err := c.compileTaskList(); err != nil {
 		return err
 	}
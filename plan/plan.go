@@ -1,13 +1,16 @@
 package plan
 
 import (
+	"github.com/dianpeng/hi-doctor/check"
 	"github.com/dianpeng/hi-doctor/dvar"
 	"github.com/dianpeng/hi-doctor/fetch"
 	"github.com/dianpeng/hi-doctor/metrics"
 	"github.com/dianpeng/hi-doctor/spec"
 	"github.com/dianpeng/hi-doctor/task"
 	"github.com/dianpeng/hi-doctor/trigger"
+	"github.com/dianpeng/hi-doctor/try"
 
+	"context"
 	"fmt"
 	"time"
 )
@@ -22,8 +25,49 @@ type Target struct {
 }
 
 type TaskPlannerItem struct {
+	Id      string           // id of the task, empty if not specified
 	Guard   dvar.DVar        // guard of this task
 	Planner task.TaskPlanner // task planner
+	Retry   *Retry           // retry policy, nil if not retry at all
+	Timeout time.Duration    // timeout of each task, 0 means no timeout
+	OnError int              // what to do when the task fails
+
+	DependsOn []int     // index of the upstream task planner in the list
+	RunIf     dvar.DVar // evaluated right before the task runs
+}
+
+// Policy when a task fails, ie its Prepare or Run returns an error
+const (
+	// abort the whole run, the error is the run's failure
+	OnErrorAbort = iota
+
+	// skip the rest of tasks generated by the same task define for the target
+	OnErrorSkipTask
+
+	// skip the rest of the batch, ie the rest of tasks of the target
+	OnErrorSkipBatch
+
+	// just move on to the next task
+	OnErrorContinue
+)
+
+func OnErrorName(x int) string {
+	switch x {
+	case OnErrorSkipTask:
+		return "skip_task"
+	case OnErrorSkipBatch:
+		return "skip_batch"
+	case OnErrorContinue:
+		return "continue"
+	default:
+		return "abort"
+	}
+}
+
+type Retry struct {
+	Opt     try.TryOpt  // retry policy
+	RetryOn dvar.DVar   // evaluated after each try, true means try failed
+	Check   check.Check // check of the task, only runs after the last try
 }
 
 type TaskPlannerList []TaskPlannerItem
@@ -40,11 +84,30 @@ type Info struct {
 	// into an array
 }
 
+// Failure of a single task which does not abort the run
+type RunFailure struct {
+	BatchIndex int64  `json:"batch_index"`
+	TaskIndex  int64  `json:"task_index"`
+	Target     string `json:"target"`
+	Task       string `json:"task"`
+	Error      string `json:"error"`
+}
+
+// Summary of a single run, ie how many tasks have been run and which failed
+type RunSummary struct {
+	BatchCount   int64        `json:"batch_count"`
+	TaskCount    int64        `json:"task_count"`    // # of tasks that have been run
+	SkippedTask  int64        `json:"skipped_task"`  // # of tasks skipped due to failure
+	FailureCount int64        `json:"failure_count"` // # of failed tasks
+	Failure      []RunFailure `json:"failure"`
+}
+
 type ExecuteInfo struct {
-	LastExecute  string `json:"last_execute"`
-	LastDuration string `json:"last_duration"`
-	LastError    error  `json:"last_error"`
-	ExecuteTimes uint64 `json:"execute_times"`
+	LastExecute  string     `json:"last_execute"`
+	LastDuration string     `json:"last_duration"`
+	LastError    error      `json:"last_error"`
+	LastSummary  RunSummary `json:"last_summary"`
+	ExecuteTimes uint64     `json:"execute_times"`
 
 	lastDuration time.Duration
 	lastExecute  time.Time
@@ -64,7 +127,11 @@ type Plan struct {
 	Trigger         dvar.DVar             `json:"-"`       // trigger of the plan
 	Target          Target                `json:"-"`       // target of the plan
 	Scheduler       dvar.DVar             `json:"-"`       // scheduler
+	Timeout         time.Duration         `json:"-"`       // timeout of each run
+	OnError         int                   `json:"-"`       // default on_error policy of tasks
+	Proxy           dvar.DVar             `json:"-"`       // proxy of http requests
 	TaskPlannerList TaskPlannerList       `json:"-"`       // list of task planner
+	TaskGraph       bool                  `json:"-"`       // tasks of a batch run as DAG
 	Finally         dvar.CodeBlock        `json:"-"`       // finally block of plan
 
 	// Filled by the runtime
@@ -72,15 +139,23 @@ type Plan struct {
 
 	isStopped bool
 	cronId    trigger.CronId
+
+	// cancelled when the plan is stopped, any in-flight execution derives its
+	// context from it
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 func newPlan() *Plan {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Plan{
 		Name:    "",
 		Comment: "",
 		Storage: make(varMap),
 		Global:  make(varMap),
 		Local:   make(varMap),
+		ctx:     ctx,
+		cancel:  cancel,
 	}
 }
 
@@ -99,13 +174,21 @@ func Compile(m *spec.Model) (*Plan, error) {
 
 func (p *Plan) Stop() {
 	p.isStopped = true
+	p.cancel() // abort in-flight execution, if any
 	if p.cronId >= 0 {
 		trigger.Remove(p.cronId)
-		p.Metrics.Stop()
+		if p.Metrics != nil {
+			p.Metrics.Stop()
+		}
 		p.cronId = -1
 	}
 }
 
+// Context of the plan, it is done once the plan is stopped
+func (p *Plan) Context() context.Context {
+	return p.ctx
+}
+
 func (p *Plan) SetCronId(cid trigger.CronId) {
 	p.cronId = cid
 }
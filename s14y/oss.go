@@ -4,6 +4,7 @@ import (
 	"github.com/dianpeng/hi-doctor/oss"
 	"github.com/dianpeng/hi-doctor/run"
 
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 
@@ -18,8 +19,8 @@ type ossSd struct {
 	client oss.Oss
 }
 
-func (f *ossSd) readObj(path string) (string, error) {
-	body, err := f.client.Get(path)
+func (f *ossSd) readObj(ctx context.Context, path string) (string, error) {
+	body, err := f.client.Get(ctx, path)
 	if err != nil {
 		return "", err
 	}
@@ -40,7 +41,8 @@ func (f *ossSd) md5(data string) string {
 }
 
 func (f *ossSd) Refresh(currentJ []Job) []NewJob {
-	keyList, err := f.client.List(f.prefix, 10000)
+	ctx := context.Background()
+	keyList, err := f.client.List(ctx, f.prefix, 10000)
 	if err != nil {
 		return nil
 	}
@@ -49,7 +51,7 @@ func (f *ossSd) Refresh(currentJ []Job) []NewJob {
 	for _, entry := range keyList {
 		ext := path.Ext(entry)
 		if ext == ".yaml" {
-			data, err := f.readObj(entry)
+			data, err := f.readObj(ctx, entry)
 			if err != nil {
 				continue
 			}
@@ -21,10 +21,32 @@ type Task []*TaskAny
 type TaskOption map[string]interface{}
 
 type TaskAny struct {
-	Guard  string     `yaml:"string"`
-	Type   string     `yaml:"type"`
-	Option TaskOption `yaml:"option"`
-	Check  *Check     `yaml:"check"`
+	Id      string     `yaml:"id"` // results are exposed as tasks.<id> as well
+	Guard   string     `yaml:"string"`
+	Type    string     `yaml:"type"`
+	Option  TaskOption `yaml:"option"`
+	Check   *Check     `yaml:"check"`
+	Retry   *Retry     `yaml:"retry"`
+	Timeout int64      `yaml:"timeout"`  // in seconds, 0 means no timeout
+	OnError string     `yaml:"on_error"` // inherits the job's if empty
+
+	// dependency within a batch, the task only starts after all the tasks of
+	// depends_on succeed and then run_if evaluates to true
+	DependsOn []string `yaml:"depends_on"`
+	RunIf     string   `yaml:"run_if"`
+}
+
+// Retry policy of a task. The task is tried until retry_on evaluates to false
+// or the count is exhausted, and the check only runs after the last try
+type Retry struct {
+	Mode        string  `yaml:"mode"`         // at_most(default) or exact
+	Count       int     `yaml:"count"`        // max # of tries
+	Threshold   int     `yaml:"threshold"`    // # of failed tries to abort
+	Backoff     string  `yaml:"backoff"`      // fixed(default) or exponential
+	Interval    int64   `yaml:"interval"`     // in milliseconds
+	MaxInterval int64   `yaml:"max_interval"` // in milliseconds
+	Jitter      float64 `yaml:"jitter"`       // ratio of interval, [0, 1]
+	RetryOn     string  `yaml:"retry_on"`     // expression, true means failed
 }
 
 type Check struct {
@@ -71,6 +93,9 @@ type Model struct {
 	Trigger   string   `yaml:"trigger"`
 	Target    *Target  `yaml:"target"`
 	Scheduler string   `yaml:"scheduler"`
+	Timeout   int64    `yaml:"timeout"`  // in seconds, 0 means no timeout
+	OnError   string   `yaml:"on_error"` // abort(default), skip_task, skip_batch or continue
+	Proxy     string   `yaml:"proxy"`    // proxy of http requests, target.proxy overrides it
 	Task      Task     `yaml:"task"`
 	Finally   []string `yaml:"finally"`
 	Info      Info
@@ -1,7 +1,12 @@
 package storage
 
+import (
+	"sync"
+)
+
 type booleanPrimitive struct {
-	v bool
+	mutex sync.Mutex
+	v     bool
 }
 
 func (b *booleanPrimitive) Type() string {
@@ -9,10 +14,16 @@ func (b *booleanPrimitive) Type() string {
 }
 
 func (b *booleanPrimitive) Get() interface{} {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
 	return b.v
 }
 
 func (b *booleanPrimitive) Set(x interface{}) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
 	vv, ok := x.(bool)
 	if ok {
 		b.v = vv
@@ -2,9 +2,12 @@ package storage
 
 import (
 	"github.com/dianpeng/hi-doctor/util"
+
+	"sync"
 )
 
 type intPrimitive struct {
+	mutex sync.Mutex
 	value int64
 }
 
@@ -17,10 +20,16 @@ func (i *intPrimitive) Type() string {
 }
 
 func (i *intPrimitive) Get() interface{} {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
 	return i.value
 }
 
 func (i *intPrimitive) Set(x interface{}) bool {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
 	val, ok := i.toInt(x)
 	if ok {
 		i.value = val
@@ -31,6 +40,9 @@ func (i *intPrimitive) Set(x interface{}) bool {
 }
 
 func (i *intPrimitive) CheckedAdd(value interface{}, threshold interface{}) bool {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
 	if val, ok := i.toInt(value); ok {
 		if thr, ok := i.toInt(threshold); ok {
 			newV := val + i.value
@@ -42,6 +54,9 @@ func (i *intPrimitive) CheckedAdd(value interface{}, threshold interface{}) bool
 }
 
 func (i *intPrimitive) CheckedSub(value interface{}, threshold interface{}) bool {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
 	if val, ok := i.toInt(value); ok {
 		if thr, ok := i.toInt(threshold); ok {
 			newV := val - i.value
@@ -2,6 +2,8 @@ package storage
 
 import (
 	"github.com/dianpeng/hi-doctor/util"
+
+	"sync"
 )
 
 const (
@@ -11,8 +13,9 @@ const (
 )
 
 type mapImpl struct {
-	ty int
-	m  map[string]Primitive
+	mutex sync.Mutex
+	ty    int
+	m     map[string]Primitive
 }
 
 func (m *mapImpl) Type() string {
@@ -30,6 +33,9 @@ func (m *mapImpl) Type() string {
 }
 
 func (m *mapImpl) Get(key string) Primitive {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
 	x, ok := m.m[key]
 	if !ok {
 		x = m.init()
@@ -65,6 +71,9 @@ func (m *mapImpl) newPrimitive(x interface{}) Primitive {
 }
 
 func (m *mapImpl) Set(key string, x interface{}) Primitive {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
 	val := m.newPrimitive(x)
 	m.m[key] = val
 	return val
@@ -2,9 +2,12 @@ package storage
 
 import (
 	"github.com/dianpeng/hi-doctor/util"
+
+	"sync"
 )
 
 type realPrimitive struct {
+	mutex sync.Mutex
 	value float64
 }
 
@@ -17,10 +20,16 @@ func (i *realPrimitive) Type() string {
 }
 
 func (i *realPrimitive) Get() interface{} {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
 	return i.value
 }
 
 func (i *realPrimitive) Set(x interface{}) bool {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
 	val, ok := i.toReal(x)
 	if ok {
 		i.value = val
@@ -31,6 +40,9 @@ func (i *realPrimitive) Set(x interface{}) bool {
 }
 
 func (i *realPrimitive) CheckedAdd(value interface{}, threshold interface{}) bool {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
 	if val, ok := i.toReal(value); ok {
 		if thr, ok := i.toReal(threshold); ok {
 			newV := val + i.value
@@ -42,6 +54,9 @@ func (i *realPrimitive) CheckedAdd(value interface{}, threshold interface{}) boo
 }
 
 func (i *realPrimitive) CheckedSub(value interface{}, threshold interface{}) bool {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
 	if val, ok := i.toReal(value); ok {
 		if thr, ok := i.toReal(threshold); ok {
 			newV := val - i.value
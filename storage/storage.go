@@ -4,6 +4,9 @@ package storage
 // object internally will just be a interface and this allow us to easily
 // work with expression engine. The only downside is that the user has to
 // call method to get its actual value out.
+//
+// Storage objects are shared by all the batches of a job, which may run in
+// parallel, so every implementation must be safe for concurrent use.
 
 type Storage interface {
 	Type() string
@@ -0,0 +1,76 @@
+package task
+
+import (
+	"context"
+	"sync"
+)
+
+// Resources shared by the tasks of the same job, ie the connection pools, so
+// they can be reused across the tasks, the targets and the runs. It is carried
+// by the context passed to the task, and released when the job is stopped
+type Shared struct {
+	sync.Mutex
+	item     map[string]sharedItem
+	released bool
+}
+
+type sharedItem struct {
+	value   interface{}
+	release func()
+}
+
+type sharedKey struct{}
+
+func NewShared() *Shared {
+	return &Shared{
+		item: make(map[string]sharedItem),
+	}
+}
+
+func WithShared(ctx context.Context, s *Shared) context.Context {
+	return context.WithValue(ctx, sharedKey{}, s)
+}
+
+// the shared resources of the job, nil if the context does not carry any
+func GetShared(ctx context.Context) *Shared {
+	s, _ := ctx.Value(sharedKey{}).(*Shared)
+	return s
+}
+
+// get the resource of the key, create it if not existed. The release function
+// is called when the shared resources are released. The resource created after
+// the release is not kept, the caller owns it then and ok is false
+func (s *Shared) Get(
+	key string,
+	create func() (interface{}, func()),
+) (value interface{}, ok bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	if item, ok := s.item[key]; ok {
+		return item.value, true
+	}
+	v, release := create()
+	if s.released {
+		return v, false
+	}
+	s.item[key] = sharedItem{
+		value:   v,
+		release: release,
+	}
+	return v, true
+}
+
+// release all the resources, called when the job is stopped
+func (s *Shared) Release() {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, item := range s.item {
+		if item.release != nil {
+			item.release()
+		}
+	}
+	s.item = make(map[string]sharedItem)
+	s.released = true
+}
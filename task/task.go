@@ -3,17 +3,22 @@ package task
 import (
 	"github.com/dianpeng/hi-doctor/dvar"
 	"github.com/dianpeng/hi-doctor/spec"
+
+	"context"
 )
 
 type Task interface {
 	// Run this phase's preparation phase. This is a logic step and mostly each
 	// phase should not be awared of this
-	Prepare(*dvar.EvalEnv) error
+	Prepare(context.Context, *dvar.EvalEnv) error
 
 	// For each phase, all the observable effects will be stored by global variable
 	// inside of the EvalEnv. User is expected to know this and we do not allow
-	// customized binding, ie local variables
-	Run(*dvar.EvalEnv) error
+	// customized binding, ie local variables.
+	//
+	// The context is cancelled when the job is stopped or the task/job timeout
+	// is reached, any blocking operation should be aborted accordingly
+	Run(context.Context, *dvar.EvalEnv) error
 
 	// description of this Task
 	Description() string
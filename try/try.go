@@ -1,5 +1,10 @@
 package try
 
+import (
+	"math/rand"
+	"time"
+)
+
 // Try defines a general way for trying certain inspection task.
 
 const (
@@ -13,8 +18,69 @@ const (
 	TryAtMost
 )
 
+const (
+	// Wait the same interval between each try
+	BackoffFixed = iota
+
+	// Double the interval after each try, capped by the max interval
+	BackoffExponential
+)
+
+type Backoff struct {
+	Type        int
+	Interval    time.Duration
+	MaxInterval time.Duration
+	Jitter      float64 // ratio of the interval, [0, 1]
+}
+
 type TryOpt struct {
 	Type      int
 	TryCount  int
-	Threshold int
+	Threshold int // # of failure to abort, 0 means no threshold
+	Backoff   Backoff
+}
+
+// Whether another try should be issued. The attempt is the # of tries have
+// been done so far, failure is the # of failed tries among them and lastFailed
+// indicates whether the latest try failed or not
+func (t *TryOpt) Next(attempt, failure int, lastFailed bool) bool {
+	if t.Threshold > 0 && failure >= t.Threshold {
+		return false
+	}
+	if attempt >= t.TryCount {
+		return false
+	}
+
+	switch t.Type {
+	case TryExact:
+		return true
+	default:
+		return lastFailed
+	}
+}
+
+// The duration to wait before the next try, attempt is the # of tries have
+// been done so far, ie starts from 1
+func (b *Backoff) Delay(attempt int) time.Duration {
+	d := b.Interval
+	if b.Type == BackoffExponential {
+		for i := 1; i < attempt; i++ {
+			d = d * 2
+			if b.MaxInterval > 0 && d >= b.MaxInterval {
+				break
+			}
+		}
+	}
+	if b.MaxInterval > 0 && d > b.MaxInterval {
+		d = b.MaxInterval
+	}
+
+	if b.Jitter > 0 && d > 0 {
+		delta := float64(d) * b.Jitter
+		d = d + time.Duration((rand.Float64()*2-1)*delta)
+		if d < 0 {
+			d = 0
+		}
+	}
+	return d
 }
@@ -0,0 +1,81 @@
+package try
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTryNext(t *testing.T) {
+	{
+		opt := TryOpt{
+			Type:     TryAtMost,
+			TryCount: 3,
+		}
+		if !opt.Next(1, 1, true) {
+			t.Fatalf("failed try should be retried")
+		}
+		if opt.Next(1, 0, false) {
+			t.Fatalf("succeeded try should not be retried")
+		}
+		if opt.Next(3, 3, true) {
+			t.Fatalf("try count should be honored")
+		}
+	}
+	{
+		opt := TryOpt{
+			Type:      TryExact,
+			TryCount:  5,
+			Threshold: 2,
+		}
+		if !opt.Next(1, 0, false) {
+			t.Fatalf("exact try should always be retried")
+		}
+		if opt.Next(3, 2, true) {
+			t.Fatalf("threshold should abort the try")
+		}
+		if opt.Next(5, 0, false) {
+			t.Fatalf("try count should be honored")
+		}
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	{
+		b := Backoff{
+			Type:     BackoffFixed,
+			Interval: 100 * time.Millisecond,
+		}
+		if b.Delay(1) != 100*time.Millisecond || b.Delay(5) != 100*time.Millisecond {
+			t.Fatalf("fixed backoff should not grow")
+		}
+	}
+	{
+		b := Backoff{
+			Type:        BackoffExponential,
+			Interval:    100 * time.Millisecond,
+			MaxInterval: time.Second,
+		}
+		if b.Delay(1) != 100*time.Millisecond {
+			t.Fatalf("invalid first delay")
+		}
+		if b.Delay(3) != 400*time.Millisecond {
+			t.Fatalf("invalid exponential delay")
+		}
+		if b.Delay(10) != time.Second {
+			t.Fatalf("delay should be capped")
+		}
+	}
+	{
+		b := Backoff{
+			Type:     BackoffFixed,
+			Interval: 100 * time.Millisecond,
+			Jitter:   0.5,
+		}
+		for i := 0; i < 100; i++ {
+			d := b.Delay(1)
+			if d < 50*time.Millisecond || d > 150*time.Millisecond {
+				t.Fatalf("jitter out of range: %s", d)
+			}
+		}
+	}
+}
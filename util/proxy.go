@@ -0,0 +1,31 @@
+package util
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// ProxyDirect means no proxy at all, even if the job or the target has one
+const ProxyDirect = "direct"
+
+// ParseProxy parses the proxy url, the scheme must be http, https, socks5 or
+// socks5h. An empty string or direct means no proxy, nil is returned
+func ParseProxy(x string) (*url.URL, error) {
+	if x == "" || x == ProxyDirect {
+		return nil, nil
+	}
+	u, err := url.Parse(x)
+	if err != nil {
+		return nil, fmt.Errorf("proxy is invalid: %s", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+		break
+	default:
+		return nil, fmt.Errorf("proxy scheme %s is unknown, must be http, https, socks5 or socks5h", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("proxy %s does not have host", u.Redacted())
+	}
+	return u, nil
+}
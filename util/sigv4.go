@@ -0,0 +1,193 @@
+package util
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AWS signature version 4, used by S3, most of the S3 compatible object
+// storage and the other AWS services. The request is signed in place, ie the Authorization header and
+// the x-amz-* headers are added, and the URL is normalized into its canonical
+// form, so what is sent is exactly what is signed.
+
+const (
+	SigV4Algorithm       = "AWS4-HMAC-SHA256"
+	SigV4UnsignedPayload = "UNSIGNED-PAYLOAD"
+
+	sigV4DateFormat = "20060102T150405Z"
+)
+
+type SigV4 struct {
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	Region       string
+	Service      string
+}
+
+func SigV4PayloadHash(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+// headers never signed, they may be modified by the proxy or the transport
+var sigV4SkipHeader = map[string]bool{
+	"authorization":   true,
+	"user-agent":      true,
+	"content-length":  true,
+	"accept-encoding": true,
+	"connection":      true,
+}
+
+func (s *SigV4) Sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format(sigV4DateFormat)
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	// canonical URI and query, written back to the URL
+	uri := sigV4Escape(req.URL.Path, false)
+	if uri == "" {
+		uri = "/"
+	}
+	req.URL.RawPath = uri
+	canonicalURI := sigV4CanonicalURI(uri, s.Service)
+	query := sigV4CanonicalQuery(req)
+	req.URL.RawQuery = query
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	// canonical headers
+	hdr := map[string]string{
+		"host": host,
+	}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if sigV4SkipHeader[lk] {
+			continue
+		}
+		vv := make([]string, 0, len(v))
+		for _, x := range v {
+			vv = append(vv, strings.Join(strings.Fields(x), " "))
+		}
+		hdr[lk] = strings.Join(vv, ",")
+	}
+	names := make([]string, 0, len(hdr))
+	for k := range hdr {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	canonicalHeader := strings.Builder{}
+	for _, k := range names {
+		canonicalHeader.WriteString(k)
+		canonicalHeader.WriteString(":")
+		canonicalHeader.WriteString(hdr[k])
+		canonicalHeader.WriteString("\n")
+	}
+	signedHeader := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI,
+		query,
+		canonicalHeader.String(),
+		signedHeader,
+		payloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", date, s.Region, s.Service)
+	stringToSign := strings.Join([]string{
+		SigV4Algorithm,
+		amzDate,
+		scope,
+		SigV4PayloadHash([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := sigV4Hmac([]byte("AWS4"+s.SecretKey), date)
+	key = sigV4Hmac(key, s.Region)
+	key = sigV4Hmac(key, s.Service)
+	key = sigV4Hmac(key, "aws4_request")
+	signature := hex.EncodeToString(sigV4Hmac(key, stringToSign))
+
+	req.Header.Set(
+		"Authorization",
+		fmt.Sprintf(
+			"%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+			SigV4Algorithm,
+			s.AccessKey,
+			scope,
+			signedHeader,
+			signature,
+		),
+	)
+}
+
+func sigV4Hmac(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// S3 signs the path as it is sent, while the other services sign the path
+// encoded once more, ie a space is sent as %20 and signed as %2520
+func sigV4CanonicalURI(uri string, service string) string {
+	if service == "s3" {
+		return uri
+	}
+	return sigV4Escape(uri, false)
+}
+
+func sigV4CanonicalQuery(req *http.Request) string {
+	q := req.URL.Query()
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	out := []string{}
+	for _, k := range keys {
+		v := append([]string{}, q[k]...)
+		sort.Strings(v)
+		for _, x := range v {
+			out = append(out, sigV4Escape(k, true)+"="+sigV4Escape(x, true))
+		}
+	}
+	return strings.Join(out, "&")
+}
+
+// everything except the unreserved characters is percent encoded, the slash
+// is kept as is unless it is part of the query
+func sigV4Escape(x string, slash bool) string {
+	const hex = "0123456789ABCDEF"
+	b := strings.Builder{}
+	for i := 0; i < len(x); i++ {
+		c := x[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !slash:
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&15])
+		}
+	}
+	return b.String()
+}
@@ -2,6 +2,10 @@ package util
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
 )
 
 func GetTLSVersionName(v uint16) string {
@@ -69,6 +73,57 @@ func GetTLSCipherSuitesName(v uint16) string {
 	case tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256:
 		return "tls-ecdhe-ecdsa-with-chacha20-poly1305-sha256"
 	default:
-		return "unknown"
+		// tls-1.3 cipher suites and anything newer, just derive from go's name
+		name := tls.CipherSuiteName(v)
+		if strings.HasPrefix(name, "0x") {
+			return "unknown"
+		}
+		return strings.ToLower(strings.ReplaceAll(name, "_", "-"))
+	}
+}
+
+// Reverse of GetTLSVersionName, also accepts the short form, ie "1.2"
+func GetTLSVersionByName(name string) (uint16, bool) {
+	switch strings.ToLower(name) {
+	case "tls-1.0", "tls1.0", "1.0":
+		return tls.VersionTLS10, true
+	case "tls-1.1", "tls1.1", "1.1":
+		return tls.VersionTLS11, true
+	case "tls-1.2", "tls1.2", "1.2":
+		return tls.VersionTLS12, true
+	case "tls-1.3", "tls1.3", "1.3":
+		return tls.VersionTLS13, true
+	default:
+		return 0, false
+	}
+}
+
+// Look up cipher suite by name, both go's name, ie TLS_RSA_WITH_AES_128_CBC_SHA
+// and the name returned by GetTLSCipherSuitesName are accepted
+func GetTLSCipherSuiteByName(name string) (uint16, bool) {
+	norm := strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+	for _, x := range tls.CipherSuites() {
+		if x.Name == norm {
+			return x.ID, true
+		}
+	}
+	for _, x := range tls.InsecureCipherSuites() {
+		if x.Name == norm {
+			return x.ID, true
+		}
+	}
+	return 0, false
+}
+
+// Load a PEM encoded CA bundle from file
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no valid certificate found in %s", path)
 	}
+	return pool, nil
 }